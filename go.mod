@@ -4,13 +4,13 @@ go 1.20
 
 require (
 	github.com/bits-and-blooms/bloom/v3 v3.3.1
+	github.com/rs/zerolog v1.29.0
 	golang.org/x/exp v0.0.0-20230224173230-c95f2b4c22f2
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
-	github.com/rs/zerolog v1.29.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
 )
 
@@ -18,7 +18,7 @@ require (
 	github.com/bits-and-blooms/bitset v1.5.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.2 // indirect
+	github.com/stretchr/testify v1.8.2
 	golang.org/x/tools v0.6.0
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
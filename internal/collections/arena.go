@@ -0,0 +1,68 @@
+package collections
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A slab of an arena. Values are handed out from the start of the slab by
+// atomically moving next past them.
+type arenaSlab[T any] struct {
+	items []T
+	next  atomic.Int64
+}
+
+// Allocates values of T from large slabs, turning many small allocations
+// into a few large ones the GC has to track. Allocation is lock-free, except
+// for replacing a full slab. Memory is only freed when the whole arena is
+// no longer used.
+type Arena[T any] struct {
+	slabSize int
+	slab     atomic.Pointer[arenaSlab[T]]
+	lock     sync.Mutex
+	// Number of values allocated by the arena, including unused space at the
+	// end of full slabs
+	size atomic.Int64
+}
+
+// Creates an arena allocating slabs of slabSize values.
+func NewArena[T any](slabSize int) *Arena[T] {
+	return &Arena[T]{slabSize: slabSize}
+}
+
+// Allocates n zeroed values. Allocations larger than a quarter of a slab
+// get their own memory, so they don't waste the rest of a slab.
+func (a *Arena[T]) Alloc(n int) []T {
+	if n > a.slabSize/4 {
+		a.size.Add(int64(n))
+		return make([]T, n)
+	}
+
+	for {
+		slab := a.slab.Load()
+		if slab != nil {
+			end := slab.next.Add(int64(n))
+			if end <= int64(len(slab.items)) {
+				return slab.items[end-int64(n) : end : end]
+			}
+		}
+
+		a.lock.Lock()
+		// Another goroutine may have replaced the slab while waiting
+		if a.slab.Load() == slab {
+			a.slab.Store(&arenaSlab[T]{items: make([]T, a.slabSize)})
+			a.size.Add(int64(a.slabSize))
+		}
+		a.lock.Unlock()
+	}
+}
+
+// Allocates a single zeroed value.
+func (a *Arena[T]) New() *T {
+	return &a.Alloc(1)[0]
+}
+
+// Number of values allocated from the Go heap by the arena.
+func (a *Arena[T]) Size() int64 {
+	return a.size.Load()
+}
@@ -0,0 +1,298 @@
+package collections
+
+import (
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+type btreeItem[TKey any, TValue any] struct {
+	key   TKey
+	value *TValue
+}
+
+type btreeNode[TKey any, TValue any] struct {
+	items []btreeItem[TKey, TValue]
+	// Children of the node, nil for leaves. Child i holds the keys before
+	// item i, the last child the keys after the last item.
+	children []*btreeNode[TKey, TValue]
+}
+
+func (n *btreeNode[TKey, TValue]) isLeaf() bool {
+	return n.children == nil
+}
+
+// A B-tree keeping its keys in ascending order. Nodes hold many keys next to
+// each other, so scans touch less memory than in a skiplist. Reads may run
+// concurrently with writes, which are serialized by a lock.
+type BTree[TKey any, TValue any] struct {
+	root *btreeNode[TKey, TValue]
+	// Minimum number of children of the inner nodes, except the root
+	degree     int
+	numEntries int
+	// Bytes allocated for the nodes of the tree
+	nodesSize int64
+	// Incremented when items are moved between nodes, which invalidates the
+	// position of cursors
+	version uint64
+	lock    sync.RWMutex
+	// Returns a negative number if a is before b, 0 if they're equal and a
+	// positive number if a is after b
+	compare func(a TKey, b TKey) int
+}
+
+// Creates a BTree ordering its keys by compare. Nodes hold between degree-1
+// and 2*degree-1 keys, degree must be at least 2.
+func NewBTree[TKey any, TValue any](degree int, compare func(a TKey, b TKey) int) *BTree[TKey, TValue] {
+	if degree < 2 {
+		panic("BTree degree must be at least 2")
+	}
+	t := &BTree[TKey, TValue]{
+		degree:  degree,
+		compare: compare,
+	}
+	t.root = t.newNode(true)
+	return t
+}
+
+func (t *BTree[TKey, TValue]) maxItems() int {
+	return 2*t.degree - 1
+}
+
+// Nodes are allocated with room for as many items and children as they can
+// hold, so they never grow and their size is known.
+func (t *BTree[TKey, TValue]) newNode(leaf bool) *btreeNode[TKey, TValue] {
+	var item btreeItem[TKey, TValue]
+	n := &btreeNode[TKey, TValue]{
+		items: make([]btreeItem[TKey, TValue], 0, t.maxItems()),
+	}
+	t.nodesSize += int64(unsafe.Sizeof(*n)) + int64(t.maxItems())*int64(unsafe.Sizeof(item))
+	if !leaf {
+		n.children = make([]*btreeNode[TKey, TValue], 0, t.maxItems()+1)
+		t.nodesSize += int64(t.maxItems()+1) * int64(unsafe.Sizeof(n))
+	}
+	return n
+}
+
+// Returns the index of the first item of n at or after key, and whether the
+// item has the key.
+func (t *BTree[TKey, TValue]) search(n *btreeNode[TKey, TValue], key TKey) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool {
+		return t.compare(n.items[i].key, key) >= 0
+	})
+	return i, i < len(n.items) && t.compare(n.items[i].key, key) == 0
+}
+
+func (t *BTree[TKey, TValue]) Get(key TKey) (*TValue, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	n := t.root
+	for {
+		i, found := t.search(n, key)
+		if found {
+			return n.items[i].value, true
+		}
+		if n.isLeaf() {
+			return nil, false
+		}
+		n = n.children[i]
+	}
+}
+
+// Splits the full child i of n in two, moving its middle item up into n.
+func (t *BTree[TKey, TValue]) splitChild(n *btreeNode[TKey, TValue], i int) {
+	child := n.children[i]
+	middle := t.degree - 1
+	right := t.newNode(child.isLeaf())
+	right.items = append(right.items, child.items[middle+1:]...)
+	if !child.isLeaf() {
+		right.children = append(right.children, child.children[middle+1:]...)
+		for j := middle + 1; j < len(child.children); j++ {
+			child.children[j] = nil
+		}
+		child.children = child.children[:middle+1]
+	}
+	item := child.items[middle]
+	// The moved items are cleared, so the node doesn't keep them alive
+	for j := middle; j < len(child.items); j++ {
+		child.items[j] = btreeItem[TKey, TValue]{}
+	}
+	child.items = child.items[:middle]
+
+	n.items = append(n.items, btreeItem[TKey, TValue]{})
+	copy(n.items[i+1:], n.items[i:])
+	n.items[i] = item
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+	t.version++
+}
+
+// Inserts an item into the BTree, or updates an existing item if the key
+// already exists. Returns the old value in case of an update.
+func (t *BTree[TKey, TValue]) Insert(key TKey, value TValue) *TValue {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Full nodes are split on the way down, so there's always room to move
+	// an item up into the parent
+	if len(t.root.items) == t.maxItems() {
+		root := t.newNode(false)
+		root.children = append(root.children, t.root)
+		t.root = root
+		t.splitChild(t.root, 0)
+	}
+
+	n := t.root
+	for {
+		i, found := t.search(n, key)
+		if found {
+			old := n.items[i].value
+			n.items[i].value = &value
+			return old
+		}
+		if n.isLeaf() {
+			n.items = append(n.items, btreeItem[TKey, TValue]{})
+			copy(n.items[i+1:], n.items[i:])
+			n.items[i] = btreeItem[TKey, TValue]{key: key, value: &value}
+			t.numEntries++
+			t.version++
+			return nil
+		}
+		if len(n.children[i].items) == t.maxItems() {
+			t.splitChild(n, i)
+			// The item moved up may be the key, or come before it
+			continue
+		}
+		n = n.children[i]
+	}
+}
+
+// Returns a cursor at the first item, nil if the tree is empty.
+func (t *BTree[TKey, TValue]) Iterate() *BTreeCursor[TKey, TValue] {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	c := &BTreeCursor[TKey, TValue]{tree: t, version: t.version}
+	c.pushLeftmost(t.root)
+	return c.settle()
+}
+
+// Returns a cursor at the first item with a key at or after key, nil if
+// there is none.
+func (t *BTree[TKey, TValue]) Seek(key TKey) *BTreeCursor[TKey, TValue] {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.seek(key)
+}
+
+// The lock must be held.
+func (t *BTree[TKey, TValue]) seek(key TKey) *BTreeCursor[TKey, TValue] {
+	c := &BTreeCursor[TKey, TValue]{tree: t, version: t.version}
+	n := t.root
+	for {
+		i, found := t.search(n, key)
+		c.path = append(c.path, btreePosition[TKey, TValue]{n, i})
+		if found || n.isLeaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	return c.settle()
+}
+
+func (t *BTree[TKey, TValue]) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.numEntries
+}
+
+// Bytes allocated for the nodes and values of the tree, not counting memory
+// the keys and values refer to.
+func (t *BTree[TKey, TValue]) Size() int64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	var value TValue
+	return t.nodesSize + int64(t.numEntries)*int64(unsafe.Sizeof(value))
+}
+
+// An item of a node on the path from the root to the item of a cursor
+type btreePosition[TKey any, TValue any] struct {
+	node  *btreeNode[TKey, TValue]
+	index int
+}
+
+// Points at an item of a BTree. Keys inserted after the cursor was created
+// may or may not be seen when moving it.
+type BTreeCursor[TKey any, TValue any] struct {
+	tree *BTree[TKey, TValue]
+	// Path from the root to the item, the item is at the last position
+	path []btreePosition[TKey, TValue]
+	key  TKey
+	// Value of the item when the cursor was created
+	value *TValue
+	// Version of the tree the path is valid for
+	version uint64
+}
+
+// Moves down to the first item of the subtree of n.
+func (c *BTreeCursor[TKey, TValue]) pushLeftmost(n *btreeNode[TKey, TValue]) {
+	for {
+		c.path = append(c.path, btreePosition[TKey, TValue]{n, 0})
+		if n.isLeaf() {
+			return
+		}
+		n = n.children[0]
+	}
+}
+
+// Moves up past the ends of nodes until the path points at an item. Returns
+// nil if there are no more items.
+func (c *BTreeCursor[TKey, TValue]) settle() *BTreeCursor[TKey, TValue] {
+	for len(c.path) > 0 {
+		p := c.path[len(c.path)-1]
+		if p.index < len(p.node.items) {
+			c.key = p.node.items[p.index].key
+			c.value = p.node.items[p.index].value
+			return c
+		}
+		c.path = c.path[:len(c.path)-1]
+	}
+	return nil
+}
+
+// Returns a cursor at the next item, nil if this is the last one.
+func (c *BTreeCursor[TKey, TValue]) Next() *BTreeCursor[TKey, TValue] {
+	c.tree.lock.RLock()
+	defer c.tree.lock.RUnlock()
+
+	if c.version != c.tree.version {
+		// Items were moved since the cursor was created, find the item again
+		next := c.tree.seek(c.key)
+		if next != nil && c.tree.compare(next.key, c.key) == 0 {
+			return next.next()
+		}
+		return next
+	}
+	return c.next()
+}
+
+// The lock must be held and the path valid for the current version.
+func (c *BTreeCursor[TKey, TValue]) next() *BTreeCursor[TKey, TValue] {
+	next := &BTreeCursor[TKey, TValue]{
+		tree:    c.tree,
+		path:    append([]btreePosition[TKey, TValue]{}, c.path...),
+		version: c.version,
+	}
+	p := &next.path[len(next.path)-1]
+	p.index++
+	if !p.node.isLeaf() {
+		next.pushLeftmost(p.node.children[p.index])
+	}
+	return next.settle()
+}
+
+func (c *BTreeCursor[TKey, TValue]) Value() (TKey, *TValue) {
+	return c.key, c.value
+}
@@ -0,0 +1,119 @@
+package collections
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func btreeKeys(tree *BTree[string, int]) []string {
+	keys := []string{}
+	for c := tree.Iterate(); c != nil; c = c.Next() {
+		k, _ := c.Value()
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestBTreeInsertsInOrder(t *T) {
+	tree := NewBTree[string, int](2, strings.Compare)
+	assert.Nil(t, tree.Iterate())
+
+	expected := []string{}
+	for _, i := range rand.Perm(500) {
+		key := fmt.Sprintf("key%04d", i)
+		assert.Nil(t, tree.Insert(key, i))
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+
+	old := tree.Insert("key0042", 1000)
+	assert.Equal(t, 42, *old, "Should return the old value")
+	v, exists := tree.Get("key0042")
+	assert.True(t, exists)
+	assert.Equal(t, 1000, *v)
+	_, exists = tree.Get("key0500")
+	assert.False(t, exists)
+
+	assert.Equal(t, expected, btreeKeys(tree))
+	assert.Equal(t, 500, tree.Len())
+}
+
+func TestBTreeSeeks(t *T) {
+	tree := NewBTree[string, int](2, strings.Compare)
+	for i := 0; i < 100; i += 2 {
+		tree.Insert(fmt.Sprintf("key%03d", i), i)
+	}
+
+	k, _ := tree.Seek("key010").Value()
+	assert.Equal(t, "key010", k, "Should find an existing key")
+	k, _ = tree.Seek("key011").Value()
+	assert.Equal(t, "key012", k, "Should find the next key")
+	k, _ = tree.Seek("a").Value()
+	assert.Equal(t, "key000", k)
+	assert.Nil(t, tree.Seek("key099"))
+
+	k, _ = tree.Seek("key049").Next().Value()
+	assert.Equal(t, "key052", k)
+}
+
+func TestBTreeCursorContinuesAfterInserts(t *T) {
+	tree := NewBTree[string, int](2, strings.Compare)
+	for i := 0; i < 50; i += 2 {
+		tree.Insert(fmt.Sprintf("key%03d", i), i)
+	}
+
+	keys := []string{}
+	for c := tree.Iterate(); c != nil; c = c.Next() {
+		k, _ := c.Value()
+		keys = append(keys, k)
+		// Splits nodes the cursor is in
+		tree.Insert("a"+k, 0)
+	}
+	assert.Len(t, keys, 25, "Should not see keys inserted behind the cursor")
+	assert.True(t, sort.StringsAreSorted(keys))
+	assert.Equal(t, 50, tree.Len())
+}
+
+func TestBTreeConcurrentReads(t *T) {
+	tree := NewBTree[string, int](4, strings.Compare)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, i := range rand.Perm(2000) {
+			tree.Insert(fmt.Sprintf("key%04d", i), i)
+		}
+	}()
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				assert.True(t, sort.StringsAreSorted(btreeKeys(tree)))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, btreeKeys(tree), 2000)
+}
+
+func TestBTreeSize(t *T) {
+	tree := NewBTree[string, int](2, strings.Compare)
+	empty := tree.Size()
+	assert.Greater(t, empty, int64(0), "Should count the root")
+
+	for i := 0; i < 100; i++ {
+		tree.Insert(fmt.Sprintf("key%03d", i), i)
+	}
+	size := tree.Size()
+	assert.Greater(t, size, empty)
+
+	tree.Insert("key050", 0)
+	assert.Equal(t, size, tree.Size(), "Should not grow when values are replaced")
+}
@@ -0,0 +1,289 @@
+package collections
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const defaultCacheShards = 16
+
+// Options used when creating a Cache.
+type CacheOptions[TKey comparable, TValue any] struct {
+	// Total charge of the entries the cache holds, split evenly between its
+	// shards.
+	Capacity int64
+	// Number of shards, each with its own lock. If 0, a default is used.
+	Shards int
+	// Hashes keys to pick their shard, and to count how often they're used
+	// by TinyLFU caches. Required.
+	Hash func(key TKey) uint64
+	// Called with the entries evicted to make room for others, after the
+	// lock of their shard is released. Entries that are replaced or removed
+	// aren't passed to it. If nil, evicted entries are dropped.
+	OnEvict func(key TKey, value TValue)
+	// Number of entries the cache is expected to hold, sizing the frequency
+	// sketch of TinyLFU caches. If 0, the sketch starts small and forgets
+	// frequencies sooner.
+	ExpectedEntries int
+}
+
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Entries evicted to make room for others
+	Evictions uint64
+	// New entries kept out of the cache, since they were used less often
+	// than the entries they would have evicted
+	Rejections uint64
+	// Total charge of the entries, including pinned ones
+	Usage       int64
+	PinnedUsage int64
+	Capacity    int64
+	Entries     int
+}
+
+type cacheEntry[TKey comparable, TValue any] struct {
+	key    TKey
+	value  TValue
+	charge int64
+	// Pinned entries are never evicted, only removed
+	pinned bool
+	// Position in the LRU list, nil for pinned entries
+	node *Node[*cacheEntry[TKey, TValue]]
+}
+
+type cacheShard[TKey comparable, TValue any] struct {
+	lock     sync.Mutex
+	capacity int64
+	usage    int64
+	pinned   int64
+	// Least recently used entries are last in the list. Pinned entries are
+	// not part of the list.
+	lru     LinkedList[*cacheEntry[TKey, TValue]]
+	entries map[TKey]*cacheEntry[TKey, TValue]
+	// How often keys were used recently, nil if every new entry is admitted
+	sketch *countMinSketch
+}
+
+// A sharded cache bounded by the total charge of its entries, usually their
+// size in bytes. Least recently used entries are evicted first. A TinyLFU
+// cache also counts how often keys are used, and only admits a new entry if
+// it's used more often than the entries it would evict, so a scan of keys
+// used once doesn't flush the cache.
+type Cache[TKey comparable, TValue any] struct {
+	shards     []*cacheShard[TKey, TValue]
+	capacity   int64
+	hash       func(key TKey) uint64
+	onEvict    func(key TKey, value TValue)
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	evictions  atomic.Uint64
+	rejections atomic.Uint64
+}
+
+// Creates a cache evicting the least recently used entries when full.
+func NewLRUCache[TKey comparable, TValue any](opts CacheOptions[TKey, TValue]) *Cache[TKey, TValue] {
+	return newCache(opts, false)
+}
+
+// Creates a cache evicting the least recently used entries when full, but
+// only for new entries used more often than the ones evicted.
+func NewTinyLFUCache[TKey comparable, TValue any](opts CacheOptions[TKey, TValue]) *Cache[TKey, TValue] {
+	return newCache(opts, true)
+}
+
+func newCache[TKey comparable, TValue any](opts CacheOptions[TKey, TValue], tinyLFU bool) *Cache[TKey, TValue] {
+	numShards := opts.Shards
+	if numShards <= 0 {
+		numShards = defaultCacheShards
+	}
+
+	shards := make([]*cacheShard[TKey, TValue], numShards)
+	for i := range shards {
+		shards[i] = &cacheShard[TKey, TValue]{
+			capacity: opts.Capacity / int64(numShards),
+			lru:      NewLinkedList[*cacheEntry[TKey, TValue]](),
+			entries:  map[TKey]*cacheEntry[TKey, TValue]{},
+		}
+		if tinyLFU {
+			shards[i].sketch = newCountMinSketch(opts.ExpectedEntries / numShards)
+		}
+	}
+
+	return &Cache[TKey, TValue]{
+		shards:   shards,
+		capacity: opts.Capacity,
+		hash:     opts.Hash,
+		onEvict:  opts.OnEvict,
+	}
+}
+
+// Spreads keys with similar hashes over the shards
+func (c *Cache[TKey, TValue]) shard(hash uint64) *cacheShard[TKey, TValue] {
+	hash ^= hash >> 29
+	return c.shards[hash%uint64(len(c.shards))]
+}
+
+// Returns the value of a key. TinyLFU caches count the access, both for hits
+// and misses.
+func (c *Cache[TKey, TValue]) Get(key TKey) (TValue, bool) {
+	hash := c.hash(key)
+	s := c.shard(hash)
+	s.lock.Lock()
+	if s.sketch != nil {
+		s.sketch.increment(hash)
+	}
+	entry, exists := s.entries[key]
+	var value TValue
+	if exists {
+		if !entry.pinned {
+			s.lru.MoveToFront(entry.node)
+		}
+		value = entry.value
+	}
+	s.lock.Unlock()
+
+	if !exists {
+		c.misses.Add(1)
+		return value, false
+	}
+	c.hits.Add(1)
+	return value, true
+}
+
+// Inserts an entry, replacing the entry of the key if there is one. The
+// least recently used entries of the shard are evicted if it's over
+// capacity. Returns false if the entry wasn't admitted into a TinyLFU cache.
+// Inserting doesn't count as an access of the key, the Get that missed it
+// already did.
+func (c *Cache[TKey, TValue]) Insert(key TKey, value TValue, charge int64) bool {
+	return c.insert(key, value, charge, false)
+}
+
+// Inserts an entry that is never evicted, it stays in the cache until it's
+// removed. Its charge still counts against the capacity of the cache.
+func (c *Cache[TKey, TValue]) InsertPinned(key TKey, value TValue, charge int64) {
+	c.insert(key, value, charge, true)
+}
+
+func (c *Cache[TKey, TValue]) insert(key TKey, value TValue, charge int64, pinned bool) bool {
+	hash := c.hash(key)
+	s := c.shard(hash)
+	s.lock.Lock()
+
+	existing, exists := s.entries[key]
+	if !exists && !pinned && s.sketch != nil {
+		if !s.admit(c, hash, charge) {
+			s.lock.Unlock()
+			c.rejections.Add(1)
+			return false
+		}
+	}
+	if exists {
+		s.remove(existing)
+	}
+
+	entry := &cacheEntry[TKey, TValue]{
+		key:    key,
+		value:  value,
+		charge: charge,
+		pinned: pinned,
+	}
+	if pinned {
+		s.pinned += charge
+	} else {
+		entry.node = s.lru.PushFront(entry)
+	}
+	s.entries[key] = entry
+	s.usage += charge
+
+	evicted := []*cacheEntry[TKey, TValue]{}
+	for s.usage > s.capacity && s.lru.Last() != nil {
+		victim := s.lru.Last().Value()
+		s.remove(victim)
+		evicted = append(evicted, victim)
+	}
+	s.lock.Unlock()
+
+	c.evictions.Add(uint64(len(evicted)))
+	if c.onEvict != nil {
+		for _, e := range evicted {
+			c.onEvict(e.key, e.value)
+		}
+	}
+	return true
+}
+
+// Checks if a new entry is used more often than every entry it would evict.
+// The lock must be held.
+func (s *cacheShard[TKey, TValue]) admit(c *Cache[TKey, TValue], hash uint64, charge int64) bool {
+	frequency := s.sketch.estimate(hash)
+	free := s.capacity - s.usage
+	for node := s.lru.Last(); node != nil && free < charge; node = node.Prev() {
+		victim := node.Value()
+		if s.sketch.estimate(c.hash(victim.key)) >= frequency {
+			return false
+		}
+		free += victim.charge
+	}
+	return true
+}
+
+// The lock must be held.
+func (s *cacheShard[TKey, TValue]) remove(entry *cacheEntry[TKey, TValue]) {
+	if entry.pinned {
+		s.pinned -= entry.charge
+	} else {
+		s.lru.Remove(entry.node)
+	}
+	s.usage -= entry.charge
+	delete(s.entries, entry.key)
+}
+
+// Removes the entry of a key. Returns false if there was none.
+func (c *Cache[TKey, TValue]) Remove(key TKey) bool {
+	s := c.shard(c.hash(key))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, exists := s.entries[key]
+	if exists {
+		s.remove(entry)
+	}
+	return exists
+}
+
+// Removes every entry, including pinned ones, with a key matching fn.
+// Returns the number of entries removed.
+func (c *Cache[TKey, TValue]) RemoveIf(fn func(key TKey) bool) int {
+	removed := 0
+	for _, s := range c.shards {
+		s.lock.Lock()
+		for key, entry := range s.entries {
+			if fn(key) {
+				s.remove(entry)
+				removed++
+			}
+		}
+		s.lock.Unlock()
+	}
+	return removed
+}
+
+func (c *Cache[TKey, TValue]) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+		Evictions:  c.evictions.Load(),
+		Rejections: c.rejections.Load(),
+		Capacity:   c.capacity,
+	}
+	for _, s := range c.shards {
+		s.lock.Lock()
+		stats.Usage += s.usage
+		stats.PinnedUsage += s.pinned
+		stats.Entries += len(s.entries)
+		s.lock.Unlock()
+	}
+	return stats
+}
@@ -0,0 +1,164 @@
+package collections
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math/rand"
+	"sync"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCacheOptions(capacity int64, shards int) CacheOptions[string, int] {
+	seed := maphash.MakeSeed()
+	return CacheOptions[string, int]{
+		Capacity: capacity,
+		Shards:   shards,
+		Hash:     func(key string) uint64 { return maphash.String(seed, key) },
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *T) {
+	evicted := []string{}
+	opts := newTestCacheOptions(30, 1)
+	opts.OnEvict = func(key string, value int) { evicted = append(evicted, key) }
+	c := NewLRUCache(opts)
+	assert.True(t, c.Insert("a", 1, 10))
+	c.Insert("b", 2, 10)
+	c.Insert("c", 3, 10)
+
+	// Touch a so b becomes the least recently used
+	_, exists := c.Get("a")
+	assert.True(t, exists)
+	c.Insert("d", 4, 10)
+	c.Insert("a", 5, 10)
+
+	_, exists = c.Get("b")
+	assert.False(t, exists, "b should have been evicted")
+	v, exists := c.Get("a")
+	assert.True(t, exists)
+	assert.Equal(t, 5, v, "Should replace the value")
+	assert.Equal(t, []string{"b"}, evicted, "Should not pass replaced entries")
+
+	stats := c.Stats()
+	assert.Equal(t, int64(30), stats.Usage)
+	assert.Equal(t, 3, stats.Entries)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+}
+
+func TestCacheKeepsPinnedEntries(t *T) {
+	c := NewLRUCache(newTestCacheOptions(20, 1))
+	c.InsertPinned("pinned", 1, 15)
+	c.Insert("b", 2, 10)
+	c.Insert("c", 3, 10)
+
+	_, exists := c.Get("pinned")
+	assert.True(t, exists, "Pinned entries should never be evicted")
+	assert.Equal(t, int64(15), c.Stats().PinnedUsage)
+
+	assert.True(t, c.Remove("pinned"))
+	assert.False(t, c.Remove("pinned"))
+	c.Insert("d", 4, 5)
+	assert.Equal(t, 1, c.RemoveIf(func(key string) bool { return key == "d" }))
+	stats := c.Stats()
+	assert.Equal(t, int64(0), stats.PinnedUsage)
+	assert.Equal(t, int64(0), stats.Usage)
+}
+
+func TestTinyLFUCacheKeepsFrequentEntries(t *T) {
+	opts := newTestCacheOptions(10, 1)
+	opts.ExpectedEntries = 100
+	c := NewTinyLFUCache(opts)
+
+	// Read the entries a few times, so they're used more often than the
+	// entries of a scan
+	for i := 0; i < 3; i++ {
+		for k := 0; k < 10; k++ {
+			key := fmt.Sprintf("hot%v", k)
+			if _, exists := c.Get(key); !exists {
+				assert.True(t, c.Insert(key, k, 1), "Should admit entries while there's room")
+			}
+		}
+	}
+	for k := 0; k < 50; k++ {
+		key := fmt.Sprintf("scan%v", k)
+		c.Get(key)
+		c.Insert(key, k, 1)
+	}
+
+	for k := 0; k < 10; k++ {
+		_, exists := c.Get(fmt.Sprintf("hot%v", k))
+		assert.True(t, exists, "hot%v should still be cached", k)
+	}
+	assert.Equal(t, uint64(50), c.Stats().Rejections)
+
+	// Entries used more often than the ones in the cache are admitted
+	for i := 0; i < 10; i++ {
+		c.Get("new")
+	}
+	assert.True(t, c.Insert("new", 0, 1))
+}
+
+func TestTinyLFUCacheCountsAccessesOnce(t *T) {
+	opts := newTestCacheOptions(10, 1)
+	opts.ExpectedEntries = 100
+	c := NewTinyLFUCache(opts)
+	frequency := func(key string) uint8 {
+		hash := opts.Hash(key)
+		return c.shard(hash).sketch.estimate(hash)
+	}
+
+	_, exists := c.Get("a")
+	assert.False(t, exists)
+	assert.True(t, c.Insert("a", 1, 1))
+	assert.Equal(t, uint8(1), frequency("a"), "A miss and the insert after it should count once")
+
+	c.Get("a")
+	c.Insert("a", 2, 1)
+	assert.Equal(t, uint8(2), frequency("a"), "Replacing an entry should not count")
+}
+
+func TestCacheConcurrentAccess(t *T) {
+	for _, c := range []*Cache[string, int]{
+		NewLRUCache(newTestCacheOptions(100, 4)),
+		NewTinyLFUCache(newTestCacheOptions(100, 4)),
+	} {
+		wg := sync.WaitGroup{}
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					key := fmt.Sprintf("key%v", rand.Intn(200))
+					if _, exists := c.Get(key); !exists {
+						c.Insert(key, i, 1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		stats := c.Stats()
+		assert.LessOrEqual(t, stats.Usage, stats.Capacity)
+		assert.Equal(t, uint64(4000), stats.Hits+stats.Misses)
+	}
+}
+
+func TestCountMinSketchEstimatesFrequency(t *T) {
+	s := newCountMinSketch(64)
+	for i := 0; i < 5; i++ {
+		s.increment(1)
+	}
+	s.increment(2)
+	assert.GreaterOrEqual(t, s.estimate(1), uint8(5))
+	assert.GreaterOrEqual(t, s.estimate(2), uint8(1))
+	assert.Less(t, s.estimate(2), s.estimate(1))
+
+	// Counts are halved once the sketch has seen enough increments
+	for i := 0; i < s.resetAfter; i++ {
+		s.increment(3)
+	}
+	assert.Less(t, s.estimate(1), uint8(5))
+}
@@ -0,0 +1,216 @@
+package collections
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Maximum height of the towers of a ConcurrentSkipList
+const concurrentSkipListMaxHeight = 20
+
+// Number of nodes, tower links and key bytes allocated per arena slab
+const (
+	nodeSlabSize  = 1024
+	towerSlabSize = 4096
+	keySlabSize   = 64 * 1024
+)
+
+type ConcurrentSkipListElement[TValue any] struct {
+	key   string
+	value atomic.Pointer[TValue]
+	// Links to the next element on every level of the tower of the element
+	next []atomic.Pointer[ConcurrentSkipListElement[TValue]]
+}
+
+// Returns the next element, nil if this is the last one.
+func (e *ConcurrentSkipListElement[TValue]) Next() *ConcurrentSkipListElement[TValue] {
+	return e.next[0].Load()
+}
+
+func (e *ConcurrentSkipListElement[TValue]) Value() (string, *TValue) {
+	return e.key, e.value.Load()
+}
+
+// A skiplist that can be read and written concurrently without locks.
+// Elements are linked into the list with compare-and-swap, and are never
+// removed, the value of an existing key is replaced atomically instead.
+// Elements, their towers and keys are allocated from arenas, so inserting
+// rarely allocates. Readers see every insert that completed before they
+// started, and may or may not see concurrent ones.
+type ConcurrentSkipList[TValue any] struct {
+	head *ConcurrentSkipListElement[TValue]
+	// Height of the tallest tower in the list
+	height  atomic.Int32
+	compare func(a string, b string) int
+
+	numEntries atomic.Int64
+	nodes      *Arena[ConcurrentSkipListElement[TValue]]
+	towers     *Arena[atomic.Pointer[ConcurrentSkipListElement[TValue]]]
+	keys       *Arena[byte]
+	values     *Arena[TValue]
+}
+
+// Creates a ConcurrentSkipList ordering its keys by compare, which returns
+// a negative number if a is before b, 0 if they're equal and a positive
+// number if a is after b.
+func NewConcurrentSkipList[TValue any](compare func(a string, b string) int) *ConcurrentSkipList[TValue] {
+	l := &ConcurrentSkipList[TValue]{
+		compare: compare,
+		nodes:   NewArena[ConcurrentSkipListElement[TValue]](nodeSlabSize),
+		towers:  NewArena[atomic.Pointer[ConcurrentSkipListElement[TValue]]](towerSlabSize),
+		keys:    NewArena[byte](keySlabSize),
+		values:  NewArena[TValue](nodeSlabSize),
+	}
+	l.head = &ConcurrentSkipListElement[TValue]{
+		next: make([]atomic.Pointer[ConcurrentSkipListElement[TValue]], concurrentSkipListMaxHeight),
+	}
+	l.height.Store(1)
+	return l
+}
+
+func (l *ConcurrentSkipList[TValue]) randomHeight() int {
+	// Each level is half as likely as the one below it
+	height := 1
+	for r := rand.Uint32(); r&1 == 1 && height < concurrentSkipListMaxHeight; r >>= 1 {
+		height++
+	}
+	return height
+}
+
+// Finds the last element before key and the first element at or after key
+// on a level, starting the search at prev, which must be before key.
+func (l *ConcurrentSkipList[TValue]) findSpliceForLevel(key string, level int, prev *ConcurrentSkipListElement[TValue]) (before *ConcurrentSkipListElement[TValue], after *ConcurrentSkipListElement[TValue]) {
+	for {
+		next := prev.next[level].Load()
+		if next == nil || l.compare(next.key, key) >= 0 {
+			return prev, next
+		}
+		prev = next
+	}
+}
+
+// Finds the elements around key on every level of the list, searching
+// from the top level down.
+func (l *ConcurrentSkipList[TValue]) findSplice(key string, before *[concurrentSkipListMaxHeight]*ConcurrentSkipListElement[TValue], after *[concurrentSkipListMaxHeight]*ConcurrentSkipListElement[TValue]) {
+	prev := l.head
+	for level := int(l.height.Load()) - 1; level >= 0; level-- {
+		before[level], after[level] = l.findSpliceForLevel(key, level, prev)
+		prev = before[level]
+	}
+}
+
+// Returns the element with key, nil if there is none.
+func (l *ConcurrentSkipList[TValue]) find(key string) *ConcurrentSkipListElement[TValue] {
+	prev := l.head
+	var next *ConcurrentSkipListElement[TValue]
+	for level := int(l.height.Load()) - 1; level >= 0; level-- {
+		prev, next = l.findSpliceForLevel(key, level, prev)
+	}
+	if next != nil && l.compare(next.key, key) == 0 {
+		return next
+	}
+	return nil
+}
+
+func (l *ConcurrentSkipList[TValue]) Get(key string) (*TValue, bool) {
+	e := l.find(key)
+	if e == nil {
+		return nil, false
+	}
+	return e.value.Load(), true
+}
+
+// Copies a key into the key arena, so the list doesn't keep the memory of
+// the caller's key alive.
+func (l *ConcurrentSkipList[TValue]) copyKey(key string) string {
+	if len(key) == 0 {
+		return ""
+	}
+	data := l.keys.Alloc(len(key))
+	copy(data, key)
+	return unsafe.String(&data[0], len(data))
+}
+
+// Inserts an item into the list, or replaces the value if the key already
+// exists. Returns the old value in case of an update.
+func (l *ConcurrentSkipList[TValue]) Insert(key string, value TValue) *TValue {
+	v := l.values.New()
+	*v = value
+
+	if e := l.find(key); e != nil {
+		return e.value.Swap(v)
+	}
+
+	height := l.randomHeight()
+	for {
+		listHeight := l.height.Load()
+		if int(listHeight) >= height || l.height.CompareAndSwap(listHeight, int32(height)) {
+			break
+		}
+	}
+
+	var before, after [concurrentSkipListMaxHeight]*ConcurrentSkipListElement[TValue]
+	l.findSplice(key, &before, &after)
+	if after[0] != nil && l.compare(after[0].key, key) == 0 {
+		// Inserted concurrently since the lookup
+		return after[0].value.Swap(v)
+	}
+
+	e := l.nodes.New()
+	e.key = l.copyKey(key)
+	e.value.Store(v)
+	e.next = l.towers.Alloc(height)
+
+	// Link the element in from the bottom up. Once it's on level 0 it's in
+	// the list, the upper levels only speed up searches.
+	for level := 0; level < height; level++ {
+		for {
+			e.next[level].Store(after[level])
+			if before[level].next[level].CompareAndSwap(after[level], e) {
+				break
+			}
+
+			// Another element was linked in next to this one, search again
+			// from the element before it
+			before[level], after[level] = l.findSpliceForLevel(key, level, before[level])
+			if level == 0 && after[0] != nil && l.compare(after[0].key, key) == 0 {
+				// The key was inserted concurrently, the element is dropped
+				return after[0].value.Swap(v)
+			}
+		}
+	}
+	l.numEntries.Add(1)
+	return nil
+}
+
+// Returns the first element, nil if the list is empty.
+func (l *ConcurrentSkipList[TValue]) Iterate() *ConcurrentSkipListElement[TValue] {
+	return l.head.next[0].Load()
+}
+
+// Returns the first element with a key at or after key, nil if there is
+// none.
+func (l *ConcurrentSkipList[TValue]) Seek(key string) *ConcurrentSkipListElement[TValue] {
+	prev := l.head
+	var next *ConcurrentSkipListElement[TValue]
+	for level := int(l.height.Load()) - 1; level >= 0; level-- {
+		prev, next = l.findSpliceForLevel(key, level, prev)
+	}
+	return next
+}
+
+func (l *ConcurrentSkipList[TValue]) Len() int {
+	return int(l.numEntries.Load())
+}
+
+// Bytes allocated for the elements, towers, keys and values of the list.
+func (l *ConcurrentSkipList[TValue]) ArenaSize() int64 {
+	var node ConcurrentSkipListElement[TValue]
+	var link atomic.Pointer[ConcurrentSkipListElement[TValue]]
+	var value TValue
+	return l.nodes.Size()*int64(unsafe.Sizeof(node)) +
+		l.towers.Size()*int64(unsafe.Sizeof(link)) +
+		l.keys.Size() +
+		l.values.Size()*int64(unsafe.Sizeof(value))
+}
@@ -0,0 +1,138 @@
+package collections
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func keysOf[TValue any](l *ConcurrentSkipList[TValue]) []string {
+	keys := []string{}
+	for e := l.Iterate(); e != nil; e = e.Next() {
+		k, _ := e.Value()
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestConcurrentSkipListInsertsInOrder(t *T) {
+	l := NewConcurrentSkipList[int](strings.Compare)
+	assert.Nil(t, l.Iterate())
+	assert.Nil(t, l.Insert("c", 3))
+	assert.Nil(t, l.Insert("a", 1))
+	assert.Nil(t, l.Insert("b", 2))
+
+	old := l.Insert("b", 4)
+	assert.Equal(t, 2, *old)
+	v, exists := l.Get("b")
+	assert.True(t, exists)
+	assert.Equal(t, 4, *v)
+	_, exists = l.Get("d")
+	assert.False(t, exists)
+
+	assert.Equal(t, []string{"a", "b", "c"}, keysOf(l))
+	assert.Equal(t, 3, l.Len())
+
+	k, _ := l.Seek("bb").Value()
+	assert.Equal(t, "c", k)
+	k, _ = l.Seek("b").Value()
+	assert.Equal(t, "b", k)
+	assert.Nil(t, l.Seek("d"))
+	assert.Greater(t, l.ArenaSize(), int64(0))
+}
+
+func TestConcurrentSkipListKeepsNoReferenceToKeys(t *T) {
+	l := NewConcurrentSkipList[int](strings.Compare)
+	key := []byte("key")
+	l.Insert(string(key[:2]), 1)
+	key[0] = 'x'
+	assert.Equal(t, []string{"ke"}, keysOf(l))
+}
+
+func TestConcurrentSkipListConcurrentInserts(t *T) {
+	l := NewConcurrentSkipList[int](strings.Compare)
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for _, i := range rand.Perm(1000) {
+				// Every writer inserts half of the keys of the others
+				l.Insert(fmt.Sprintf("key%05d", i*8/2+w/2), w)
+				l.Get(fmt.Sprintf("key%05d", i))
+			}
+		}(w)
+	}
+	// Readers iterate while the list is written
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				assert.True(t, sort.StringsAreSorted(keysOf(l)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	keys := keysOf(l)
+	assert.Len(t, keys, 4000)
+	assert.Equal(t, 4000, l.Len())
+	assert.True(t, sort.StringsAreSorted(keys))
+	for i := 0; i < 4000; i++ {
+		_, exists := l.Get(fmt.Sprintf("key%05d", i))
+		assert.True(t, exists)
+	}
+}
+
+func TestArenaAllocatesFromSlabs(t *T) {
+	a := NewArena[int](100)
+	first := a.Alloc(10)
+	second := a.Alloc(10)
+	assert.Len(t, first, 10)
+	assert.Equal(t, 10, cap(first))
+	first[9] = 1
+	assert.Equal(t, 0, second[0])
+	assert.Equal(t, int64(100), a.Size())
+
+	// Large allocations don't use the slab
+	a.Alloc(50)
+	assert.Equal(t, int64(150), a.Size())
+	for i := 0; i < 10; i++ {
+		a.Alloc(10)
+	}
+	assert.Equal(t, int64(250), a.Size())
+}
+
+// Inserts with many concurrent writers, the locked skiplist serializes
+// them.
+func BenchmarkConcurrentInserts(b *B) {
+	keys := make([]string, 1<<16)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%016x", rand.Uint64())
+	}
+
+	b.Run("locked", func(b *B) {
+		l := NewSkipList[string, int](20)
+		b.SetParallelism(8)
+		b.RunParallel(func(pb *PB) {
+			for i := rand.Intn(len(keys)); pb.Next(); i++ {
+				l.Insert(keys[i%len(keys)], i)
+			}
+		})
+	})
+	b.Run("lock-free", func(b *B) {
+		l := NewConcurrentSkipList[int](strings.Compare)
+		b.SetParallelism(8)
+		b.RunParallel(func(pb *PB) {
+			for i := rand.Intn(len(keys)); pb.Next(); i++ {
+				l.Insert(keys[i%len(keys)], i)
+			}
+		})
+	})
+}
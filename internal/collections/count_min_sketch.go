@@ -0,0 +1,84 @@
+package collections
+
+// Number of rows of counters of a countMinSketch, each indexed by a
+// different hash of the key
+const sketchDepth = 4
+
+const (
+	minSketchWidth = 64
+	// Counters saturate at this count, telling keys used often apart from
+	// keys used rarely doesn't need more
+	maxSketchCount = 15
+	// Counters are halved after this many increments per counter of a row
+	sketchSamplesPerCounter = 10
+)
+
+// Estimates how often keys were used recently, in a fixed amount of memory.
+// Keys sharing counters make counts overestimated, never underestimated.
+// All counts are halved periodically, so keys that were used often long ago
+// are forgotten.
+type countMinSketch struct {
+	rows [sketchDepth][]uint8
+	mask uint64
+	// Increments since the counters were last halved
+	increments int
+	resetAfter int
+}
+
+// Creates a sketch with room for about expectedEntries keys.
+func newCountMinSketch(expectedEntries int) *countMinSketch {
+	width := minSketchWidth
+	for width < expectedEntries {
+		width *= 2
+	}
+	s := &countMinSketch{
+		mask:       uint64(width - 1),
+		resetAfter: width * sketchSamplesPerCounter,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// Index of the counter of a key in a row, derived from its hash by double
+// hashing.
+func (s *countMinSketch) index(hash uint64, row int) uint64 {
+	hash *= 0x9E3779B97F4A7C15
+	return (hash + uint64(row)*(hash>>32|1)) & s.mask
+}
+
+func (s *countMinSketch) increment(hash uint64) {
+	for i := range s.rows {
+		counter := &s.rows[i][s.index(hash, i)]
+		if *counter < maxSketchCount {
+			*counter++
+		}
+	}
+
+	s.increments++
+	if s.increments >= s.resetAfter {
+		s.halve()
+	}
+}
+
+func (s *countMinSketch) halve() {
+	for _, row := range s.rows {
+		for i := range row {
+			row[i] /= 2
+		}
+	}
+	s.increments /= 2
+}
+
+// Returns how often a key was used, at least as often as it really was
+// since the counts were halved.
+func (s *countMinSketch) estimate(hash uint64) uint8 {
+	estimate := uint8(maxSketchCount)
+	for i := range s.rows {
+		if count := s.rows[i][s.index(hash, i)]; count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
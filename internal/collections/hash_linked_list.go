@@ -0,0 +1,187 @@
+package collections
+
+import (
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+type hashListNode[TKey any, TValue any] struct {
+	key   TKey
+	value *TValue
+	next  *hashListNode[TKey, TValue]
+}
+
+// A hash table of linked lists, each sorted by key. Looking up a key only
+// walks the list of its bucket, but iterating in key order needs all keys
+// to be sorted, which is done on the first iteration after keys were added.
+// Suited for point lookups, not scans. Reads may run concurrently with
+// writes, which are serialized by a lock.
+//
+// Keys are only equal if compare says so and they have the same hash.
+type HashLinkedList[TKey any, TValue any] struct {
+	buckets    []*hashListNode[TKey, TValue]
+	numEntries int
+	lock       sync.RWMutex
+	// Every node sorted by key, nil if keys were added since it was sorted.
+	// Replaced rather than modified, cursors keep iterating over the nodes
+	// sorted when they were created.
+	sorted     []*hashListNode[TKey, TValue]
+	sortedLock sync.Mutex
+	hash       func(key TKey) uint64
+	// Returns a negative number if a is before b, 0 if they're equal and a
+	// positive number if a is after b
+	compare func(a TKey, b TKey) int
+}
+
+// Creates a HashLinkedList with numBuckets buckets, placing keys by hash and
+// ordering them by compare.
+func NewHashLinkedList[TKey any, TValue any](numBuckets int, hash func(key TKey) uint64, compare func(a TKey, b TKey) int) *HashLinkedList[TKey, TValue] {
+	return &HashLinkedList[TKey, TValue]{
+		buckets: make([]*hashListNode[TKey, TValue], numBuckets),
+		hash:    hash,
+		compare: compare,
+	}
+}
+
+func (l *HashLinkedList[TKey, TValue]) bucket(key TKey) int {
+	return int(l.hash(key) % uint64(len(l.buckets)))
+}
+
+// Returns the last node of a bucket with a key before key, nil if there is
+// none, and the node after it. The lock must be held.
+func (l *HashLinkedList[TKey, TValue]) find(bucket int, key TKey) (prev *hashListNode[TKey, TValue], node *hashListNode[TKey, TValue]) {
+	node = l.buckets[bucket]
+	for node != nil && l.compare(node.key, key) < 0 {
+		prev = node
+		node = node.next
+	}
+	return prev, node
+}
+
+func (l *HashLinkedList[TKey, TValue]) Get(key TKey) (*TValue, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	_, node := l.find(l.bucket(key), key)
+	if node != nil && l.compare(node.key, key) == 0 {
+		return node.value, true
+	}
+	return nil, false
+}
+
+// Inserts an item into the list, or updates an existing item if the key
+// already exists. Returns the old value in case of an update.
+func (l *HashLinkedList[TKey, TValue]) Insert(key TKey, value TValue) *TValue {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	bucket := l.bucket(key)
+	prev, node := l.find(bucket, key)
+	if node != nil && l.compare(node.key, key) == 0 {
+		old := node.value
+		node.value = &value
+		return old
+	}
+
+	newNode := &hashListNode[TKey, TValue]{key: key, value: &value, next: node}
+	if prev == nil {
+		l.buckets[bucket] = newNode
+	} else {
+		prev.next = newNode
+	}
+	l.numEntries++
+	l.sorted = nil
+	return nil
+}
+
+// Returns every node sorted by key. The read lock must be held.
+func (l *HashLinkedList[TKey, TValue]) sortedNodes() []*hashListNode[TKey, TValue] {
+	l.sortedLock.Lock()
+	defer l.sortedLock.Unlock()
+	if l.sorted != nil {
+		return l.sorted
+	}
+
+	sorted := make([]*hashListNode[TKey, TValue], 0, l.numEntries)
+	for _, node := range l.buckets {
+		for ; node != nil; node = node.next {
+			sorted = append(sorted, node)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return l.compare(sorted[i].key, sorted[j].key) < 0
+	})
+	l.sorted = sorted
+	return sorted
+}
+
+// Returns a cursor at the first item, nil if the list is empty.
+func (l *HashLinkedList[TKey, TValue]) Iterate() *HashLinkedListCursor[TKey, TValue] {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.cursor(l.sortedNodes(), 0)
+}
+
+// Returns a cursor at the first item with a key at or after key, nil if
+// there is none.
+func (l *HashLinkedList[TKey, TValue]) Seek(key TKey) *HashLinkedListCursor[TKey, TValue] {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	nodes := l.sortedNodes()
+	i := sort.Search(len(nodes), func(i int) bool {
+		return l.compare(nodes[i].key, key) >= 0
+	})
+	return l.cursor(nodes, i)
+}
+
+func (l *HashLinkedList[TKey, TValue]) cursor(nodes []*hashListNode[TKey, TValue], index int) *HashLinkedListCursor[TKey, TValue] {
+	if index >= len(nodes) {
+		return nil
+	}
+	return &HashLinkedListCursor[TKey, TValue]{list: l, nodes: nodes, index: index}
+}
+
+func (l *HashLinkedList[TKey, TValue]) Len() int {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.numEntries
+}
+
+// Bytes allocated for the buckets, nodes and values of the list, and the
+// sorted nodes of the last iteration, not counting memory the keys and
+// values refer to.
+func (l *HashLinkedList[TKey, TValue]) Size() int64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	l.sortedLock.Lock()
+	defer l.sortedLock.Unlock()
+
+	var node hashListNode[TKey, TValue]
+	var value TValue
+	ptrSize := int64(unsafe.Sizeof(&node))
+	return int64(len(l.buckets))*ptrSize +
+		int64(l.numEntries)*int64(unsafe.Sizeof(node)+unsafe.Sizeof(value)) +
+		int64(cap(l.sorted))*ptrSize
+}
+
+// Points at an item of a HashLinkedList. Only the keys in the list when the
+// iteration started are seen, with their current values.
+type HashLinkedListCursor[TKey any, TValue any] struct {
+	list  *HashLinkedList[TKey, TValue]
+	nodes []*hashListNode[TKey, TValue]
+	index int
+}
+
+// Returns a cursor at the next item, nil if this is the last one.
+func (c *HashLinkedListCursor[TKey, TValue]) Next() *HashLinkedListCursor[TKey, TValue] {
+	return c.list.cursor(c.nodes, c.index+1)
+}
+
+func (c *HashLinkedListCursor[TKey, TValue]) Value() (TKey, *TValue) {
+	c.list.lock.RLock()
+	defer c.list.lock.RUnlock()
+	node := c.nodes[c.index]
+	return node.key, node.value
+}
@@ -0,0 +1,88 @@
+package collections
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math/rand"
+	"sort"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHashLinkedList(numBuckets int) *HashLinkedList[string, int] {
+	seed := maphash.MakeSeed()
+	return NewHashLinkedList[string, int](numBuckets, func(key string) uint64 {
+		return maphash.String(seed, key)
+	}, strings.Compare)
+}
+
+func hashListKeys(l *HashLinkedList[string, int]) []string {
+	keys := []string{}
+	for c := l.Iterate(); c != nil; c = c.Next() {
+		k, _ := c.Value()
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestHashLinkedListInsertsAndGets(t *T) {
+	l := newTestHashLinkedList(16)
+	assert.Nil(t, l.Iterate())
+
+	expected := []string{}
+	for _, i := range rand.Perm(200) {
+		key := fmt.Sprintf("key%03d", i)
+		assert.Nil(t, l.Insert(key, i))
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+
+	old := l.Insert("key042", 1000)
+	assert.Equal(t, 42, *old, "Should return the old value")
+	v, exists := l.Get("key042")
+	assert.True(t, exists)
+	assert.Equal(t, 1000, *v)
+	_, exists = l.Get("key200")
+	assert.False(t, exists)
+
+	assert.Equal(t, expected, hashListKeys(l))
+	assert.Equal(t, 200, l.Len())
+}
+
+func TestHashLinkedListSeeks(t *T) {
+	l := newTestHashLinkedList(4)
+	for _, k := range []string{"a", "c", "e"} {
+		l.Insert(k, 0)
+	}
+
+	k, _ := l.Seek("b").Value()
+	assert.Equal(t, "c", k)
+	assert.Nil(t, l.Seek("f"))
+
+	// Cursors keep iterating over the keys there were when they were created
+	c := l.Seek("c")
+	l.Insert("d", 0)
+	l.Insert("c", 1)
+	k, v := c.Value()
+	assert.Equal(t, "c", k)
+	assert.Equal(t, 1, *v, "Should see the current value")
+	k, _ = c.Next().Value()
+	assert.Equal(t, "e", k)
+
+	k, _ = l.Seek("c").Next().Value()
+	assert.Equal(t, "d", k, "Should see keys added before the cursor was created")
+}
+
+func TestHashLinkedListSize(t *T) {
+	l := newTestHashLinkedList(16)
+	empty := l.Size()
+	assert.Greater(t, empty, int64(0), "Should count the buckets")
+
+	l.Insert("a", 1)
+	size := l.Size()
+	assert.Greater(t, size, empty)
+	l.Insert("a", 2)
+	assert.Equal(t, size, l.Size(), "Should not grow when values are replaced")
+}
@@ -11,6 +11,8 @@ type Node[T any] struct {
 
 type LinkedList[T any] struct {
 	first *Node[T]
+	last  *Node[T]
+	len   int
 }
 
 func NewLinkedList[T any]() LinkedList[T] {
@@ -33,6 +35,14 @@ func (l *LinkedList[T]) First() *Node[T] {
 	return l.first
 }
 
+func (l *LinkedList[T]) Last() *Node[T] {
+	return l.last
+}
+
+func (l *LinkedList[T]) Len() int {
+	return l.len
+}
+
 func (l *LinkedList[T]) Insert(index int, value T) error {
 	var prev *Node[T] = nil
 	node := l.first
@@ -50,17 +60,19 @@ func (l *LinkedList[T]) Insert(index int, value T) error {
 		prev:  prev,
 	}
 
-	if node != nil {
-		node.prev = newNode
-	}
+	l.link(newNode)
+	return nil
+}
 
-	if prev == nil {
-		l.first = newNode
-	} else {
-		prev.next = newNode
+// Inserts a value first in the list and returns the node holding it.
+func (l *LinkedList[T]) PushFront(value T) *Node[T] {
+	newNode := &Node[T]{
+		value: value,
+		next:  l.first,
+		prev:  nil,
 	}
-
-	return nil
+	l.link(newNode)
+	return newNode
 }
 
 func (l *LinkedList[T]) InsertAfter(node *Node[T], value T) {
@@ -69,10 +81,7 @@ func (l *LinkedList[T]) InsertAfter(node *Node[T], value T) {
 		next:  node.next,
 		prev:  node,
 	}
-	node.next = newNode
-	if newNode.next != nil {
-		newNode.next.prev = newNode
-	}
+	l.link(newNode)
 }
 
 func (l *LinkedList[T]) InsertBefore(node *Node[T], value T) {
@@ -81,17 +90,25 @@ func (l *LinkedList[T]) InsertBefore(node *Node[T], value T) {
 		next:  node,
 		prev:  node.prev,
 	}
-	if node.prev != nil {
-		node.prev.next = newNode
-	} else {
-		l.first = newNode
+	l.link(newNode)
+}
+
+// Moves a node that is part of the list to the front of the list.
+func (l *LinkedList[T]) MoveToFront(node *Node[T]) {
+	if l.first == node {
+		return
 	}
-	node.prev = newNode
+	l.Remove(node)
+	node.prev = nil
+	node.next = l.first
+	l.link(node)
 }
 
 func (l *LinkedList[T]) Remove(node *Node[T]) {
 	if node.next != nil {
 		node.next.prev = node.prev
+	} else {
+		l.last = node.prev
 	}
 
 	if node.prev != nil {
@@ -99,6 +116,23 @@ func (l *LinkedList[T]) Remove(node *Node[T]) {
 	} else {
 		l.first = node.Next()
 	}
+	l.len -= 1
+}
+
+// Links a node with its prev and next pointers set up into the list.
+func (l *LinkedList[T]) link(node *Node[T]) {
+	if node.next != nil {
+		node.next.prev = node
+	} else {
+		l.last = node
+	}
+
+	if node.prev != nil {
+		node.prev.next = node
+	} else {
+		l.first = node
+	}
+	l.len += 1
 }
 
 func (l *LinkedList[T]) toArray() []T {
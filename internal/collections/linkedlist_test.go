@@ -135,3 +135,49 @@ func TestKeepsLinksCorrect(t *T) {
 
 	assert.Equal(t, []int{8, 7, 4, 1, 2, 8, 7, 4, 1, 2}, l.toArray(), "Should match")
 }
+
+func TestTracksLast(t *T) {
+	l := NewLinkedList[int]()
+	l.Insert(0, 1)
+	l.Insert(1, 2)
+	l.InsertAfter(l.Last(), 3)
+
+	assert.Equal(t, 3, l.Last().Value(), "Last should be 3")
+	assert.Equal(t, 3, l.Len(), "Should have 3 elements")
+
+	l.Remove(l.Last())
+	assert.Equal(t, 2, l.Last().Value(), "Last should be 2 after removal")
+	assert.Nil(t, l.Last().Next(), "Last should have nil next")
+
+	l.Remove(l.First())
+	l.Remove(l.First())
+	assert.Nil(t, l.First(), "Should be empty")
+	assert.Nil(t, l.Last(), "Should be empty")
+	assert.Equal(t, 0, l.Len(), "Should be empty")
+}
+
+func TestPushFront(t *T) {
+	l := NewLinkedList[int]()
+	n := l.PushFront(1)
+	l.PushFront(2)
+
+	assert.Equal(t, 1, n.Value(), "Should return the inserted node")
+	assert.Equal(t, []int{2, 1}, l.toArray())
+	assert.Equal(t, 1, l.Last().Value(), "First pushed should be last")
+}
+
+func TestMoveToFront(t *T) {
+	l := NewLinkedList[int]()
+	l.Insert(0, 1)
+	l.Insert(1, 2)
+	l.Insert(2, 3)
+
+	l.MoveToFront(l.Last())
+	assert.Equal(t, []int{3, 1, 2}, l.toArray())
+	assert.Equal(t, 2, l.Last().Value(), "Last should be 2")
+
+	l.MoveToFront(l.First().Next())
+	assert.Equal(t, []int{1, 3, 2}, l.toArray())
+	assert.Nil(t, l.First().Prev(), "First should have nil prev")
+	assert.Equal(t, 3, l.Len(), "Should keep length")
+}
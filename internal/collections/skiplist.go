@@ -7,32 +7,68 @@ import (
 	"golang.org/x/exp/constraints"
 )
 
-type SkiplistElement[TKey constraints.Ordered, TValue any] struct {
+type SkiplistElement[TKey any, TValue any] struct {
 	key   *TKey
 	value *TValue
 	next  []*SkiplistElement[TKey, TValue]
+	// The element before this one on the bottom level, the head of the list
+	// if this is the first element
+	prev *SkiplistElement[TKey, TValue]
 }
 
-func (e SkiplistElement[TKey, TValue]) Next() *SkiplistElement[TKey, TValue] {
+// Returns the next element, nil if this is the last one. An element removed
+// from the list still links to the element that was after it, so iterating
+// can continue past it.
+func (e *SkiplistElement[TKey, TValue]) Next() *SkiplistElement[TKey, TValue] {
 	return e.next[0]
 }
 
-func (e SkiplistElement[TKey, TValue]) Value() (*TKey, *TValue) {
+// Returns the previous element, nil if this is the first one.
+func (e *SkiplistElement[TKey, TValue]) Prev() *SkiplistElement[TKey, TValue] {
+	if e.prev == nil || e.prev.key == nil {
+		return nil
+	}
+	return e.prev
+}
+
+func (e *SkiplistElement[TKey, TValue]) Value() (*TKey, *TValue) {
 	return e.key, e.value
 }
 
 // A SkipList is a probabilistic data structure that offers efficient
 // inserts and lookups for keys. Keys are stored in ascending order
-// and the elements can be iterated over.
-type SkipList[TKey constraints.Ordered, TValue any] struct {
+// and the elements can be iterated over in both directions.
+//
+// The methods of the list can be called concurrently, but elements must not
+// be iterated while the list is modified. Memtables use ConcurrentSkipList,
+// which can be iterated during inserts, this is a standalone collection.
+type SkipList[TKey any, TValue any] struct {
 	head             SkiplistElement[TKey, TValue]
 	numLayers        int
 	layerProbability float32
 	numEntries       int
 	lock             *sync.RWMutex
+	// Returns a negative number if a is before b, 0 if they're equal and a
+	// positive number if a is after b
+	compare func(a TKey, b TKey) int
 }
 
 func NewSkipList[TKey constraints.Ordered, TValue any](numLayers int) SkipList[TKey, TValue] {
+	return NewSkipListFunc[TKey, TValue](numLayers, func(a TKey, b TKey) int {
+		if a < b {
+			return -1
+		}
+		if a > b {
+			return 1
+		}
+		return 0
+	})
+}
+
+// Creates a SkipList ordering its keys by compare, which returns a negative
+// number if a is before b, 0 if they're equal and a positive number if a is
+// after b.
+func NewSkipListFunc[TKey any, TValue any](numLayers int, compare func(a TKey, b TKey) int) SkipList[TKey, TValue] {
 	next := make([]*SkiplistElement[TKey, TValue], numLayers)
 	head := SkiplistElement[TKey, TValue]{
 		key:   nil,
@@ -45,29 +81,50 @@ func NewSkipList[TKey constraints.Ordered, TValue any](numLayers int) SkipList[T
 		layerProbability: 0.5,
 		numEntries:       0,
 		lock:             &sync.RWMutex{},
+		compare:          compare,
 	}
 }
 
-func (l SkipList[TKey, TValue]) Get(key TKey) (*TValue, bool) {
-	l.lock.RLock()
-	defer l.lock.RUnlock()
+// Returns the last element with a key before key on every level, the head
+// of the list if there is none. The lock must be held.
+func (l *SkipList[TKey, TValue]) findBefore(key TKey) []*SkiplistElement[TKey, TValue] {
+	update := make([]*SkiplistElement[TKey, TValue], l.numLayers)
+	node := &l.head
+	for i := l.numLayers - 1; i >= 0; i-- {
+		for node.next[i] != nil && l.compare(*node.next[i].key, key) < 0 {
+			node = node.next[i]
+		}
+
+		update[i] = node
+	}
+	return update
+}
 
+// Returns the last element with a key before key, the head of the list if
+// there is none. The lock must be held.
+func (l *SkipList[TKey, TValue]) lastBefore(key TKey) *SkiplistElement[TKey, TValue] {
 	node := &l.head
 	for i := l.numLayers - 1; i >= 0; i-- {
-		for node.next[i] != nil && *node.next[i].key < key {
+		for node.next[i] != nil && l.compare(*node.next[i].key, key) < 0 {
 			node = node.next[i]
 		}
 	}
+	return node
+}
+
+func (l *SkipList[TKey, TValue]) Get(key TKey) (*TValue, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
 
-	final := node.next[0]
-	if final != nil && final.key != nil && *final.key == key {
+	final := l.lastBefore(key).next[0]
+	if final != nil && final.key != nil && l.compare(*final.key, key) == 0 {
 		return final.value, true
 	} else {
 		return nil, false
 	}
 }
 
-func (l SkipList[TKey, TValue]) randomNumLevels() int {
+func (l *SkipList[TKey, TValue]) randomNumLevels() int {
 	levels := 1
 
 	for rand.Float32() < l.layerProbability && levels < l.numLayers {
@@ -83,20 +140,11 @@ func (l *SkipList[TKey, TValue]) Insert(key TKey, value TValue) *TValue {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 
-	update := make([]*SkiplistElement[TKey, TValue], len(l.head.next))
-	node := &l.head
-	for i := l.numLayers - 1; i >= 0; i-- {
-		for node.next[i] != nil && *node.next[i].key < key {
-			node = node.next[i]
-		}
-
-		update[i] = node
-	}
-
-	final := node.next[0]
-	if final != nil && final.key != nil && *final.key == key {
-		oldValue := node.value
-		node.value = &value
+	update := l.findBefore(key)
+	final := update[0].next[0]
+	if final != nil && final.key != nil && l.compare(*final.key, key) == 0 {
+		oldValue := final.value
+		final.value = &value
 		return oldValue
 	} else {
 		numLevels := l.randomNumLevels()
@@ -104,20 +152,91 @@ func (l *SkipList[TKey, TValue]) Insert(key TKey, value TValue) *TValue {
 			key:   &key,
 			value: &value,
 			next:  make([]*SkiplistElement[TKey, TValue], numLevels),
+			prev:  update[0],
 		}
 		for i := 0; i < numLevels; i++ {
 			newNode.next[i] = update[i].next[i]
 			update[i].next[i] = newNode
 		}
+		if newNode.next[0] != nil {
+			newNode.next[0].prev = newNode
+		}
 		l.numEntries += 1
 		return nil
 	}
 }
 
-func (l SkipList[TKey, TValue]) Iterate() *SkiplistElement[TKey, TValue] {
+// Removes the item with key from the SkipList. Returns its value, nil if
+// there was no item with the key.
+func (l *SkipList[TKey, TValue]) Remove(key TKey) *TValue {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	update := l.findBefore(key)
+	node := update[0].next[0]
+	if node == nil || l.compare(*node.key, key) != 0 {
+		return nil
+	}
+
+	for i := range node.next {
+		update[i].next[i] = node.next[i]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node.prev
+	}
+	// The links of the removed element are kept, iterators positioned on
+	// it continue with the elements that were around it
+	l.numEntries -= 1
+	return node.value
+}
+
+// Returns the first element, nil if the list is empty.
+func (l *SkipList[TKey, TValue]) Iterate() *SkiplistElement[TKey, TValue] {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
 	return l.head.next[0]
 }
 
-func (l SkipList[TKey, TValue]) Len() int {
+// Returns the last element, nil if the list is empty. Iterate backwards
+// from it with Prev.
+func (l *SkipList[TKey, TValue]) Last() *SkiplistElement[TKey, TValue] {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	node := &l.head
+	for i := l.numLayers - 1; i >= 0; i-- {
+		for node.next[i] != nil {
+			node = node.next[i]
+		}
+	}
+	if node == &l.head {
+		return nil
+	}
+	return node
+}
+
+// Returns the first element with a key at or after key, nil if there is
+// none.
+func (l *SkipList[TKey, TValue]) Seek(key TKey) *SkiplistElement[TKey, TValue] {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.lastBefore(key).next[0]
+}
+
+// Returns the last element with a key before key, nil if there is none.
+func (l *SkipList[TKey, TValue]) SeekLT(key TKey) *SkiplistElement[TKey, TValue] {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	node := l.lastBefore(key)
+	if node == &l.head {
+		return nil
+	}
+	return node
+}
+
+func (l *SkipList[TKey, TValue]) Len() int {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
 	return l.numEntries
 }
@@ -1,6 +1,7 @@
 package collections
 
 import (
+	"bytes"
 	. "testing"
 
 	"github.com/stretchr/testify/assert"
@@ -43,3 +44,118 @@ func TestIteratesInSortedKeyOrder(t *T) {
 	k, _ = i.Value()
 	assert.Equal(t, 5, *k)
 }
+
+func TestUpdatesExistingItem(t *T) {
+	sl := NewSkipList[int, int](4)
+	sl.Insert(1, 1)
+	sl.Insert(2, 2)
+
+	old := sl.Insert(2, 3)
+	assert.Equal(t, 2, *old, "Should return the old value")
+
+	v, _ := sl.Get(2)
+	assert.Equal(t, 3, *v, "Should update the value")
+	v, _ = sl.Get(1)
+	assert.Equal(t, 1, *v, "Should not touch other items")
+	assert.Equal(t, 2, sl.Len())
+}
+
+func TestOrdersByCompareFunc(t *T) {
+	sl := NewSkipListFunc[[]byte, int](4, func(a []byte, b []byte) int {
+		return bytes.Compare(b, a)
+	})
+	sl.Insert([]byte{1}, 1)
+	sl.Insert([]byte{3}, 3)
+	sl.Insert([]byte{2}, 2)
+	sl.Insert([]byte{3}, 4)
+
+	v, exists := sl.Get([]byte{3})
+	assert.True(t, exists)
+	assert.Equal(t, 4, *v)
+
+	keys := [][]byte{}
+	for i := sl.Iterate(); i != nil; i = i.Next() {
+		k, _ := i.Value()
+		keys = append(keys, *k)
+	}
+	assert.Equal(t, [][]byte{{3}, {2}, {1}}, keys)
+}
+
+func skipListKeys(sl *SkipList[int, int]) []int {
+	keys := []int{}
+	for i := sl.Iterate(); i != nil; i = i.Next() {
+		k, _ := i.Value()
+		keys = append(keys, *k)
+	}
+	return keys
+}
+
+func TestSeeksToKeys(t *T) {
+	sl := NewSkipList[int, int](4)
+	for _, k := range []int{10, 20, 30, 40} {
+		sl.Insert(k, k)
+	}
+
+	k, _ := sl.Seek(20).Value()
+	assert.Equal(t, 20, *k, "Should find an existing key")
+	k, _ = sl.Seek(25).Value()
+	assert.Equal(t, 30, *k, "Should find the next key")
+	k, _ = sl.Seek(5).Value()
+	assert.Equal(t, 10, *k)
+	assert.Nil(t, sl.Seek(45))
+
+	k, _ = sl.SeekLT(20).Value()
+	assert.Equal(t, 10, *k, "Should find the key before an existing key")
+	k, _ = sl.SeekLT(45).Value()
+	assert.Equal(t, 40, *k)
+	assert.Nil(t, sl.SeekLT(10))
+}
+
+func TestIteratesBackwards(t *T) {
+	sl := NewSkipList[int, int](4)
+	assert.Nil(t, sl.Last())
+	for _, k := range []int{3, 1, 5, 2, 4} {
+		sl.Insert(k, k)
+	}
+
+	keys := []int{}
+	for i := sl.Last(); i != nil; i = i.Prev() {
+		k, _ := i.Value()
+		keys = append(keys, *k)
+	}
+	assert.Equal(t, []int{5, 4, 3, 2, 1}, keys)
+}
+
+func TestRemovesItems(t *T) {
+	sl := NewSkipList[int, int](4)
+	for _, k := range []int{1, 2, 3, 4} {
+		sl.Insert(k, k*10)
+	}
+
+	old := sl.Remove(2)
+	assert.Equal(t, 20, *old, "Should return the removed value")
+	assert.Nil(t, sl.Remove(2), "Should not remove missing keys")
+	sl.Remove(4)
+
+	_, exists := sl.Get(2)
+	assert.False(t, exists)
+	assert.Equal(t, []int{1, 3}, skipListKeys(&sl))
+	assert.Equal(t, 2, sl.Len())
+
+	k, _ := sl.Last().Value()
+	assert.Equal(t, 3, *k)
+	k, _ = sl.Last().Prev().Value()
+	assert.Equal(t, 1, *k, "Should relink the previous element")
+}
+
+func TestIteratesPastRemovedElement(t *T) {
+	sl := NewSkipList[int, int](4)
+	for _, k := range []int{1, 2, 3} {
+		sl.Insert(k, k)
+	}
+
+	i := sl.Seek(2)
+	sl.Remove(2)
+	k, _ := i.Next().Value()
+	assert.Equal(t, 3, *k)
+}
@@ -2,16 +2,28 @@ package db
 
 import (
 	"path"
+	"time"
 
 	"github.com/lindend/distdb/internal/lsmtree"
 )
 
+// A collection of keys and values. Keys are arbitrary bytes, ordered by the
+// comparator of the collection.
 type Collection struct {
 	lsmt *lsmtree.LsmTree
+	// Called when the collection is closed, nil if it isn't part of a
+	// Database
+	onClose func()
 }
 
 func NewCollection(rootDir string, name string) (*Collection, error) {
-	tree, err := lsmtree.NewLsmTree(path.Join(rootDir, name))
+	return NewCollectionWithOptions(rootDir, name, lsmtree.DefaultOptions())
+}
+
+// Creates or opens a collection with the given options for its tree. A
+// collection must always be opened with the same comparator.
+func NewCollectionWithOptions(rootDir string, name string, options lsmtree.Options) (*Collection, error) {
+	tree, err := lsmtree.NewLsmTree(path.Join(rootDir, name), options)
 	if err != nil {
 		return nil, err
 	}
@@ -20,3 +32,62 @@ func NewCollection(rootDir string, name string) (*Collection, error) {
 		lsmt: tree,
 	}, nil
 }
+
+func (c *Collection) Get(key []byte) ([]byte, bool, error) {
+	return c.lsmt.Get(string(key))
+}
+
+func (c *Collection) Set(key []byte, data []byte) error {
+	return c.lsmt.Set(string(key), data)
+}
+
+func (c *Collection) Delete(key []byte) error {
+	return c.lsmt.Delete(string(key))
+}
+
+// Sets the value of a key that expires after ttl.
+func (c *Collection) SetWithTTL(key []byte, data []byte, ttl time.Duration) error {
+	return c.lsmt.SetWithTTL(string(key), data, ttl)
+}
+
+// Sets the value of a key if it doesn't have one. Returns false if it did.
+func (c *Collection) SetIfAbsent(key []byte, data []byte) (bool, error) {
+	return c.lsmt.SetIfAbsent(string(key), data)
+}
+
+// Sets the value of a key if its current value is expected. Returns false if
+// it wasn't.
+func (c *Collection) SetIfEquals(key []byte, expected []byte, data []byte) (bool, error) {
+	return c.lsmt.SetIfEquals(string(key), expected, data)
+}
+
+// Deletes a key if its current value is expected. Returns false if it wasn't.
+func (c *Collection) DeleteIfEquals(key []byte, expected []byte) (bool, error) {
+	return c.lsmt.DeleteIfEquals(string(key), expected)
+}
+
+// Returns the value of a key along with its version, which changes on every
+// write of the key.
+func (c *Collection) GetWithVersion(key []byte) ([]byte, uint64, bool, error) {
+	return c.lsmt.GetWithVersion(string(key))
+}
+
+// Sets the value of a key if its version, as returned by GetWithVersion, is
+// unchanged. Returns false if it changed.
+func (c *Collection) SetIfVersion(key []byte, version uint64, data []byte) (bool, error) {
+	return c.lsmt.SetIfVersion(string(key), version, data)
+}
+
+// Deletes a key if its version, as returned by GetWithVersion, is unchanged.
+// Returns false if it changed.
+func (c *Collection) DeleteIfVersion(key []byte, version uint64) (bool, error) {
+	return c.lsmt.DeleteIfVersion(string(key), version)
+}
+
+// Releases the files of the collection. It can't be used afterwards.
+func (c *Collection) Close() error {
+	if c.onClose != nil {
+		c.onClose()
+	}
+	return c.lsmt.Close()
+}
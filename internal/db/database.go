@@ -0,0 +1,105 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/lindend/distdb/internal/lsmtree"
+)
+
+// Options used when opening a Database.
+type DatabaseOptions struct {
+	// Options for the trees of the collections of the database.
+	CollectionOptions lsmtree.Options
+	// Limit in bytes of the memory used by the memtables of all collections
+	// together. If 0, each collection only limits the size of its own
+	// memtable.
+	WriteBufferSize int64
+}
+
+func DefaultDatabaseOptions() DatabaseOptions {
+	return DatabaseOptions{
+		CollectionOptions: lsmtree.DefaultOptions(),
+		WriteBufferSize:   256 * lsmtree.Megabyte,
+	}
+}
+
+// A set of collections in the same directory, sharing a limit of the memory
+// used by their memtables.
+type Database struct {
+	rootDir string
+	options DatabaseOptions
+	// Shared by the trees of all collections, nil if memtables aren't
+	// limited together
+	writeBuffers *lsmtree.WriteBufferManager
+	lock         sync.Mutex
+	// Open collections by name
+	collections map[string]*Collection
+}
+
+func OpenDatabase(rootDir string, options DatabaseOptions) (*Database, error) {
+	if err := os.MkdirAll(rootDir, 0770); err != nil {
+		return nil, err
+	}
+	d := &Database{
+		rootDir:     rootDir,
+		options:     options,
+		collections: map[string]*Collection{},
+	}
+	if options.WriteBufferSize > 0 {
+		d.writeBuffers = lsmtree.NewWriteBufferManager(options.WriteBufferSize)
+		d.options.CollectionOptions.WriteBufferManager = d.writeBuffers
+	}
+	return d, nil
+}
+
+// Returns the collection with a name, opening or creating it if it isn't
+// open.
+func (d *Database) Collection(name string) (*Collection, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if c, ok := d.collections[name]; ok {
+		return c, nil
+	}
+	c, err := NewCollectionWithOptions(d.rootDir, name, d.options.CollectionOptions)
+	if err != nil {
+		return nil, err
+	}
+	c.onClose = func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		delete(d.collections, name)
+	}
+	d.collections[name] = c
+	return c, nil
+}
+
+// Bytes of memory used by the memtables of all open collections, 0 if they
+// aren't limited together.
+func (d *Database) WriteBufferUsage() int64 {
+	if d.writeBuffers == nil {
+		return 0
+	}
+	return d.writeBuffers.Usage()
+}
+
+// Closes every open collection. The database can't be used afterwards.
+func (d *Database) Close() error {
+	d.lock.Lock()
+	collections := make([]*Collection, 0, len(d.collections))
+	for _, c := range d.collections {
+		collections = append(collections, c)
+	}
+	d.lock.Unlock()
+
+	var err error
+	for _, c := range collections {
+		err = errors.Join(err, c.Close())
+	}
+	if d.writeBuffers != nil {
+		d.writeBuffers.Close()
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"fmt"
+	. "testing"
+	"time"
+
+	"github.com/lindend/distdb/internal/lsmtree"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectionsShareWriteBuffer(t *T) {
+	options := DefaultDatabaseOptions()
+	options.CollectionOptions.MergeInterval = 0
+	options.WriteBufferSize = 2 * lsmtree.Megabyte
+	d, err := OpenDatabase(t.TempDir(), options)
+	assert.Nil(t, err)
+
+	users, err := d.Collection("users")
+	assert.Nil(t, err)
+	orders, err := d.Collection("orders")
+	assert.Nil(t, err)
+	same, err := d.Collection("users")
+	assert.Nil(t, err)
+	assert.Same(t, users, same)
+
+	// Neither collection exceeds the limit on its own, together they do
+	value := make([]byte, 10*lsmtree.Kilobyte)
+	for i := 0; i < 150; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		assert.Nil(t, users.Set(key, value))
+		assert.Nil(t, orders.Set(key, value))
+	}
+
+	assert.Eventually(t, func() bool {
+		return d.WriteBufferUsage() <= options.WriteBufferSize
+	}, 10*time.Second, 10*time.Millisecond)
+	flushed := users.lsmt.LayerSizes()[1] + orders.lsmt.LayerSizes()[1]
+	assert.Greater(t, flushed, uint64(0), "Should flush a memtable once the shared limit is exceeded")
+	for _, c := range []*Collection{users, orders} {
+		data, exists, err := c.Get([]byte("key042"))
+		assert.Nil(t, err)
+		assert.True(t, exists)
+		assert.Len(t, data, 10*lsmtree.Kilobyte)
+	}
+
+	assert.Nil(t, d.Close())
+	assert.Equal(t, int64(0), d.WriteBufferUsage(), "Should release the memory of closed collections")
+}
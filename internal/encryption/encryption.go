@@ -0,0 +1,91 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Provides the AES keys data is encrypted with, 16, 24 or 32 bytes long.
+// Keys are identified by an id stored next to the data they encrypt, so
+// data encrypted with older keys can still be decrypted after the current
+// key is rotated. Must be safe to call concurrently.
+type KeyProvider interface {
+	// Returns the key to encrypt new data with, and its id.
+	CurrentKey() (id string, key []byte, err error)
+	// Returns the key with an id.
+	Key(id string) ([]byte, error)
+}
+
+type staticKeyProvider struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// Returns a provider of a fixed set of keys by id, encrypting new data with
+// the key with id currentID.
+func NewStaticKeyProvider(currentID string, keys map[string][]byte) (KeyProvider, error) {
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("missing current key %v", currentID)
+	}
+	return staticKeyProvider{currentID, keys}, nil
+}
+
+func (p staticKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.currentID, p.keys[p.currentID], nil
+}
+
+func (p staticKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %v", id)
+	}
+	return key, nil
+}
+
+// Encrypts and authenticates data with AES-GCM. Every sealed message gets a
+// random nonce, stored in front of it.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead}, nil
+}
+
+// Size in bytes a sealed message is larger than its plaintext.
+func (c *Cipher) Overhead() int {
+	return c.aead.NonceSize() + c.aead.Overhead()
+}
+
+// Encrypts plaintext and appends it to dst. additionalData is authenticated
+// but not encrypted, the same data must be passed to Open.
+func (c *Cipher) Seal(dst []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	start := len(dst)
+	dst = append(dst, make([]byte, nonceSize)...)
+	nonce := dst[start : start+nonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(dst, nonce, plaintext, additionalData), nil
+}
+
+// Decrypts a message sealed by Seal, failing if it has been modified.
+func (c *Cipher) Open(sealed []byte, additionalData []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("sealed message is too short")
+	}
+	return c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData)
+}
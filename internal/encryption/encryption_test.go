@@ -0,0 +1,39 @@
+package encryption
+
+import (
+	"bytes"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipherSealsAndOpens(t *T) {
+	keys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	assert.Nil(t, err)
+	id, key, err := keys.CurrentKey()
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", id)
+
+	c, err := NewCipher(key)
+	assert.Nil(t, err)
+	sealed, err := c.Seal([]byte("prefix"), []byte("secret"), []byte("ad"))
+	assert.Nil(t, err)
+	assert.Equal(t, len("prefix")+len("secret")+c.Overhead(), len(sealed))
+	assert.False(t, bytes.Contains(sealed, []byte("secret")))
+
+	opened, err := c.Open(sealed[len("prefix"):], []byte("ad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", string(opened))
+
+	// Modified messages and other additional data fail to open
+	_, err = c.Open(sealed[len("prefix"):], []byte("other"))
+	assert.NotNil(t, err)
+	sealed[len(sealed)-1] ^= 1
+	_, err = c.Open(sealed[len("prefix"):], []byte("ad"))
+	assert.NotNil(t, err)
+
+	_, err = keys.Key("k2")
+	assert.NotNil(t, err)
+	_, err = NewStaticKeyProvider("k2", map[string][]byte{"k1": key})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,189 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Size in bytes of data keys, giving AES-256
+const dataKeySize = 32
+
+// A data key encrypted by a key of a KeyProvider, stored next to the data
+// the data key encrypts. Rotating the keys of the provider only needs the
+// data keys to be wrapped again, not the data itself.
+type WrappedKey struct {
+	// Id of the key of the provider the data key is encrypted with
+	KeyID string
+	Key   []byte
+}
+
+// Creates a random data key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Encrypts a data key with the current key of a provider.
+func WrapKey(keys KeyProvider, dataKey []byte) (WrappedKey, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	wrapped, err := c.Seal(nil, dataKey, []byte(id))
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	return WrappedKey{KeyID: id, Key: wrapped}, nil
+}
+
+// Decrypts a data key wrapped by WrapKey.
+func UnwrapKey(keys KeyProvider, wrapped WrappedKey) ([]byte, error) {
+	key, err := keys.Key(wrapped.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := c.Open(wrapped.Key, []byte(wrapped.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %v: %w", wrapped.KeyID, err)
+	}
+	return dataKey, nil
+}
+
+const (
+	keyFileExtension   = ".key"
+	currentKeyFileName = "CURRENT"
+)
+
+// Keeps its keys in a local directory, one file per key named by its id,
+// holding the hex encoded key. The id of the current key is in the file
+// CURRENT. Meant for development and single machine deployments, the keys
+// are only as safe as the directory.
+type FileKeyProvider struct {
+	dir     string
+	lock    sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// Opens the keys in dir, creating the directory and a first key if there
+// are none.
+func OpenFileKeyProvider(dir string) (*FileKeyProvider, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &FileKeyProvider{dir: dir, keys: map[string][]byte{}}
+	for _, e := range entries {
+		id, isKey := strings.CutSuffix(e.Name(), keyFileExtension)
+		if !isKey {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		p.keys[id], err = hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %v: %w", id, err)
+		}
+	}
+
+	if len(p.keys) == 0 {
+		if _, err := p.Rotate(); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	current, err := os.ReadFile(path.Join(dir, currentKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+	p.current = strings.TrimSpace(string(current))
+	if _, ok := p.keys[p.current]; !ok {
+		return nil, fmt.Errorf("current key %v is missing", p.current)
+	}
+	return p, nil
+}
+
+// Creates a new key and makes it the current one. Returns its id. Older
+// keys are kept, to decrypt the data encrypted with them.
+func (p *FileKeyProvider) Rotate() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key, err := NewDataKey()
+	if err != nil {
+		return "", err
+	}
+	id := fmt.Sprintf("%06d", p.lastKeyNumber()+1)
+	err = os.WriteFile(path.Join(p.dir, id+keyFileExtension), []byte(hex.EncodeToString(key)), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	// Replace CURRENT atomically, a partially written id would leave the
+	// provider without a current key
+	tmpFileName := path.Join(p.dir, currentKeyFileName+".tmp")
+	if err := os.WriteFile(tmpFileName, []byte(id), 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpFileName, path.Join(p.dir, currentKeyFileName)); err != nil {
+		return "", err
+	}
+
+	p.keys[id] = key
+	p.current = id
+	return id, nil
+}
+
+// Largest number used as the id of a key, 0 if there is none.
+func (p *FileKeyProvider) lastKeyNumber() int {
+	numbers := []int{0}
+	for id := range p.keys {
+		if n, err := strconv.Atoi(id); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers[len(numbers)-1]
+}
+
+func (p *FileKeyProvider) CurrentKey() (string, []byte, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *FileKeyProvider) Key(id string) ([]byte, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.New("unknown key " + id)
+	}
+	return key, nil
+}
@@ -0,0 +1,44 @@
+package encryption
+
+import (
+	"bytes"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileKeyProviderRotatesKeys(t *T) {
+	dir := t.TempDir()
+	keys, err := OpenFileKeyProvider(dir)
+	assert.Nil(t, err)
+
+	dataKey, err := NewDataKey()
+	assert.Nil(t, err)
+	wrapped, err := WrapKey(keys, dataKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "000001", wrapped.KeyID)
+	assert.False(t, bytes.Contains(wrapped.Key, dataKey))
+
+	id, err := keys.Rotate()
+	assert.Nil(t, err)
+	assert.Equal(t, "000002", id)
+
+	// Keys and the current key survive reopening
+	keys, err = OpenFileKeyProvider(dir)
+	assert.Nil(t, err)
+	current, _, err := keys.CurrentKey()
+	assert.Nil(t, err)
+	assert.Equal(t, "000002", current)
+
+	unwrapped, err := UnwrapKey(keys, wrapped)
+	assert.Nil(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	// A data key can't be unwrapped with another key
+	wrapped.KeyID = "000002"
+	_, err = UnwrapKey(keys, wrapped)
+	assert.NotNil(t, err)
+	wrapped.KeyID = "000003"
+	_, err = UnwrapKey(keys, wrapped)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,125 @@
+package lsmtree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lindend/distdb/internal/wal"
+)
+
+type ChangeKind int
+
+const (
+	ChangePut ChangeKind = iota
+	ChangeDelete
+	// Deletes every key from Key up to, but not including, End
+	ChangeDeleteRange
+	// Value is an operand for the merge operator of the tree
+	ChangeMerge
+)
+
+// A write to the tree, read from its WAL.
+type Change struct {
+	Kind ChangeKind
+	Key  string
+	// End of the range of a ChangeDeleteRange
+	End   string
+	Value []byte
+	// Unix time in nanoseconds when the value expires, 0 if it never does
+	ExpiresAt int64
+	// Sequence number of the write, the version it gave the key
+	Seq uint64
+	// LSN of the record of the change
+	lsn uint64
+}
+
+var ErrChangesUnavailable = errors.New("changes are no longer in the WAL")
+
+// A stream of the writes to a tree, in the order they were written to the
+// WAL.
+type ChangeStream struct {
+	tree    *LsmTree
+	reader  *wal.Reader
+	fromSeq uint64
+	// Set once the first record has been read
+	started bool
+}
+
+// Returns a stream of the writes to the tree with sequence numbers from
+// fromSeq onwards. Changes are read from the WAL, starting at its oldest
+// record, which only has the writes that haven't been merged into layer 0
+// yet. The WAL is kept from there on until the stream acknowledges the
+// changes or is closed, so a stream that falls behind keeps the WAL from
+// being deleted. Values moved by value log garbage collection are written
+// again with their original sequence number, so they show up as puts again.
+func (tree *LsmTree) Changes(fromSeq uint64) (*ChangeStream, error) {
+	reader, err := tree.log.NewReader(0)
+	if err != nil {
+		return nil, err
+	}
+	return &ChangeStream{tree: tree, reader: reader, fromSeq: fromSeq}, nil
+}
+
+// Returns the next change, waiting for it to be written if the stream has
+// returned every change. Returns ErrChangesUnavailable if the oldest changes
+// requested have already been removed from the WAL.
+func (s *ChangeStream) Next(ctx context.Context) (Change, error) {
+	for {
+		e, err := s.reader.Next(ctx)
+		if err != nil {
+			return Change{}, err
+		}
+
+		// Every write gets the next sequence number, so if the oldest
+		// record is newer than fromSeq the writes between them are gone
+		first := !s.started
+		s.started = true
+		if first && e.Seq > s.fromSeq && s.fromSeq > 0 {
+			return Change{}, fmt.Errorf("%w: requested from %v, the oldest change is %v", ErrChangesUnavailable, s.fromSeq, e.Seq)
+		}
+		if e.Seq < s.fromSeq {
+			continue
+		}
+
+		return s.toChange(e)
+	}
+}
+
+func (s *ChangeStream) toChange(e wal.WALEntry) (Change, error) {
+	c := Change{Key: e.Key, ExpiresAt: e.ExpiresAt, Seq: e.Seq, lsn: e.LSN}
+	switch e.Kind {
+	case RecordKindWrite, RecordKindValuePointer:
+		release := s.tree.acquireValueLog()
+		defer release()
+
+		c.Kind = ChangePut
+		var err error
+		c.Value, err = s.tree.resolveValue(e.Kind, e.Data)
+		if err != nil {
+			return Change{}, err
+		}
+	case RecordKindDelete:
+		c.Kind = ChangeDelete
+	case RecordKindRangeDelete:
+		c.Kind = ChangeDeleteRange
+		c.End = string(e.Data)
+	case RecordKindMerge:
+		c.Kind = ChangeMerge
+		c.Value = e.Data
+	default:
+		return Change{}, fmt.Errorf("unknown record kind %v in WAL", e.Kind)
+	}
+	return c, nil
+}
+
+// Acknowledges c and every change before it, letting the WAL segments with
+// only acknowledged changes be deleted.
+func (s *ChangeStream) Ack(c Change) {
+	s.reader.Ack(c.lsn)
+}
+
+// Closes the stream and stops keeping the WAL for it.
+func (s *ChangeStream) Close() error {
+	return s.reader.Close()
+}
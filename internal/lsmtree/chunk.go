@@ -1,28 +1,137 @@
 package lsmtree
 
+import (
+	"sync/atomic"
+
+	"github.com/lindend/distdb/internal/sstable"
+)
+
 type chunkType int
 
 const (
-	chunkTypeSkiplist chunkType = 1
+	chunkTypeMemtable chunkType = 1
 	chunkTypeSSTable  chunkType = 2
 )
 
 type chunkIterator interface {
-	next() chunkIterator
+	// Returns an iterator at the next entry, nil if this is the last one.
+	// The chunk can't be read past an error.
+	next() (chunkIterator, error)
 	value() (uint64, string, []byte)
+	// Unix time in nanoseconds when the current entry expires, 0 if it
+	// never does
+	expiresAt() int64
+	// Sequence number of the current entry
+	seq() uint64
 }
 
 type chunk struct {
 	name      string
 	data      chunkData
 	chunkType chunkType
+	// Number of references to the chunk, the tree holds one while the chunk
+	// is part of it. The chunk's data is deleted when the last one is released.
+	refs *atomic.Int32
+}
+
+func newChunk(name string, data chunkData, chunkType chunkType) *chunk {
+	c := &chunk{
+		name:      name,
+		data:      data,
+		chunkType: chunkType,
+		refs:      &atomic.Int32{},
+	}
+	c.refs.Store(1)
+	return c
+}
+
+func (c *chunk) toJson() lsmChunkJson {
+	j := lsmChunkJson{
+		Name:      c.name,
+		ChunkType: c.chunkType,
+	}
+	if sl, ok := c.data.(*memtableChunk); ok {
+		j.FirstLSN = sl.firstLSN
+	}
+	if tbl, ok := c.data.(*sstableChunk); ok {
+		j.IngestedSeq = tbl.ingestedSeq
+	}
+	return j
+}
+
+func (c *chunk) ref() {
+	c.refs.Add(1)
+}
+
+func (c *chunk) unref() {
+	if c.refs.Add(-1) == 0 {
+		c.data.delete()
+	}
 }
 
 type chunkData interface {
-	get(key string) (uint64, []byte, bool, error)
-	set(key string, kind uint64, data []byte) error
+	// Returns the entry of a key, false if the chunk has none
+	get(key string) (entry, bool, error)
+	// Looks up a key like get, without copying the data of the entry. Data
+	// read from a table is borrowed through value, which must be released
+	// once the data isn't used anymore.
+	getBorrowed(key string, value *sstable.Value) (entry, bool, error)
+	set(e entry) error
+	// Largest sequence number of the entries of the chunk
+	maxSeq() uint64
 	size() uint64
-	iterator() chunkIterator
+	// Returns an iterator at the first entry, nil if the chunk is empty
+	iterator() (chunkIterator, error)
+	// Returns an iterator positioned at the first key >= key, nil if there
+	// is none
+	seek(key string) (chunkIterator, error)
 	numEntries() int64
+	// Range tombstones of the chunk. They hide keys in older chunks, but not
+	// the entries of the chunk itself.
+	rangeTombstones() []sstable.RangeTombstone
+	// Returns false if the chunk definitely has no keys starting with prefix
+	mayContainPrefix(prefix string) bool
+	// Returns the range of keys and range tombstones in the chunk, false if
+	// the chunk is empty
+	keyRange() (sstable.KeyRange, bool)
+	// Returns the properties of the chunk, false if it doesn't have any
+	properties() (sstable.TableProperties, bool)
 	delete() error
 }
+
+func coveredByTombstone(tombstones []sstable.RangeTombstone, key string, cmp sstable.Comparator) bool {
+	for _, t := range tombstones {
+		if t.Covers(key, cmp) {
+			return true
+		}
+	}
+	return false
+}
+
+// Looks up a key in a single chunk. A key missing from the chunk, but covered
+// by one of its range tombstones, is returned as deleted.
+func getFromChunk(c *chunk, key string, cmp sstable.Comparator) (entry, bool, error) {
+	e, exists, err := c.data.get(key)
+	if err != nil || exists {
+		return e, exists, err
+	}
+
+	if coveredByTombstone(c.data.rangeTombstones(), key, cmp) {
+		return entry{key: key, kind: RecordKindDelete}, true, nil
+	}
+	return entry{}, false, nil
+}
+
+// Looks up a key in a single chunk like getFromChunk, borrowing the data of
+// the entry through value.
+func getBorrowedFromChunk(c *chunk, key string, value *sstable.Value, cmp sstable.Comparator) (entry, bool, error) {
+	e, exists, err := c.data.getBorrowed(key, value)
+	if err != nil || exists {
+		return e, exists, err
+	}
+
+	if coveredByTombstone(c.data.rangeTombstones(), key, cmp) {
+		return entry{key: key, kind: RecordKindDelete}, true, nil
+	}
+	return entry{}, false, nil
+}
@@ -0,0 +1,87 @@
+package lsmtree
+
+import "github.com/lindend/distdb/internal/sstable"
+
+// Describes the merge a compaction filter is called from.
+type CompactionContext struct {
+	// Layer being merged
+	Layer int
+	// Layer the merged SSTable is added to
+	TargetLayer int
+	// Set if there is no older data below the merged SSTable
+	Bottommost bool
+}
+
+type CompactionDecision int
+
+const (
+	// Keep the entry as it is
+	CompactionKeep CompactionDecision = iota
+	// Remove the key. Unless the merge is bottommost a delete is written
+	// instead, so older values of the key don't reappear.
+	CompactionRemove
+	// Replace the value of the key with the returned value
+	CompactionChangeValue
+)
+
+// Called by merges for the newest value of every key, letting applications
+// drop expired or obsolete entries, or rewrite values, without scanning the
+// tree. Deletes and keys hidden by range deletes aren't passed to the
+// filter. Values stored in the value log are read before calling the
+// filter. Must be safe to call from the merge process.
+type CompactionFilter interface {
+	Filter(ctx CompactionContext, key string, value []byte) (CompactionDecision, []byte)
+}
+
+// Runs the compaction filter of the tree on an entry. Returns false if
+// nothing should be written for the entry.
+func (tree *LsmTree) filterMergedEntry(ctx CompactionContext, e entry) (entry, bool, error) {
+	filter := tree.options.CompactionFilter
+	if filter == nil || (e.kind != RecordKindWrite && e.kind != RecordKindValuePointer) {
+		return e, true, nil
+	}
+
+	value, err := tree.resolveValue(e.kind, e.data)
+	if err != nil {
+		return e, false, err
+	}
+
+	decision, newValue := filter.Filter(ctx, e.key, value)
+	switch decision {
+	case CompactionRemove:
+		e, keep := removeMergedEntry(ctx, e)
+		return e, keep, nil
+	case CompactionChangeValue:
+		return entry{key: e.key, kind: RecordKindWrite, data: newValue, expiresAt: e.expiresAt, seq: e.seq, source: e.source}, true, nil
+	}
+	return e, true, nil
+}
+
+// Removes an entry from a merge. Unless the merge is bottommost the entry is
+// replaced by a delete, so older values of the key don't reappear. Returns
+// false if nothing should be written for the entry.
+func removeMergedEntry(ctx CompactionContext, e entry) (entry, bool) {
+	if ctx.Bottommost {
+		return e, false
+	}
+	return entry{key: e.key, kind: RecordKindDelete, seq: e.seq, source: e.source}, true
+}
+
+// Writes what's left of an entry removed from a merge, if anything.
+func (tree *LsmTree) writeRemovedEntry(ctx CompactionContext, e entry, tbl *sstable.SSTableBuilder) error {
+	e, keep := removeMergedEntry(ctx, e)
+	if !keep {
+		return nil
+	}
+	return tree.writeMergedEntry(e, tbl)
+}
+
+// Writes an entry that survived the merge, after running the compaction
+// filter on it.
+func (tree *LsmTree) mergeEntry(ctx CompactionContext, e entry, tbl *sstable.SSTableBuilder) error {
+	e, keep, err := tree.filterMergedEntry(ctx, e)
+	if err != nil || !keep {
+		return err
+	}
+	return tree.writeMergedEntry(e, tbl)
+}
@@ -0,0 +1,256 @@
+package lsmtree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/lindend/distdb/internal/sstable"
+
+	"github.com/rs/zerolog/log"
+)
+
+type externalFile struct {
+	root     string
+	name     string
+	keyRange sstable.KeyRange
+	// Layer the table is placed in, and the name of its chunk there
+	layer     int
+	chunkName string
+}
+
+// Checks that the entries of an external table are in ascending order,
+// within the key range of the table and only of kinds that can be ingested.
+func validateExternalFile(tbl *sstable.SSTable, cmp sstable.Comparator) (sstable.KeyRange, bool, error) {
+	keyRange, exists := tbl.KeyRange()
+
+	it, err := tbl.Iterator()
+	prevKey := ""
+	for i := 0; err == nil; i++ {
+		kind, key, _ := it.Value()
+		if i > 0 && cmp.Compare(key, prevKey) <= 0 {
+			return keyRange, false, fmt.Errorf("key %q is not in ascending order", key)
+		}
+		if !exists || cmp.Compare(key, keyRange.Smallest) < 0 || cmp.Compare(key, keyRange.Largest) > 0 {
+			return keyRange, false, fmt.Errorf("key %q is outside of the key range of the table", key)
+		}
+		// Value pointers point into the value log of another tree
+		if kind != RecordKindWrite && kind != RecordKindDelete {
+			return keyRange, false, fmt.Errorf("key %q has unsupported record kind %v", key, kind)
+		}
+		prevKey = key
+		it, err = it.Next()
+	}
+	if err != io.EOF {
+		return keyRange, false, err
+	}
+
+	for _, t := range tbl.RangeTombstones() {
+		if cmp.Compare(t.Start, t.End) >= 0 || cmp.Compare(t.Start, keyRange.Smallest) < 0 || cmp.Compare(t.End, keyRange.Largest) > 0 {
+			return keyRange, false, fmt.Errorf("invalid range tombstone [%q, %q)", t.Start, t.End)
+		}
+	}
+	return keyRange, exists, nil
+}
+
+func (tree *LsmTree) loadExternalFile(p string) (*externalFile, error) {
+	root, name := filepath.Split(p)
+	// Tables written with another comparator fail to load
+	tbl, err := sstable.LoadSSTable(root, name, sstable.Options{Comparator: tree.cmp, KeyProvider: tree.options.KeyProvider})
+	if err != nil {
+		return nil, err
+	}
+	defer tbl.Close()
+
+	keyRange, exists, err := validateExternalFile(tbl, tree.cmp)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", p, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	return &externalFile{root: root, name: name, keyRange: keyRange}, nil
+}
+
+func chunksOverlap(chunks []*chunk, keyRange sstable.KeyRange, cmp sstable.Comparator) bool {
+	for _, c := range chunks {
+		r, exists := c.data.keyRange()
+		if exists && r.Overlaps(keyRange, cmp) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the layer to place a table in. The table must be newer than every
+// key it overlaps, so it's placed first in the deepest layer that has no
+// overlapping chunks in the layers above it.
+func (tree *LsmTree) ingestionLayer(keyRange sstable.KeyRange) int {
+	for i := range tree.layers {
+		l := &tree.layers[i]
+		l.lock.RLock()
+		overlaps := chunksOverlap(l.chunks, keyRange, tree.cmp)
+		l.lock.RUnlock()
+		if overlaps {
+			return i
+		}
+	}
+	return len(tree.layers) - 1
+}
+
+// Moves the files of the tables into the tree. If a move fails, the tables
+// already moved are moved back.
+func (tree *LsmTree) moveExternalFiles(files []*externalFile) error {
+	for i, f := range files {
+		if err := sstable.MoveSSTable(f.root, f.name, tree.rootDir, f.chunkName); err != nil {
+			// The failed move may have moved some of the files of the table
+			return errors.Join(err, tree.restoreExternalFiles(files[:i+1]))
+		}
+	}
+	return nil
+}
+
+// Moves the files of ingested tables back to where they came from.
+func (tree *LsmTree) restoreExternalFiles(files []*externalFile) error {
+	var err error
+	for _, f := range files {
+		err = errors.Join(err, sstable.MoveSSTable(tree.rootDir, f.chunkName, f.root, f.name))
+	}
+	return err
+}
+
+// Opens the moved tables as chunks. If one fails to open, the ones already
+// opened are closed.
+func (tree *LsmTree) openIngestedChunks(files []*externalFile) ([]*chunk, error) {
+	chunks := make([]*chunk, 0, len(files))
+	for _, f := range files {
+		data, err := tree.createChunkData(chunkTypeSSTable, f.chunkName)
+		if err != nil {
+			closeIngestedChunks(chunks)
+			return nil, err
+		}
+		chunks = append(chunks, newChunk(f.chunkName, data, chunkTypeSSTable))
+	}
+	return chunks, nil
+}
+
+// Closes the tables of chunks that weren't added to the tree, without
+// deleting their files.
+func closeIngestedChunks(chunks []*chunk) {
+	for _, c := range chunks {
+		c.data.(*sstableChunk).tbl.Close()
+	}
+}
+
+// Adds SSTables written outside of the tree, by the public sst writer, to
+// the tree without going through the WAL. Each path is the directory and
+// name of a table, without file extension. The tables must not overlap each
+// other. Their files are moved into the tree.
+//
+// Ingestion is all or nothing. All tables are validated before any of them
+// are moved, and if moving or opening one fails, or the state of the tree
+// can't be saved, the moved files are moved back and the tree is left as it
+// was.
+//
+// Every entry of a table gets the next sequence number of the tree, so keys
+// overwritten by an ingested table get a new version. Ingested tables are
+// placed so that they're newer than any data in the tree they overlap, since
+// reads and merges use the order of chunks to find the newest value of a
+// key. If they overlap the root chunk, it's pushed to layer 0 first.
+func (tree *LsmTree) IngestExternalFiles(paths []string) error {
+	files := []*externalFile{}
+	for _, p := range paths {
+		f, err := tree.loadExternalFile(p)
+		if err != nil {
+			return err
+		}
+		// Empty tables have nothing to ingest
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return tree.cmp.Compare(files[i].keyRange.Smallest, files[j].keyRange.Smallest) < 0
+	})
+	for i := 1; i < len(files); i++ {
+		if files[i-1].keyRange.Overlaps(files[i].keyRange, tree.cmp) {
+			return errors.New("external files must not overlap each other")
+		}
+	}
+
+	// No writes or merges may move data between layers while placing the
+	// tables
+	tree.mergeLock.Lock()
+	defer tree.mergeLock.Unlock()
+	tree.writeLock.Lock()
+	defer tree.writeLock.Unlock()
+
+	for _, f := range files {
+		if chunksOverlap([]*chunk{tree.root()}, f.keyRange, tree.cmp) {
+			if err := tree.pushRoot(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	// The tables don't overlap each other, so placing one doesn't change
+	// where the others go
+	names := map[string]bool{}
+	for _, f := range files {
+		f.layer = tree.ingestionLayer(f.keyRange)
+		for f.chunkName == "" || names[f.chunkName] {
+			f.chunkName = tree.generateChunkName(f.layer)
+		}
+		names[f.chunkName] = true
+	}
+
+	if err := tree.moveExternalFiles(files); err != nil {
+		return err
+	}
+	chunks, err := tree.openIngestedChunks(files)
+	if err != nil {
+		return errors.Join(err, tree.restoreExternalFiles(files))
+	}
+
+	for i, f := range files {
+		// Writes are paused, no write can get a sequence number in between
+		chunks[i].data.(*sstableChunk).ingestedSeq = tree.seq.Add(1)
+		l := &tree.layers[f.layer]
+		l.lock.Lock()
+		l.chunks = append([]*chunk{chunks[i]}, l.chunks...)
+		l.lock.Unlock()
+	}
+
+	if err := tree.save(); err != nil {
+		tree.removeIngestedChunks(files, chunks)
+		closeIngestedChunks(chunks)
+		return errors.Join(err, tree.restoreExternalFiles(files))
+	}
+
+	for _, f := range files {
+		log.Info().
+			Str("file", filepath.Join(f.root, f.name)).
+			Int("layer", f.layer).
+			Msg("Ingested external file")
+	}
+	return nil
+}
+
+// Takes chunks added by a failed ingestion out of their layers again.
+func (tree *LsmTree) removeIngestedChunks(files []*externalFile, chunks []*chunk) {
+	for i, f := range files {
+		l := &tree.layers[f.layer]
+		l.lock.Lock()
+		for j, c := range l.chunks {
+			if c == chunks[i] {
+				l.chunks = append(l.chunks[:j:j], l.chunks[j+1:]...)
+				break
+			}
+		}
+		l.lock.Unlock()
+	}
+}
@@ -0,0 +1,276 @@
+package lsmtree
+
+import (
+	"strings"
+
+	"github.com/lindend/distdb/internal/sstable"
+)
+
+// Merges several chunk iterators into a single stream ordered by key. When
+// several iterators have the same key, the entry of the iterator with the
+// lowest index is returned first. The stream ends at the first error reading
+// a chunk.
+type mergingIterator struct {
+	its     []chunkIterator
+	entries []*entry
+	cmp     sstable.Comparator
+	// Error that ended the stream early
+	err error
+}
+
+func newMergingIterator(its []chunkIterator, cmp sstable.Comparator) *mergingIterator {
+	entries := make([]*entry, len(its))
+	// Populate all entries with the current values
+	for i := 0; i < len(its); i++ {
+		entries[i] = getEntry(its[i])
+	}
+	return &mergingIterator{
+		its:     its,
+		entries: entries,
+		cmp:     cmp,
+	}
+}
+
+// Returns the next entry and the index of the iterator it came from.
+func (m *mergingIterator) next() (*entry, int, bool) {
+	if m.err != nil {
+		return nil, 0, false
+	}
+	// Find the key with the lowest index
+	min, exists := getMin(m.entries, m.cmp)
+	if !exists {
+		return nil, 0, false
+	}
+	e := m.entries[min]
+	e.source = min
+
+	// Progress the chosen iterator and load the next value
+	m.its[min], m.err = m.its[min].next()
+	m.entries[min] = getEntry(m.its[min])
+	return e, min, true
+}
+
+// Returns the entry next would return, without advancing.
+func (m *mergingIterator) peek() (*entry, bool) {
+	if m.err != nil {
+		return nil, false
+	}
+	min, exists := getMin(m.entries, m.cmp)
+	if !exists {
+		return nil, false
+	}
+	e := m.entries[min]
+	e.source = min
+	return e, true
+}
+
+// Iterates over the live keys of a tree in the order of its comparator.
+type Iterator struct {
+	tree   *LsmTree
+	chunks []*chunk
+	merged *mergingIterator
+	// Range tombstones of each chunk
+	tombstones [][]sstable.RangeTombstone
+	// Exclusive upper bound, empty for no bound
+	end string
+	// Only keys starting with prefix are returned
+	prefix string
+	// Set if the keys with the prefix follow each other, so the iterator
+	// ends at the first key after them
+	prefixContiguous bool
+	inPrefix         bool
+	prevKey          string
+	started          bool
+	key              string
+	value            []byte
+	err              error
+	release          func()
+	// Time the iterator was created, keys expiring after it are returned
+	now int64
+}
+
+// Returns the chunks of the tree, newest first, with a reference held to
+// each of them.
+func (tree *LsmTree) snapshotChunks() []*chunk {
+	// The root can't be pushed, merged and deleted before it's referenced
+	tree.rootLock.RLock()
+	root := tree.rootChunk
+	root.ref()
+	tree.rootLock.RUnlock()
+	chunks := []*chunk{root}
+
+	for i := range tree.layers {
+		l := &tree.layers[i]
+		l.lock.RLock()
+		for _, c := range l.chunks {
+			c.ref()
+			chunks = append(chunks, c)
+		}
+		l.lock.RUnlock()
+	}
+	return chunks
+}
+
+// Creates an iterator over the keys in [start, end). An empty start means
+// the iterator starts at the first key of the tree, and an empty end that it
+// continues to the last one. The iterator sees the
+// chunks of the tree at the time it was created, and keeps them from being
+// deleted until it's closed.
+func (tree *LsmTree) NewIterator(start string, end string) *Iterator {
+	return tree.newIterator(start, end, func(c *chunk) bool { return true })
+}
+
+// Creates an iterator over the keys starting with prefix. Chunks whose
+// filters show they have no keys with the prefix are not read. Unless the
+// comparator of the tree is a PrefixSeeker, every key of the tree is scanned
+// to find the ones with the prefix.
+func (tree *LsmTree) NewPrefixIterator(prefix string) *Iterator {
+	start := ""
+	seeker, contiguous := tree.cmp.(sstable.PrefixSeeker)
+	if contiguous {
+		if key, ok := seeker.PrefixSeekKey(prefix); ok {
+			start = key
+		}
+	}
+
+	it := tree.newIterator(start, "", func(c *chunk) bool {
+		return c.data.mayContainPrefix(prefix)
+	})
+	it.prefix = prefix
+	it.prefixContiguous = contiguous
+	return it
+}
+
+func (tree *LsmTree) newIterator(start string, end string, include func(c *chunk) bool) *Iterator {
+	release := tree.acquireValueLog()
+	chunks := tree.snapshotChunks()
+
+	its := make([]chunkIterator, len(chunks))
+	tombstones := make([][]sstable.RangeTombstone, len(chunks))
+	var err error
+	for i, c := range chunks {
+		// Tombstones of skipped chunks still hide keys in older chunks
+		tombstones[i] = c.data.rangeTombstones()
+		if include(c) && start == "" && err == nil {
+			its[i], err = c.data.iterator()
+		} else if include(c) && err == nil {
+			its[i], err = c.data.seek(start)
+		}
+	}
+
+	return &Iterator{
+		tree:       tree,
+		chunks:     chunks,
+		merged:     newMergingIterator(its, tree.cmp),
+		tombstones: tombstones,
+		end:        end,
+		err:        err,
+		release:    release,
+		now:        tree.now().UnixNano(),
+	}
+}
+
+// Advances to the next key. Returns false when there are no more keys in the
+// range, or if an error occured.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for {
+		e, _, exists := it.merged.next()
+		if !exists {
+			it.err = it.merged.err
+			return false
+		}
+		if it.end != "" && it.tree.cmp.Compare(e.key, it.end) >= 0 {
+			return false
+		}
+		if !strings.HasPrefix(e.key, it.prefix) {
+			if it.inPrefix && it.prefixContiguous {
+				return false
+			}
+			continue
+		}
+		it.inPrefix = true
+
+		// Only the newest entry of every key is of interest
+		if it.started && e.key == it.prevKey {
+			continue
+		}
+		it.prevKey = e.key
+		it.started = true
+
+		if isDropped(*e, it.tombstones, true, it.tree.cmp) || e.expired(it.now) {
+			continue
+		}
+
+		var value []byte
+		var err error
+		if e.kind == RecordKindMerge {
+			value, err = it.resolveOperands(e)
+			// Operands read after an error would be missing
+			if err == nil {
+				err = it.merged.err
+			}
+		} else {
+			value, err = it.tree.resolveValue(e.kind, e.data)
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.key = e.key
+		it.value = value
+		return true
+	}
+}
+
+// Combines the merge operand of an entry with the older entries of its key.
+// Entries of the same key follow each other, newest first.
+func (it *Iterator) resolveOperands(e *entry) ([]byte, error) {
+	operands := [][]byte{e.data}
+	for {
+		next, exists := it.merged.peek()
+		if !exists || next.key != e.key {
+			value, _, err := it.tree.resolveMerge(e.key, operands, nil, it.now)
+			return value, err
+		}
+		it.merged.next()
+
+		if hiddenByTombstone(*next, it.tombstones, it.tree.cmp) {
+			value, _, err := it.tree.resolveMerge(e.key, operands, nil, it.now)
+			return value, err
+		}
+		if next.kind != RecordKindMerge {
+			value, _, err := it.tree.resolveMerge(e.key, operands, next, it.now)
+			return value, err
+		}
+		operands = append(operands, next.data)
+	}
+}
+
+func (it *Iterator) Key() string {
+	return it.key
+}
+
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Releases the chunks held by the iterator.
+func (it *Iterator) Close() {
+	if it.release == nil {
+		return
+	}
+	for _, c := range it.chunks {
+		c.unref()
+	}
+	it.chunks = nil
+	it.release()
+	it.release = nil
+}
@@ -2,14 +2,18 @@ package lsmtree
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lindend/distdb/internal/sstable"
+	"github.com/lindend/distdb/internal/vlog"
+	"github.com/lindend/distdb/internal/wal"
 
 	"github.com/rs/zerolog/log"
 )
@@ -22,6 +26,14 @@ const Terabyte = 1024 * Gigabyte
 const (
 	RecordKindWrite  uint64 = 0x1000
 	RecordKindDelete uint64 = 0x1001
+	// The data of the record is a pointer to a value in the value log
+	RecordKindValuePointer uint64 = 0x1002
+	// Deletes all keys from the key of the record up to, but not including,
+	// the key stored in the data of the record.
+	RecordKindRangeDelete uint64 = 0x1003
+	// The data of the record is an operand for the merge operator of the
+	// tree, combined with the older value of the key when read.
+	RecordKindMerge uint64 = 0x1004
 )
 
 type layer struct {
@@ -40,39 +52,96 @@ type lsmLayerJson struct {
 type lsmChunkJson struct {
 	Name      string
 	ChunkType chunkType
+	// LSN of the first WAL record of a memtable chunk. 0 for chunks written
+	// before the WAL was segmented, which have a WAL file of their own.
+	FirstLSN uint64 `json:",omitempty"`
+	// Sequence number of the entries of an ingested SSTable chunk
+	IngestedSeq uint64 `json:",omitempty"`
 }
 
 type lsmTreeJson struct {
 	Layers           []lsmLayerJson
 	Root             lsmChunkJson
 	MaxRootChunkSize uint64
+	// Name of the comparator ordering the keys, empty for trees created
+	// before it was recorded, which are in bytewise order
+	Comparator string `json:",omitempty"`
 }
 
 type LsmTree struct {
-	layers           []layer
-	exit             chan int
+	layers []layer
+	exit   chan int
+	// Closed when the merge process has exited, nil if there is none
+	mergeDone        chan int
 	rootChunk        *chunk
 	maxRootChunkSize uint64
 	rootDir          string
+	options          Options
+	// Order of the keys
+	cmp sstable.Comparator
+	// Shared by all SSTables of the tree, nil if disabled
+	blockCache *sstable.BlockCache
+	// Log storing large values outside of the SSTables, nil if disabled
+	vlog *vlog.ValueLog
+	// Write-ahead log of the memtable chunks
+	log *wal.Log
+	// Only one value log collection may run at a time
+	valueLogGC sync.Mutex
+	// Held while moving chunks between layers
+	mergeLock sync.Mutex
+	// Held for reading by writers, and for writing when writes must be
+	// paused.
+	writeLock sync.RWMutex
+	// Held for reading while using the root chunk, and for writing while
+	// replacing it. Writes to the root finish before it's pushed to layer 0.
+	rootLock sync.RWMutex
+	// Only one save may write the structure of the tree at a time
+	saveLock sync.Mutex
+	// Current time, used to expire keys
+	now func() time.Time
+	// Sequence number of the last write, the version of the key written
+	seq atomic.Uint64
+	// Held while writing a key, so conditional writes can check the current
+	// value of the key without other writes of it getting in between
+	keyLocks [keyLockStripes]sync.Mutex
 }
 
-func createChunkData(chunkType chunkType, rootDir string, name string) (chunkData, error) {
+func newTree(rootDir string, options Options) *LsmTree {
+	tree := &LsmTree{
+		rootDir: rootDir,
+		options: options,
+		exit:    make(chan int),
+		now:     time.Now,
+		cmp:     options.Comparator,
+	}
+	if tree.cmp == nil {
+		tree.cmp = sstable.BytewiseComparator
+	}
+	if options.BlockCacheSize > 0 && options.BlockCacheTinyLFU {
+		tree.blockCache = sstable.NewTinyLFUBlockCache(options.BlockCacheSize, options.BlockCacheShards)
+	} else if options.BlockCacheSize > 0 {
+		tree.blockCache = sstable.NewBlockCache(options.BlockCacheSize, options.BlockCacheShards)
+	}
+	return tree
+}
+
+func (tree *LsmTree) createChunkData(chunkType chunkType, name string) (chunkData, error) {
 	switch chunkType {
-	case chunkTypeSkiplist:
-		return newSkipListChunk(path.Join(rootDir, fmt.Sprintf("wal-%v.log", name)))
+	case chunkTypeMemtable:
+		return tree.newMemtableChunk(tree.log.NextLSN()), nil
 	case chunkTypeSSTable:
-		tbl, err := sstable.LoadSSTable(rootDir, name)
+		tbl, err := sstable.LoadSSTable(tree.rootDir, name, tree.tableOptions())
 		if err != nil {
 			return nil, err
 		}
-		return &sstableChunk{tbl}, nil
+		return &sstableChunk{tbl: tbl}, nil
 	}
 	panic("Unknown chunkType")
 }
 
-func NewLsmTree(rootDir string) (*LsmTree, error) {
+func NewLsmTree(rootDir string, options Options) (*LsmTree, error) {
 	// Try to load an existing tree
-	existingTree, err := load(rootDir)
+	existingTree, err := load(rootDir, options)
 	if existingTree != nil {
 		return existingTree, nil
 	}
@@ -83,52 +152,51 @@ func NewLsmTree(rootDir string) (*LsmTree, error) {
 	}
 
 	// Create a new tree instead
-	rootChunkName := randomString(6)
-	chunkData, err := createChunkData(chunkTypeSkiplist, rootDir, rootChunkName)
-	if err != nil {
+	tree := newTree(rootDir, options)
+	if err := tree.openValueLog(); err != nil {
+		return nil, err
+	}
+	if err := tree.openWAL(); err != nil {
 		return nil, err
 	}
 
-	rootChunk := &chunk{
-		name:      rootChunkName,
-		data:      chunkData,
-		chunkType: chunkTypeSkiplist,
+	rootChunkName := randomString(6)
+	chunkData, err := tree.createChunkData(chunkTypeMemtable, rootChunkName)
+	if err != nil {
+		return nil, err
 	}
 
-	tree := &LsmTree{
-		rootDir:          rootDir,
-		rootChunk:        rootChunk,
-		maxRootChunkSize: 16 * Megabyte,
-		layers: []layer{
-			{
-				name:      "layer-0",
-				maxChunks: 4,
-				chunks:    []*chunk{},
-				lock:      &sync.RWMutex{},
-			},
-			{
-				name:      "layer-1",
-				maxChunks: 8,
-				chunks:    []*chunk{},
-				lock:      &sync.RWMutex{},
-			},
-			{
-				name:      "layer-2",
-				maxChunks: 4,
-				chunks:    []*chunk{},
-				lock:      &sync.RWMutex{},
-			},
-			{
-				name:      "layer-3",
-				maxChunks: 0,
-				chunks:    []*chunk{},
-				lock:      &sync.RWMutex{},
-			},
+	tree.rootChunk = newChunk(rootChunkName, chunkData, chunkTypeMemtable)
+	tree.maxRootChunkSize = 16 * Megabyte
+	tree.layers = []layer{
+		{
+			name:      "layer-0",
+			maxChunks: 4,
+			chunks:    []*chunk{},
+			lock:      &sync.RWMutex{},
+		},
+		{
+			name:      "layer-1",
+			maxChunks: 8,
+			chunks:    []*chunk{},
+			lock:      &sync.RWMutex{},
+		},
+		{
+			name:      "layer-2",
+			maxChunks: 4,
+			chunks:    []*chunk{},
+			lock:      &sync.RWMutex{},
+		},
+		{
+			name:      "layer-3",
+			maxChunks: 0,
+			chunks:    []*chunk{},
+			lock:      &sync.RWMutex{},
 		},
-		exit: make(chan int),
 	}
 
-	go tree.mergeProcess()
+	tree.registerWriteBuffer()
+	tree.startMergeProcess()
 
 	return tree, nil
 }
@@ -137,6 +205,11 @@ type entry struct {
 	key  string
 	kind uint64
 	data []byte
+	// Unix time in nanoseconds when the entry expires, 0 if it never does
+	expiresAt int64
+	seq       uint64
+	// Index of the iterator the entry was read from when merging
+	source int
 }
 
 func getEntry(it chunkIterator) *entry {
@@ -145,21 +218,24 @@ func getEntry(it chunkIterator) *entry {
 	}
 	kind, key, data := it.value()
 	return &entry{
-		key:  key,
-		kind: kind,
-		data: data,
+		key:       key,
+		kind:      kind,
+		data:      data,
+		expiresAt: it.expiresAt(),
+		seq:       it.seq(),
 	}
 }
 
-// Finds the minimum entry by key
-func getMin(e []*entry) (int, bool) {
+// Finds the minimum entry by key. If several entries have the same
+// key, the one with the lowest index is returned.
+func getMin(e []*entry, cmp sstable.Comparator) (int, bool) {
 	exists := false
 	min := -1
 	minKey := ""
 
 	for i := 0; i < len(e); i++ {
 		if e[i] != nil {
-			if !exists || e[i].key < minKey {
+			if !exists || cmp.Compare(e[i].key, minKey) < 0 {
 				minKey = e[i].key
 				min = i
 			}
@@ -171,51 +247,145 @@ func getMin(e []*entry) (int, bool) {
 
 // Spins up a thread that populates the return channel with ordered
 // entries merged from all the iterators. When all entries have been
-// returned, the channel will be closed.
-func getEntries(its []chunkIterator) <-chan entry {
-	entries := make([]*entry, len(its))
-	// Populate all entries with the current values
-	for i := 0; i < len(its); i++ {
-		entries[i] = getEntry(its[i])
-	}
-
+// returned, or reading one of the iterators failed, the channel will be
+// closed. The error of merged can be checked once it's closed.
+func getEntries(merged *mergingIterator) <-chan entry {
 	resultChan := make(chan entry, 4)
 
 	go func() {
 		for {
-			// Find the key with the lowest index
-			min, exists := getMin(entries)
+			e, _, exists := merged.next()
 			// if we couldn't find any keys, we are done
 			if !exists {
 				close(resultChan)
 				break
 			}
-			if entries[min] != nil {
-				resultChan <- *entries[min]
-			}
-			// Progress the chosen iterator and load the next value
-			its[min] = its[min].next()
-			entries[min] = getEntry(its[min])
+			resultChan <- *e
 		}
 	}()
 
 	return resultChan
 }
 
-func parallellMerge(its []chunkIterator, tbl *sstable.SSTableBuilder) error {
+// Writes the newest entry of every key to the table builder, along with the
+// range tombstones of the chunks. Entries covered by a tombstone of a newer
+// chunk are dropped. When merging to the bottommost chunk of the tree there
+// is no older data left for deletes to hide, so they are dropped too. Values
+// larger than the value log threshold are moved to the value log, and only a
+// pointer to them is written to the table. Expired entries are removed, and
+// the compaction filter of the tree is called for every remaining value.
+// Merge operands are combined with the older value of their key. Fails if
+// any of the iterators can't be read to the end.
+func (tree *LsmTree) parallellMerge(its []chunkIterator, tombstones [][]sstable.RangeTombstone, ctx CompactionContext, tbl *sstable.SSTableBuilder) error {
 	prevKey := ""
+	first := true
+	now := tree.now().UnixNano()
+	// Operands of the current key waiting for the record they apply to
+	var pending *pendingOperands
 
-	entries := getEntries(its)
+	// The compaction filter and merge operands may read values from the
+	// value log
+	release := tree.acquireValueLog()
+	defer release()
 
+	merged := newMergingIterator(its, tree.cmp)
+	entries := getEntries(merged)
+
+	var err error
 	for entry := range entries {
-		if entry.key != prevKey {
-			tbl.Write(entry.key, RecordKindWrite, entry.data)
-		}
+		newKey := first || entry.key != prevKey
 		prevKey = entry.key
+		first = false
+		if err != nil {
+			// Drain the entries so the merging goroutine exits
+			continue
+		}
+
+		if newKey && pending != nil {
+			err = tree.writeMergedOperands(ctx, pending, nil, now, tbl)
+			pending = nil
+		} else if pending != nil {
+			if entry.kind == RecordKindMerge && !hiddenByTombstone(entry, tombstones, tree.cmp) {
+				pending.operands = append(pending.operands, entry.data)
+				continue
+			}
+			// Entries hidden by a range tombstone are deleted
+			if hiddenByTombstone(entry, tombstones, tree.cmp) {
+				entry = entry.deleted()
+			}
+			err = tree.writeMergedOperands(ctx, pending, &entry, now, tbl)
+			pending = nil
+			continue
+		}
+
+		if err != nil || !newKey || isDropped(entry, tombstones, ctx.Bottommost, tree.cmp) {
+			continue
+		}
+		if entry.kind == RecordKindMerge {
+			pending = &pendingOperands{key: entry.key, operands: [][]byte{entry.data}, seq: entry.seq, source: entry.source}
+		} else if entry.expired(now) {
+			err = tree.writeRemovedEntry(ctx, entry, tbl)
+		} else {
+			err = tree.mergeEntry(ctx, entry, tbl)
+		}
+	}
+	// Entries after a read error are missing, the merged table would lose
+	// them
+	if err == nil {
+		err = merged.err
+	}
+	if err == nil && pending != nil {
+		err = tree.writeMergedOperands(ctx, pending, nil, now, tbl)
+	}
+	if err != nil || ctx.Bottommost {
+		return err
+	}
+
+	for _, chunkTombstones := range tombstones {
+		for _, t := range chunkTombstones {
+			if err := tbl.AddRangeTombstone(t); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+// Checks if an entry is hidden by a range tombstone of a newer chunk, or if
+// it's a delete that should be dropped.
+func isDropped(e entry, tombstones [][]sstable.RangeTombstone, dropDeletes bool, cmp sstable.Comparator) bool {
+	if dropDeletes && e.kind == RecordKindDelete {
+		return true
+	}
+	return hiddenByTombstone(e, tombstones, cmp)
+}
+
+// Checks if an entry is hidden by a range tombstone of a newer chunk.
+func hiddenByTombstone(e entry, tombstones [][]sstable.RangeTombstone, cmp sstable.Comparator) bool {
+	for i := 0; i < e.source; i++ {
+		if coveredByTombstone(tombstones[i], e.key, cmp) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a delete of the key of the entry.
+func (e entry) deleted() entry {
+	return entry{key: e.key, kind: RecordKindDelete, seq: e.seq, source: e.source}
+}
+
+func (tree *LsmTree) writeMergedEntry(e entry, tbl *sstable.SSTableBuilder) error {
+	if tree.shouldSeparateValue(e) {
+		ptr, err := tree.vlog.Append([]byte(e.key), e.data)
+		if err != nil {
+			return err
+		}
+		return tbl.WriteEntry(e.key, sstable.Entry{Kind: RecordKindValuePointer, Data: ptr.Encode(), ExpiresAt: e.expiresAt, Seq: e.seq})
+	}
+	return tbl.WriteEntry(e.key, sstable.Entry{Kind: e.kind, Data: e.data, ExpiresAt: e.expiresAt, Seq: e.seq})
+}
+
 var randomFileChars = []rune("abcdefghijklmnopqrstuvwxyz1234567890")
 
 func randomString(length int) string {
@@ -249,10 +419,15 @@ func (tree *LsmTree) generateChunkName(layer int) string {
 // a SSTable. Should support concurrent reads and writes will performing
 // the merge. Not concurrent merges though.
 func (tree *LsmTree) mergeLayer(layerIdx int) error {
+	tree.mergeLock.Lock()
+	defer tree.mergeLock.Unlock()
+
 	start := time.Now()
 
-	l := tree.layers[layerIdx]
+	l := &tree.layers[layerIdx]
+	l.lock.RLock()
 	chunks := l.chunks
+	l.lock.RUnlock()
 
 	numEntries := int64(0)
 	for i := 0; i < len(chunks); i++ {
@@ -264,26 +439,60 @@ func (tree *LsmTree) mergeLayer(layerIdx int) error {
 		nextLayerIdx = layerIdx + 1
 	}
 
+	// If there are no chunks below the merged ones, deletes don't have to be
+	// kept
+	bottommost := true
+	for i := layerIdx + 1; i < len(tree.layers); i++ {
+		tree.layers[i].lock.RLock()
+		if len(tree.layers[i].chunks) > 0 {
+			bottommost = false
+		}
+		tree.layers[i].lock.RUnlock()
+	}
+
 	log.Debug().
 		Int("layer", layerIdx).
 		Int("target", nextLayerIdx).
+		Bool("bottommost", bottommost).
 		Msg("Merging layers")
 
+	// Prepare chunk iterators
+	chunkIts := make([]chunkIterator, len(chunks))
+	tombstones := make([][]sstable.RangeTombstone, len(chunks))
+	for i := 0; i < len(chunks); i++ {
+		var err error
+		chunkIts[i], err = chunks[i].data.iterator()
+		if err != nil {
+			return err
+		}
+		tombstones[i] = chunks[i].data.rangeTombstones()
+	}
+
 	chunkName := tree.generateChunkName(nextLayerIdx)
 	// Create a new SSTable chunk with a random name to merge to
-	tblBuilder, err := sstable.NewSSTable(uint(numEntries), tree.rootDir, chunkName)
+	tblBuilder, err := sstable.NewSSTable(uint(numEntries), tree.rootDir, chunkName, tree.tableBuildOptions(nextLayerIdx))
 	if err != nil {
 		return err
 	}
 
-	// Prepare chunk iterators
-	chunkIts := make([]chunkIterator, len(chunks))
-	for i := 0; i < len(chunks); i++ {
-		chunkIts[i] = chunks[i].data.iterator()
+	// Merge chunks into next layer
+	ctx := CompactionContext{
+		Layer:       layerIdx,
+		TargetLayer: nextLayerIdx,
+		Bottommost:  bottommost,
+	}
+	err = tree.parallellMerge(chunkIts, tombstones, ctx, tblBuilder)
+	if err != nil {
+		return err
 	}
 
-	// Merge chunks into next layer
-	parallellMerge(chunkIts, tblBuilder)
+	// Values moved to the value log must be durable before the table that
+	// points to them is
+	if tree.vlog != nil {
+		if err := tree.vlog.Sync(); err != nil {
+			return err
+		}
+	}
 
 	sstable, err := tblBuilder.Build()
 	if err != nil {
@@ -296,39 +505,48 @@ func (tree *LsmTree) mergeLayer(layerIdx int) error {
 
 	// Lock layer we are merging to update the chunk info
 	l.lock.Lock()
-	defer l.lock.Unlock()
 
-	// Clear chunks of layer we merged from
-	l.chunks = nil
+	// Clear chunks of layer we merged from. New chunks may have been pushed
+	// to the front of the layer while merging, keep those.
+	l.chunks = l.chunks[:len(l.chunks)-len(chunks)]
 
 	nextLayer := &tree.layers[nextLayerIdx]
 	if layerIdx != nextLayerIdx {
 		// If we are merging to a new layer, get a lock on that layer too
 		nextLayer.lock.Lock()
-		defer nextLayer.lock.Unlock()
 	}
 
-	newChunk := &chunk{
-		name:      chunkName,
-		data:      &sstableLayer,
-		chunkType: chunkTypeSSTable,
-	}
+	mergedChunk := newChunk(chunkName, &sstableLayer, chunkTypeSSTable)
 
-	nextLayer.chunks = append([]*chunk{newChunk}, nextLayer.chunks...)
+	nextLayer.chunks = append([]*chunk{mergedChunk}, nextLayer.chunks...)
 
-	tree.save()
+	if layerIdx != nextLayerIdx {
+		nextLayer.lock.Unlock()
+	}
+	l.lock.Unlock()
 
-	// Everything is merged and saved, delete old chunks
+	// The old chunks are kept on disk if the tree can't be saved, the saved
+	// tree still refers to them
+	if err := tree.save(); err != nil {
+		return err
+	}
+
+	// Everything is merged and saved, release old chunks. They are deleted
+	// once no iterators are using them.
 	for i := range chunks {
-		chunks[i].data.delete()
+		chunks[i].unref()
 	}
 
 	log.Info().Dur("duration", time.Since(start)).Msg("Merge complete")
 	return nil
 }
 
-// Save the structure of the LSM tree to a file
+// Save the structure of the LSM tree to a file. The root and layer locks
+// must not be held.
 func (tree *LsmTree) save() error {
+	tree.saveLock.Lock()
+	defer tree.saveLock.Unlock()
+
 	fileName := path.Join(tree.rootDir, "lsm.json")
 	file, err := os.Create(fileName)
 	if err != nil {
@@ -338,15 +556,17 @@ func (tree *LsmTree) save() error {
 
 	encoder := json.NewEncoder(file)
 
+	// The root is locked along with the layers, so a root being pushed to
+	// layer 0 is saved in exactly one of them
+	tree.rootLock.RLock()
 	layers := make([]lsmLayerJson, len(tree.layers))
 	for i := range layers {
+		tree.layers[i].lock.RLock()
 		chunks := make([]lsmChunkJson, len(tree.layers[i].chunks))
 		for j := range tree.layers[i].chunks {
-			chunks[j] = lsmChunkJson{
-				Name:      tree.layers[i].chunks[j].name,
-				ChunkType: tree.layers[i].chunks[j].chunkType,
-			}
+			chunks[j] = tree.layers[i].chunks[j].toJson()
 		}
+		tree.layers[i].lock.RUnlock()
 
 		layers[i] = lsmLayerJson{
 			Name:      tree.layers[i].name,
@@ -357,11 +577,10 @@ func (tree *LsmTree) save() error {
 	data := lsmTreeJson{
 		Layers:           layers,
 		MaxRootChunkSize: tree.maxRootChunkSize,
-		Root: lsmChunkJson{
-			Name:      tree.rootChunk.name,
-			ChunkType: tree.rootChunk.chunkType,
-		},
+		Comparator:       tree.cmp.Name(),
+		Root:             tree.rootChunk.toJson(),
 	}
+	tree.rootLock.RUnlock()
 
 	err = encoder.Encode(data)
 	if err != nil {
@@ -371,7 +590,7 @@ func (tree *LsmTree) save() error {
 	return nil
 }
 
-func load(rootDir string) (*LsmTree, error) {
+func load(rootDir string, options Options) (*LsmTree, error) {
 	fileName := path.Join(rootDir, "lsm.json")
 	f, err := os.Open(fileName)
 	if err != nil {
@@ -387,95 +606,321 @@ func load(rootDir string) (*LsmTree, error) {
 		return nil, err
 	}
 
-	skiplist, err := createChunkData(chunkTypeSkiplist, rootDir, m.Root.Name)
-	if err != nil {
-		return nil, err
+	tree := newTree(rootDir, options)
+	createdWith := m.Comparator
+	if createdWith == "" {
+		createdWith = sstable.BytewiseComparator.Name()
+	}
+	if createdWith != tree.cmp.Name() {
+		return nil, fmt.Errorf("tree was created with comparator %v, not %v", createdWith, tree.cmp.Name())
 	}
 
-	rootChunk := &chunk{
-		name:      m.Root.Name,
-		data:      skiplist,
-		chunkType: m.Root.ChunkType,
+	tree.maxRootChunkSize = m.MaxRootChunkSize
+	if err := tree.openValueLog(); err != nil {
+		return nil, err
+	}
+	if err := tree.openWAL(); err != nil {
+		return nil, err
 	}
 
-	layers := make([]layer, len(m.Layers))
-	for i := range layers {
-		l := m.Layers[i]
-		chunks := make([]*chunk, len(l.Chunks))
-		for j := range chunks {
-			c := l.Chunks[j]
-			chunkData, err := createChunkData(c.ChunkType, rootDir, c.Name)
+	// Chunks of the tree, newest first, starting with the root
+	chunkJsons := []lsmChunkJson{m.Root}
+	for _, l := range m.Layers {
+		chunkJsons = append(chunkJsons, l.Chunks...)
+	}
+	chunks := make([]*chunk, len(chunkJsons))
+	memtables := []*memtableChunk{}
+	for i, c := range chunkJsons {
+		if c.ChunkType == chunkTypeMemtable && c.FirstLSN == 0 {
+			continue
+		}
+		var data chunkData
+		if c.ChunkType == chunkTypeMemtable {
+			sl := tree.newMemtableChunk(c.FirstLSN)
+			memtables = append(memtables, sl)
+			data = sl
+		} else {
+			data, err = tree.createChunkData(c.ChunkType, c.Name)
 			if err != nil {
 				return nil, err
 			}
-			chunks[j] = &chunk{
-				name:      c.Name,
-				data:      chunkData,
-				chunkType: c.ChunkType,
+			if tbl, ok := data.(*sstableChunk); ok {
+				tbl.ingestedSeq = c.IngestedSeq
 			}
 		}
+		chunks[i] = newChunk(c.Name, data, c.ChunkType)
+	}
+	if err := tree.replayWAL(memtables); err != nil {
+		return nil, err
+	}
+
+	// Chunks with WAL files of their own are moved into the log oldest
+	// first, so their records are in the order they were written
+	legacyWALs := []string{}
+	for i := len(chunkJsons) - 1; i >= 0; i-- {
+		c := chunkJsons[i]
+		if chunks[i] != nil {
+			continue
+		}
+		sl, err := tree.migrateLegacyWAL(c.Name)
+		if err != nil {
+			return nil, err
+		}
+		chunks[i] = newChunk(c.Name, sl, c.ChunkType)
+		legacyWALs = append(legacyWALs, tree.legacyWALFileName(c.Name))
+	}
 
+	tree.rootChunk = chunks[0]
+	chunks = chunks[1:]
+	layers := make([]layer, len(m.Layers))
+	for i := range layers {
 		layers[i] = layer{
 			name:      m.Layers[i].Name,
 			maxChunks: m.Layers[i].MaxChunks,
-			chunks:    chunks,
+			chunks:    chunks[:len(m.Layers[i].Chunks)],
 			lock:      &sync.RWMutex{},
 		}
+		chunks = chunks[len(m.Layers[i].Chunks):]
 	}
 
-	tree := &LsmTree{
-		layers:           layers,
-		rootChunk:        rootChunk,
-		exit:             make(chan int),
-		rootDir:          rootDir,
-		maxRootChunkSize: m.MaxRootChunkSize,
+	tree.layers = layers
+	tree.initSeq()
+
+	if len(legacyWALs) > 0 {
+		if err := tree.save(); err != nil {
+			return nil, err
+		}
+		for _, fileName := range legacyWALs {
+			os.Remove(fileName)
+		}
 	}
 
-	go tree.mergeProcess()
+	tree.registerWriteBuffer()
+	tree.startMergeProcess()
 
 	return tree, nil
 
 }
 
+func (tree *LsmTree) startMergeProcess() {
+	if tree.options.MergeInterval > 0 {
+		tree.mergeDone = make(chan int)
+		go tree.mergeProcess()
+	}
+}
+
+func (tree *LsmTree) registerWriteBuffer() {
+	if tree.options.WriteBufferManager != nil {
+		tree.options.WriteBufferManager.register(tree)
+	}
+}
+
+// Stops the background merge process and closes the value log. The tree
+// can't be used after it's closed.
+func (tree *LsmTree) Close() error {
+	close(tree.exit)
+	if tree.mergeDone != nil {
+		<-tree.mergeDone
+	}
+	if tree.options.WriteBufferManager != nil {
+		tree.options.WriteBufferManager.unregister(tree)
+		tree.visitMemtables(func(c *memtableChunk) { c.releaseMemory() })
+	}
+
+	err := tree.log.Close()
+	if tree.vlog != nil {
+		return errors.Join(err, tree.vlog.Close())
+	}
+	return err
+}
+
 // Merge process running in the background, compacting layers
 // that are full.
 func (tree *LsmTree) mergeProcess() {
+	defer close(tree.mergeDone)
 	for {
-		time.Sleep(time.Second * 2)
+		select {
+		case <-tree.exit:
+			return
+		case <-time.After(tree.options.MergeInterval):
+		}
+
 		for i := 0; i < len(tree.layers); i++ {
-			l := tree.layers[i]
-			if len(l.chunks) > l.maxChunks {
-				tree.mergeLayer(i)
+			if !tree.shouldMerge(i) {
+				continue
+			}
+			if err := tree.mergeLayer(i); err != nil {
+				log.Error().Err(err).Int("layer", i).Msg("Failed to merge layer")
 			}
 		}
 	}
 }
 
-func (tree *LsmTree) Set(key string, data []byte) error {
-	tree.rootChunk.data.set(key, RecordKindWrite, data)
+// Checks if a layer is full, or has enough deletes to be worth merging
+// early.
+func (tree *LsmTree) shouldMerge(layerIdx int) bool {
+	l := &tree.layers[layerIdx]
+	l.lock.RLock()
+	defer l.lock.RUnlock()
 
-	if tree.rootChunk.data.size() > tree.maxRootChunkSize {
-		log.Debug().
-			Msg("Root chunk full, pushing to layer0")
+	if len(l.chunks) > l.maxChunks {
+		return true
+	}
+	if tree.options.MergeDeletionRatio <= 0 || len(l.chunks) == 0 {
+		return false
+	}
 
-		root := tree.rootChunk
-		tree.layers[0].chunks = append([]*chunk{root}, tree.layers[0].chunks...)
-		chunkName := randomString(6)
-		skiplistChunk, err := createChunkData(chunkTypeSkiplist, tree.rootDir, chunkName)
-		if err != nil {
-			return err
+	entries := int64(0)
+	deletes := int64(0)
+	for _, c := range l.chunks {
+		props, exists := c.data.properties()
+		if !exists {
+			continue
 		}
-		tree.rootChunk = &chunk{
-			name:      chunkName,
-			data:      skiplistChunk,
-			chunkType: chunkTypeSkiplist,
+		for kind, count := range props.EntriesByKind {
+			entries += count
+			if kind == RecordKindDelete {
+				deletes += count
+			}
 		}
+		entries += props.NumRangeDeletions
+		deletes += props.NumRangeDeletions
+	}
+	return entries > 0 && float64(deletes)/float64(entries) >= tree.options.MergeDeletionRatio
+}
+
+func (tree *LsmTree) Set(key string, data []byte) error {
+	tree.writeLock.RLock()
+	defer tree.writeLock.RUnlock()
+	defer tree.lockKey(key)()
+
+	return tree.write(key, RecordKindWrite, data, 0)
+}
+
+func (tree *LsmTree) Delete(key string) error {
+	tree.writeLock.RLock()
+	defer tree.writeLock.RUnlock()
+	defer tree.lockKey(key)()
+
+	return tree.write(key, RecordKindDelete, nil, 0)
+}
+
+// Deletes every key in [start, end).
+func (tree *LsmTree) DeleteRange(start string, end string) error {
+	if tree.cmp.Compare(start, end) >= 0 {
+		return errors.New("start of range to delete must be before end")
+	}
+
+	tree.writeLock.RLock()
+	defer tree.writeLock.RUnlock()
+	defer tree.lockAllKeys()()
+
+	return tree.write(start, RecordKindRangeDelete, []byte(end), 0)
+}
+
+// Writes a record with the next sequence number of the tree. The key must be
+// locked.
+func (tree *LsmTree) write(key string, kind uint64, data []byte, expiresAt int64) error {
+	return tree.writeEntry(entry{key: key, kind: kind, data: data, expiresAt: expiresAt, seq: tree.seq.Add(1)})
+}
+
+// Writes a record to the root chunk, pushing the root to layer 0 if it's
+// full.
+func (tree *LsmTree) writeEntry(e entry) error {
+	tree.rootLock.RLock()
+	root := tree.rootChunk
+	err := root.data.set(e)
+	full := root.data.size() > tree.maxRootChunkSize
+	tree.rootLock.RUnlock()
+	if err != nil {
+		return err
+	}
 
-		return tree.save()
+	if full {
+		log.Debug().
+			Msg("Root chunk full, pushing to layer0")
+
+		return tree.pushRootIfCurrent(root)
 	}
 	return nil
 }
 
+// Returns the root chunk, the chunk written to.
+func (tree *LsmTree) root() *chunk {
+	tree.rootLock.RLock()
+	defer tree.rootLock.RUnlock()
+	return tree.rootChunk
+}
+
+// Pushes the root chunk to layer 0 and replaces it with a new, empty, chunk.
+func (tree *LsmTree) pushRoot() error {
+	return tree.pushRootIfCurrent(nil)
+}
+
+// Pushes the root chunk to layer 0 if it's still current. Writers filling
+// the root at the same time all try to push it, only the first one does. If
+// current is nil the root is always pushed.
+func (tree *LsmTree) pushRootIfCurrent(current *chunk) error {
+	tree.rootLock.Lock()
+	root := tree.rootChunk
+	if current != nil && root != current {
+		tree.rootLock.Unlock()
+		return nil
+	}
+
+	// No writes are in progress, so every record appended to the WAL from
+	// here on belongs to the new chunk
+	chunkName := randomString(6)
+	data, err := tree.createChunkData(chunkTypeMemtable, chunkName)
+	if err != nil {
+		tree.rootLock.Unlock()
+		return err
+	}
+	tree.layers[0].lock.Lock()
+	tree.layers[0].chunks = append([]*chunk{root}, tree.layers[0].chunks...)
+	tree.layers[0].lock.Unlock()
+	tree.rootChunk = newChunk(chunkName, data, chunkTypeMemtable)
+	tree.rootLock.Unlock()
+
+	return tree.save()
+}
+
+// Merges the memtable chunks of the tree into an SSTable, freeing their
+// memory once no iterators use them.
+func (tree *LsmTree) flushMemtables() error {
+	tree.writeLock.Lock()
+	err := tree.pushRoot()
+	tree.writeLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return tree.mergeLayer(0)
+}
+
+// Calls fn for the root and every other memtable chunk of the tree.
+func (tree *LsmTree) visitMemtables(fn func(c *memtableChunk)) {
+	if mt, ok := tree.root().data.(*memtableChunk); ok {
+		fn(mt)
+	}
+	for i := range tree.layers {
+		tree.layers[i].lock.RLock()
+		for _, c := range tree.layers[i].chunks {
+			if mt, ok := c.data.(*memtableChunk); ok {
+				fn(mt)
+			}
+		}
+		tree.layers[i].lock.RUnlock()
+	}
+}
+
+// Bytes of memory used by the memtable chunks of the tree, holding the
+// writes that aren't merged into SSTables yet.
+func (tree *LsmTree) MemtableSize() uint64 {
+	total := uint64(0)
+	tree.visitMemtables(func(c *memtableChunk) { total += c.size() })
+	return total
+}
+
 func (tree *LsmTree) LayerSizes() []uint64 {
 	result := make([]uint64, len(tree.layers))
 
@@ -490,21 +935,53 @@ func (tree *LsmTree) LayerSizes() []uint64 {
 	return result
 }
 
-func (tree *LsmTree) Get(key string) (data []byte, exists bool, err error) {
-	kind, data, exists, err := tree.rootChunk.data.get(key)
-	if err != nil {
-		return nil, false, err
-	}
-	if err != nil {
-		return nil, false, err
+// Hit and miss statistics of the block cache shared by the SSTables of the
+// tree. Returns false if the tree doesn't use a block cache.
+func (tree *LsmTree) BlockCacheStats() (sstable.BlockCacheStats, bool) {
+	if tree.blockCache == nil {
+		return sstable.BlockCacheStats{}, false
 	}
+	return tree.blockCache.Stats(), true
+}
+
+func (tree *LsmTree) Get(key string) (data []byte, exists bool, err error) {
+	data, _, exists, err = tree.GetWithVersion(key)
+	return data, exists, err
+}
 
-	if kind == RecordKindDelete {
-		return nil, false, nil
+// Finds the newest record of a key, looking in the root chunk first and
+// then in every layer from the top. Expired records are returned, they still
+// hide older records of the key.
+func (tree *LsmTree) getRecord(key string) (kind uint64, data []byte, expiresAt int64, exists bool, err error) {
+	err = tree.walkRecords(key, func(e entry) bool {
+		kind, data, expiresAt, exists = e.kind, e.data, e.expiresAt, true
+		return false
+	})
+	return kind, data, expiresAt, exists, err
+}
+
+// Calls fn with the record of a key in every chunk that has one, newest
+// first, until fn returns false.
+func (tree *LsmTree) walkRecords(key string, fn func(e entry) bool) error {
+	visit := func(c *chunk) (bool, error) {
+		e, exists, err := getFromChunk(c, key, tree.cmp)
+		if err != nil || !exists {
+			return true, err
+		}
+		if !fn(e) {
+			return false, nil
+		}
+		// Merge operands are newer than the range tombstones of their chunk,
+		// which still hide the older records of the key
+		if e.kind == RecordKindMerge && coveredByTombstone(c.data.rangeTombstones(), key, tree.cmp) {
+			return fn(entry{key: key, kind: RecordKindDelete}), nil
+		}
+		return true, nil
 	}
 
-	if exists {
-		return data, true, nil
+	cont, err := visit(tree.root())
+	if err != nil || !cont {
+		return err
 	}
 
 	for i := 0; i < len(tree.layers); i++ {
@@ -513,19 +990,11 @@ func (tree *LsmTree) Get(key string) (data []byte, exists bool, err error) {
 		defer layer.lock.RUnlock()
 
 		for j := 0; j < len(layer.chunks); j++ {
-			kind, data, exists, err := layer.chunks[j].data.get(key)
-			if err != nil {
-				return nil, false, err
-			}
-
-			if kind == RecordKindDelete {
-				return nil, false, nil
-			}
-
-			if exists {
-				return data, true, nil
+			cont, err := visit(layer.chunks[j])
+			if err != nil || !cont {
+				return err
 			}
 		}
 	}
-	return make([]byte, 0), false, nil
+	return nil
 }
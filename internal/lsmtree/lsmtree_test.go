@@ -0,0 +1,1221 @@
+package lsmtree
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/lindend/distdb/internal/encryption"
+	"github.com/lindend/distdb/internal/sstable"
+	"github.com/lindend/distdb/internal/wal"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTree(t *T, options Options) *LsmTree {
+	// Merges are triggered manually by the tests
+	options.MergeInterval = 0
+	tree, err := NewLsmTree(t.TempDir(), options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+func assertValue(t *T, tree *LsmTree, key string, expected string) {
+	data, exists, err := tree.Get(key)
+	assert.Nil(t, err)
+	assert.True(t, exists, "%v should exist", key)
+	assert.Equal(t, expected, string(data), "value of %v", key)
+}
+
+func assertMissing(t *T, tree *LsmTree, key string) {
+	_, exists, err := tree.Get(key)
+	assert.Nil(t, err)
+	assert.False(t, exists, "%v should not exist", key)
+}
+
+func scan(t *T, tree *LsmTree, start string, end string) []string {
+	it := tree.NewIterator(start, end)
+	defer it.Close()
+
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.Nil(t, it.Err())
+	return keys
+}
+
+func setKeys(t *T, tree *LsmTree, keys ...string) {
+	for _, k := range keys {
+		assert.Nil(t, tree.Set(k, []byte("value-"+k)))
+	}
+}
+
+func TestDelete(t *T) {
+	tree := newTestTree(t, DefaultOptions())
+	setKeys(t, tree, "a", "b")
+
+	assert.Nil(t, tree.Delete("a"))
+	assertMissing(t, tree, "a")
+
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	assertMissing(t, tree, "a")
+	assertValue(t, tree, "b", "value-b")
+}
+
+func TestDeleteRangeInRoot(t *T) {
+	tree := newTestTree(t, DefaultOptions())
+	setKeys(t, tree, "a", "b", "c", "d", "e")
+
+	assert.Nil(t, tree.DeleteRange("b", "d"))
+	assertMissing(t, tree, "b")
+	assertMissing(t, tree, "c")
+	assertValue(t, tree, "d", "value-d")
+
+	// Writes after the tombstone are visible
+	setKeys(t, tree, "c")
+	assertValue(t, tree, "c", "value-c")
+	assert.Equal(t, []string{"a", "c", "d", "e"}, scan(t, tree, "", ""))
+
+	assert.NotNil(t, tree.DeleteRange("d", "b"), "should reject empty ranges")
+}
+
+func TestDeleteRangeHidesOlderChunks(t *T) {
+	tree := newTestTree(t, DefaultOptions())
+	setKeys(t, tree, "a", "b", "c", "d", "e")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+
+	assert.Nil(t, tree.DeleteRange("b", "e"))
+	assertMissing(t, tree, "c")
+	assert.Equal(t, []string{"a", "e"}, scan(t, tree, "", ""))
+
+	// Once merged, the tombstone lives in an SSTable on top of the old data
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	assert.Equal(t, 2, len(tree.layers[1].chunks))
+	assertMissing(t, tree, "c")
+	assertValue(t, tree, "e", "value-e")
+	assert.Equal(t, []string{"a", "e"}, scan(t, tree, "", ""))
+	assert.Equal(t, []string{"e"}, scan(t, tree, "c", ""))
+
+	// Merging to the bottom drops both covered keys and the tombstone
+	assert.Nil(t, tree.mergeLayer(1))
+	bottom := tree.layers[2].chunks[0].data
+	assert.Equal(t, int64(2), bottom.numEntries())
+	assert.Empty(t, bottom.rangeTombstones())
+	assert.Equal(t, []string{"a", "e"}, scan(t, tree, "", ""))
+}
+
+func TestIteratorReturnsNewestValues(t *T) {
+	tree := newTestTree(t, DefaultOptions())
+	setKeys(t, tree, "a", "b", "c")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.Set("b", []byte("new")))
+
+	it := tree.NewIterator("b", "c")
+	defer it.Close()
+	assert.True(t, it.Next())
+	assert.Equal(t, "b", it.Key())
+	assert.Equal(t, "new", string(it.Value()))
+	assert.False(t, it.Next())
+}
+
+func TestValueLogSeparatesLargeValues(t *T) {
+	options := DefaultOptions()
+	options.ValueLogThreshold = 64
+	tree := newTestTree(t, options)
+
+	large := strings.Repeat("x", 100)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, tree.Set(fmt.Sprintf("key%v", i), []byte(large+fmt.Sprint(i))))
+	}
+	assert.Nil(t, tree.Set("small", []byte("value")))
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+
+	kind, _, _, _, err := tree.getRecord("key3")
+	assert.Nil(t, err)
+	assert.Equal(t, RecordKindValuePointer, kind)
+	kind, _, _, _, _ = tree.getRecord("small")
+	assert.Equal(t, RecordKindWrite, kind)
+
+	assertValue(t, tree, "key3", large+"3")
+	assertValue(t, tree, "small", "value")
+
+	// Overwrite half of the values, the old ones become garbage
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, tree.Set(fmt.Sprintf("key%v", i), []byte("overwritten")))
+	}
+
+	collected, err := tree.CollectValueLog(0.5)
+	assert.Nil(t, err)
+	assert.True(t, collected)
+
+	assertValue(t, tree, "key1", "overwritten")
+	assertValue(t, tree, "key7", large+"7")
+	it := tree.NewIterator("key5", "key6")
+	assert.True(t, it.Next())
+	assert.Equal(t, large+"5", string(it.Value()))
+	it.Close()
+}
+
+func TestPrefixIterator(t *T) {
+	options := DefaultOptions()
+	options.PrefixExtractor = sstable.NewDelimitedPrefixExtractor(':')
+	tree := newTestTree(t, options)
+
+	setKeys(t, tree, "order:1", "order:2", "user:1")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	setKeys(t, tree, "user:2", "users")
+	assert.Nil(t, tree.DeleteRange("order:2", "order:3"))
+
+	it := tree.NewPrefixIterator("user:")
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.Nil(t, it.Err())
+	it.Close()
+	assert.Equal(t, []string{"user:1", "user:2"}, keys)
+
+	it = tree.NewPrefixIterator("order:")
+	keys = []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	it.Close()
+	assert.Equal(t, []string{"order:1"}, keys)
+}
+
+func TestFilterOptionsPerLayer(t *T) {
+	options := DefaultOptions()
+	options.FilterType = sstable.XorFilterType
+	options.FilterFalsePositiveRates = []float64{0.01, 0.001}
+	tree := newTestTree(t, options)
+
+	assert.Equal(t, 0.01, tree.tableBuildOptions(0).FilterFalsePositiveRate)
+	assert.Equal(t, 0.001, tree.tableBuildOptions(1).FilterFalsePositiveRate)
+	assert.Equal(t, 0.001, tree.tableBuildOptions(3).FilterFalsePositiveRate)
+	assert.Equal(t, sstable.XorFilterType, tree.tableBuildOptions(0).FilterType)
+
+	setKeys(t, tree, "a", "b", "c")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	assertValue(t, tree, "b", "value-b")
+	assertMissing(t, tree, "d")
+}
+
+func TestMergesLayerWithManyDeletes(t *T) {
+	options := DefaultOptions()
+	options.MergeDeletionRatio = 0.3
+	tree := newTestTree(t, options)
+
+	setKeys(t, tree, "a", "b", "c", "d")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	assert.False(t, tree.shouldMerge(1), "layer without deletes shouldn't be merged")
+
+	for _, k := range []string{"a", "b", "c"} {
+		assert.Nil(t, tree.Delete(k))
+	}
+	setKeys(t, tree, "e")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+
+	props, exists := tree.layers[1].chunks[0].data.properties()
+	assert.True(t, exists)
+	assert.Equal(t, int64(3), props.EntriesByKind[RecordKindDelete])
+	assert.True(t, tree.shouldMerge(1), "3 of 8 entries are deletes in layer 1")
+}
+
+type testCompactionFilter struct {
+	contexts []CompactionContext
+}
+
+func (f *testCompactionFilter) Filter(ctx CompactionContext, key string, value []byte) (CompactionDecision, []byte) {
+	f.contexts = append(f.contexts, ctx)
+	switch {
+	case strings.HasPrefix(key, "expired"):
+		return CompactionRemove, nil
+	case strings.HasPrefix(key, "upper"):
+		return CompactionChangeValue, []byte(strings.ToUpper(string(value)))
+	}
+	return CompactionKeep, nil
+}
+
+func TestCompactionFilter(t *T) {
+	filter := &testCompactionFilter{}
+	options := DefaultOptions()
+	options.CompactionFilter = filter
+	tree := newTestTree(t, options)
+
+	setKeys(t, tree, "expired1", "keep", "upper")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+
+	assertMissing(t, tree, "expired1")
+	assertValue(t, tree, "keep", "value-keep")
+	assertValue(t, tree, "upper", "VALUE-UPPER")
+	assert.Equal(t, CompactionContext{Layer: 0, TargetLayer: 1, Bottommost: true}, filter.contexts[0])
+
+	// Removed keys hide older values when the merge isn't bottommost
+	setKeys(t, tree, "expired2")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	assert.Nil(t, tree.Set("expired2", []byte("newer")))
+	assert.Nil(t, tree.pushRoot())
+	filter.contexts = nil
+	assert.Nil(t, tree.mergeLayer(0))
+	assert.False(t, filter.contexts[0].Bottommost)
+	assertMissing(t, tree, "expired2")
+	assert.Equal(t, []string{"keep", "upper"}, scan(t, tree, "", ""))
+}
+
+func TestCompactionFilterKeepsVersions(t *T) {
+	options := DefaultOptions()
+	options.CompactionFilter = &testCompactionFilter{}
+	tree := newTestTree(t, options)
+
+	setKeys(t, tree, "upper")
+	_, version, _, err := tree.GetWithVersion("upper")
+	assert.Nil(t, err)
+	assert.NotEqual(t, uint64(0), version)
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+
+	data, merged, exists, err := tree.GetWithVersion("upper")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "VALUE-UPPER", string(data))
+	assert.Equal(t, version, merged, "Changing the value should keep the version of the key")
+
+	ok, err := tree.SetIfVersion("upper", 0, []byte("stale"))
+	assert.Nil(t, err)
+	assert.False(t, ok, "Should not write with a version the key never had")
+	ok, err = tree.DeleteIfVersion("upper", 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = tree.SetIfVersion("upper", version, []byte("fresh"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestGetBorrowed(t *T) {
+	tree := newTestTree(t, DefaultOptions())
+	setKeys(t, tree, "merged", "deleted", "expired")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	setKeys(t, tree, "root")
+	assert.Nil(t, tree.Delete("deleted"))
+	assert.Nil(t, tree.SetWithTTL("expired", []byte("gone"), time.Nanosecond))
+	assert.Nil(t, tree.DeleteRange("p", "q"))
+	time.Sleep(time.Millisecond)
+
+	value := Value{}
+	for _, k := range []string{"merged", "root"} {
+		exists, err := tree.GetBorrowed(k, &value)
+		assert.Nil(t, err)
+		assert.True(t, exists, k)
+		assert.Equal(t, "value-"+k, string(value.Data))
+		_, version, _, err := tree.GetWithVersion(k)
+		assert.Nil(t, err)
+		assert.Equal(t, version, value.Version, k)
+		value.Release()
+	}
+	for _, k := range []string{"deleted", "expired", "missing", "px"} {
+		exists, err := tree.GetBorrowed(k, &value)
+		assert.Nil(t, err)
+		assert.False(t, exists, k)
+		assert.Nil(t, value.Data)
+	}
+}
+
+func TestGetBorrowedDoesNotAllocate(t *T) {
+	if raceEnabled {
+		t.Skip("allocations are not stable with the race detector")
+	}
+	for _, mode := range []sstable.IOMode{sstable.IOModeMmap, sstable.IOModePread} {
+		options := DefaultOptions()
+		options.IOMode = mode
+		tree := newTestTree(t, options)
+		setKeys(t, tree, "table")
+		assert.Nil(t, tree.pushRoot())
+		assert.Nil(t, tree.mergeLayer(0))
+		setKeys(t, tree, "root")
+
+		value := Value{}
+		for _, k := range []string{"table", "root"} {
+			allocs := AllocsPerRun(100, func() {
+				exists, err := tree.GetBorrowed(k, &value)
+				if !exists || err != nil {
+					t.Fatalf("%v should exist", k)
+				}
+				value.Release()
+			})
+			assert.Equal(t, float64(0), allocs, "%v in mode %v", k, mode)
+		}
+	}
+}
+
+func TestSetWithTTL(t *T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	tree, err := NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	now := time.Unix(1000, 0)
+	tree.now = func() time.Time { return now }
+
+	setKeys(t, tree, "session", "other")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+
+	assert.NotNil(t, tree.SetWithTTL("session", []byte("new"), 0))
+	assert.Nil(t, tree.SetWithTTL("session", []byte("new"), time.Minute))
+	assert.Nil(t, tree.SetWithTTL("cache", []byte("cached"), time.Hour))
+	assertValue(t, tree, "session", "new")
+
+	// The expiry is kept in the WAL
+	assert.Nil(t, tree.Close())
+	tree, err = NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	tree.now = func() time.Time { return now }
+	assertValue(t, tree, "session", "new")
+
+	// Expired keys hide the older values of the key
+	now = now.Add(2 * time.Minute)
+	assertMissing(t, tree, "session")
+	assertValue(t, tree, "cache", "cached")
+	assert.Equal(t, []string{"cache", "other"}, scan(t, tree, "", ""))
+
+	// Keys that haven't expired keep their expiry in merged tables. Expired
+	// keys are replaced with deletes, since layer 1 has older values.
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	kind, _, _, exists, err := tree.getRecord("session")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, RecordKindDelete, kind)
+	_, _, expiresAt, _, err := tree.getRecord("cache")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Unix(1000, 0).Add(time.Hour).UnixNano(), expiresAt)
+
+	// Merging to the bottom of the tree removes expired keys
+	now = now.Add(time.Hour)
+	assertMissing(t, tree, "cache")
+	assert.Nil(t, tree.mergeLayer(1))
+	for _, k := range []string{"session", "cache"} {
+		_, _, _, exists, err = tree.getRecord(k)
+		assert.Nil(t, err)
+		assert.False(t, exists, "%v should be removed", k)
+	}
+	assert.Equal(t, []string{"other"}, scan(t, tree, "", ""))
+}
+
+func assertCounter(t *T, tree *LsmTree, key string, expected int64) {
+	data, exists, err := tree.Get(key)
+	assert.Nil(t, err)
+	assert.True(t, exists, "%v should exist", key)
+	value, err := DecodeInt64(data)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, value, "value of %v", key)
+}
+
+func TestMergeOperator(t *T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	options.MergeOperator = NewInt64AddOperator()
+	tree, err := NewLsmTree(dir, options)
+	assert.Nil(t, err)
+
+	assert.Nil(t, tree.Set("counter", EncodeInt64(10)))
+	assert.Nil(t, tree.Set("deleted", EncodeInt64(10)))
+	assert.Nil(t, tree.Set("ranged", EncodeInt64(10)))
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+
+	// Operands in the same chunk are combined when written
+	assert.Nil(t, tree.Merge("counter", EncodeInt64(1)))
+	assert.Nil(t, tree.Merge("counter", EncodeInt64(1)))
+	assert.Nil(t, tree.Merge("new", EncodeInt64(3)))
+	assert.Nil(t, tree.Delete("deleted"))
+	assert.Nil(t, tree.Merge("deleted", EncodeInt64(2)))
+	assert.Nil(t, tree.DeleteRange("r", "s"))
+	assert.Nil(t, tree.Merge("ranged", EncodeInt64(4)))
+	assert.NotNil(t, tree.Merge("counter", []byte("not an int64")))
+	assertCounter(t, tree, "counter", 12)
+
+	// Operands are kept in the WAL
+	assert.Nil(t, tree.Close())
+	tree, err = NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	assertCounter(t, tree, "counter", 12)
+
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.Merge("counter", EncodeInt64(5)))
+	expected := map[string]int64{"counter": 17, "deleted": 2, "new": 3, "ranged": 4}
+	assertCounters := func() {
+		for k, v := range expected {
+			assertCounter(t, tree, k, v)
+		}
+		it := tree.NewIterator("", "")
+		defer it.Close()
+		for it.Next() {
+			value, err := DecodeInt64(it.Value())
+			assert.Nil(t, err)
+			assert.Equal(t, expected[it.Key()], value, "value of %v", it.Key())
+		}
+		assert.Nil(t, it.Err())
+	}
+	assertCounters()
+	assert.Equal(t, []string{"counter", "deleted", "new", "ranged"}, scan(t, tree, "", ""))
+
+	// Layer 1 has the older values, the merged operands are kept as operands
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	kind, _, _, _, err := tree.getRecord("new")
+	assert.Nil(t, err)
+	assert.Equal(t, RecordKindMerge, kind)
+	assertCounters()
+
+	// Merging to the bottom applies the operands
+	assert.Nil(t, tree.mergeLayer(1))
+	for k := range expected {
+		kind, _, _, _, err := tree.getRecord(k)
+		assert.Nil(t, err)
+		assert.Equal(t, RecordKindWrite, kind, "kind of %v", k)
+	}
+	assertCounters()
+}
+
+func TestMergeWithoutOperator(t *T) {
+	tree := newTestTree(t, DefaultOptions())
+	assert.NotNil(t, tree.Merge("key", []byte("operand")))
+}
+
+func TestBuiltinMergeOperators(t *T) {
+	appendOp := NewStringAppendOperator(",")
+	value, err := appendOp.Merge("key", nil, []byte("a"))
+	assert.Nil(t, err)
+	value, err = appendOp.Merge("key", value, []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, "a,b", string(value))
+
+	maxOp := NewMaxOperator()
+	value, _ = maxOp.Merge("key", nil, []byte("b"))
+	value, _ = maxOp.Merge("key", value, []byte("a"))
+	assert.Equal(t, "b", string(value))
+	value, _ = maxOp.Merge("key", value, []byte("c"))
+	assert.Equal(t, "c", string(value))
+
+	addOp := NewInt64AddOperator()
+	value, err = addOp.Merge("key", nil, EncodeInt64(-2))
+	assert.Nil(t, err)
+	value, err = addOp.Merge("key", value, EncodeInt64(5))
+	assert.Nil(t, err)
+	n, err := DecodeInt64(value)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), n)
+	_, err = addOp.Merge("key", []byte("bad"), EncodeInt64(1))
+	assert.NotNil(t, err)
+}
+
+func TestMergeOperandsOnValuePointer(t *T) {
+	options := DefaultOptions()
+	options.ValueLogThreshold = 64
+	options.MergeOperator = NewStringAppendOperator(",")
+	tree := newTestTree(t, options)
+
+	large := strings.Repeat("x", 100)
+	assert.Nil(t, tree.Set("key", []byte(large)))
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+
+	assert.Nil(t, tree.Merge("key", []byte("a")))
+	assertValue(t, tree, "key", large+",a")
+
+	// The value log still holds the value below the operand
+	collected, err := tree.CollectValueLog(0)
+	assert.Nil(t, err)
+	assert.True(t, collected)
+	assertValue(t, tree, "key", large+",a")
+	assert.Nil(t, tree.Merge("key", []byte("b")))
+	assertValue(t, tree, "key", large+",a,b")
+}
+
+func TestVersionsSurviveReopenAndMerges(t *T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	tree, err := NewLsmTree(dir, options)
+	assert.Nil(t, err)
+
+	setKeys(t, tree, "a", "b")
+	_, versionA, exists, err := tree.GetWithVersion("a")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	_, versionB, _, err := tree.GetWithVersion("b")
+	assert.Nil(t, err)
+	assert.Greater(t, versionB, versionA)
+
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	assert.Nil(t, tree.Close())
+
+	tree, err = NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	data, version, exists, err := tree.GetWithVersion("a")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "value-a", string(data))
+	assert.Equal(t, versionA, version)
+
+	// New writes continue after the versions of the loaded chunks
+	setKeys(t, tree, "a")
+	_, version, _, err = tree.GetWithVersion("a")
+	assert.Nil(t, err)
+	assert.Greater(t, version, versionB)
+
+	_, _, exists, err = tree.GetWithVersion("missing")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestConditionalWrites(t *T) {
+	tree := newTestTree(t, DefaultOptions())
+
+	ok, err := tree.SetIfAbsent("leader", []byte("node-1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = tree.SetIfAbsent("leader", []byte("node-2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assertValue(t, tree, "leader", "node-1")
+
+	ok, err = tree.SetIfEquals("leader", []byte("node-2"), []byte("node-3"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = tree.SetIfEquals("leader", []byte("node-1"), []byte("node-3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assertValue(t, tree, "leader", "node-3")
+
+	_, version, _, err := tree.GetWithVersion("leader")
+	assert.Nil(t, err)
+	ok, err = tree.SetIfVersion("leader", version-1, []byte("node-4"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = tree.SetIfVersion("leader", version, []byte("node-4"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = tree.DeleteIfVersion("leader", version)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = tree.DeleteIfEquals("leader", []byte("node-3"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = tree.DeleteIfEquals("leader", []byte("node-4"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assertMissing(t, tree, "leader")
+
+	// Deleted keys are absent, and missing keys never equal a value
+	ok, err = tree.SetIfEquals("leader", nil, []byte("node-5"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = tree.SetIfAbsent("leader", []byte("node-5"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assertValue(t, tree, "leader", "node-5")
+}
+
+func TestConcurrentCompareAndSwap(t *T) {
+	tree := newTestTree(t, DefaultOptions())
+	assert.Nil(t, tree.Set("counter", EncodeInt64(0)))
+
+	const workers = 8
+	const increments = 50
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				data, version, _, err := tree.GetWithVersion("counter")
+				assert.Nil(t, err)
+				value, err := DecodeInt64(data)
+				assert.Nil(t, err)
+				ok, err := tree.SetIfVersion("counter", version, EncodeInt64(value+1))
+				assert.Nil(t, err)
+				if ok {
+					i++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assertCounter(t, tree, "counter", workers*increments)
+}
+
+func TestConcurrentDeleteRangeAndGet(t *T) {
+	tree := newTestTree(t, DefaultOptions())
+	setKeys(t, tree, "a", "z")
+
+	const deletes = 200
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < deletes; i++ {
+			start := fmt.Sprintf("key%03d", i)
+			assert.Nil(t, tree.DeleteRange(start, start+"~"))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < deletes; i++ {
+			assertValue(t, tree, "a", "value-a")
+			assertMissing(t, tree, fmt.Sprintf("key%03d", i))
+		}
+	}()
+	wg.Wait()
+	assertValue(t, tree, "z", "value-z")
+}
+
+func TestConcurrentSetsPushFullRoot(t *T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	tree, err := NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	tree.maxRootChunkSize = 256 * Kilobyte
+
+	const workers = 8
+	const keysPerWorker = 3000
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWorker; i++ {
+				assert.Nil(t, tree.Set(fmt.Sprintf("%v-%05d", w, i), []byte("value")))
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.Greater(t, len(tree.layers[0].chunks), 1, "Should push the root to layer 0")
+	assert.Nil(t, tree.Close())
+
+	tree, err = NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	defer tree.Close()
+	for w := 0; w < workers; w++ {
+		for i := 0; i < keysPerWorker; i += 100 {
+			assertValue(t, tree, fmt.Sprintf("%v-%05d", w, i), "value")
+		}
+	}
+	assert.Len(t, scan(t, tree, "", ""), workers*keysPerWorker)
+}
+
+func TestReverseComparator(t *T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	options.Comparator = sstable.ReverseBytewiseComparator
+	tree, err := NewLsmTree(dir, options)
+	assert.Nil(t, err)
+
+	setKeys(t, tree, "a:1", "b:1", "b:2", "c:1")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	setKeys(t, tree, "b:3", "d:1")
+	assert.Equal(t, []string{"d:1", "c:1", "b:3", "b:2", "b:1", "a:1"}, scan(t, tree, "", ""))
+	assert.Equal(t, []string{"c:1", "b:3"}, scan(t, tree, "c:9", "b:2"))
+
+	it := tree.NewPrefixIterator("b:")
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	it.Close()
+	assert.Equal(t, []string{"b:3", "b:2", "b:1"}, keys)
+
+	// Ranges to delete are in the order of the comparator too
+	assert.NotNil(t, tree.DeleteRange("b:1", "b:3"))
+	assert.Nil(t, tree.DeleteRange("b:3", "b:1"))
+	assert.Equal(t, []string{"d:1", "c:1", "b:1", "a:1"}, scan(t, tree, "", ""))
+	assert.Nil(t, tree.Close())
+
+	// The tree can only be opened with the comparator it was created with
+	_, err = NewLsmTree(dir, DefaultOptions())
+	assert.NotNil(t, err)
+	tree, err = NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	assertValue(t, tree, "b:1", "value-b:1")
+	assertMissing(t, tree, "b:2")
+}
+
+func TestBinaryKeys(t *T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	tree, err := NewLsmTree(dir, options)
+	assert.Nil(t, err)
+
+	// Big-endian encoded integers are ordered by value
+	key := func(i uint64) string {
+		return string(EncodeInt64(int64(i)))
+	}
+	for _, i := range []uint64{0xff00, 1, 0xff} {
+		assert.Nil(t, tree.Set(key(i), []byte{byte(i)}))
+	}
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	assert.Nil(t, tree.Set(key(0x80), []byte{0x80}))
+
+	// Keys that aren't valid UTF-8 survive the WAL and the tables
+	assert.Nil(t, tree.Close())
+	tree, err = NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	assert.Equal(t, []string{key(1), key(0x80), key(0xff), key(0xff00)}, scan(t, tree, "", ""))
+	assertValue(t, tree, key(0x80), "\x80")
+}
+
+func TestWALSegmentsDeletedAfterMerge(t *T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	options.WALMaxSegmentSize = 500
+	tree, err := NewLsmTree(dir, options)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, tree.Set(fmt.Sprintf("key%03d", i), []byte("old")))
+	}
+	assert.Nil(t, tree.pushRoot())
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, tree.Set(fmt.Sprintf("key%03d", i), []byte("new")))
+	}
+	segments := tree.log.NumSegments()
+	assert.Greater(t, segments, 10)
+
+	// Only the segments with records of the root are kept once layer 0 is
+	// merged
+	assert.Nil(t, tree.mergeLayer(0))
+	assert.Less(t, tree.log.NumSegments(), segments/2)
+	assert.Nil(t, tree.Close())
+
+	tree, err = NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	assertValue(t, tree, "key010", "new")
+	assertValue(t, tree, "key060", "old")
+	assert.Equal(t, int64(50), tree.rootChunk.data.numEntries())
+}
+
+func TestWALReplaysRecordsIntoTheirChunks(t *T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	tree, err := NewLsmTree(dir, options)
+	assert.Nil(t, err)
+
+	setKeys(t, tree, "a", "b")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.Set("a", []byte("newer")))
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.Delete("b"))
+	assert.Nil(t, tree.Close())
+
+	tree, err = NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	assertValue(t, tree, "a", "newer")
+	assertMissing(t, tree, "b")
+	assert.Equal(t, int64(1), tree.rootChunk.data.numEntries())
+	assert.Len(t, tree.layers[0].chunks, 2)
+	assert.Equal(t, int64(1), tree.layers[0].chunks[0].data.numEntries())
+	assert.Equal(t, int64(2), tree.layers[0].chunks[1].data.numEntries())
+}
+
+func TestMigratesWALFilesOfChunks(t *T) {
+	dir := t.TempDir()
+	writeWAL := func(chunkName string, key string, data string) {
+		w, err := wal.NewWAL(path.Join(dir, fmt.Sprintf("wal-%v.log", chunkName)))
+		assert.Nil(t, err)
+		assert.Nil(t, w.Write(RecordKindWrite, key, []byte(data)))
+		assert.Nil(t, w.Close())
+	}
+	writeWAL("old", "a", "old")
+	writeWAL("old", "b", "old")
+	writeWAL("root", "a", "new")
+
+	// A tree written before the WAL was segmented, with a WAL file per chunk
+	file, err := os.Create(path.Join(dir, "lsm.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, json.NewEncoder(file).Encode(lsmTreeJson{
+		Layers: []lsmLayerJson{
+			{Name: "layer-0", MaxChunks: 4, Chunks: []lsmChunkJson{{Name: "old", ChunkType: chunkTypeMemtable}}},
+			{Name: "layer-1", MaxChunks: 0, Chunks: []lsmChunkJson{}},
+		},
+		Root:             lsmChunkJson{Name: "root", ChunkType: chunkTypeMemtable},
+		MaxRootChunkSize: 16 * Megabyte,
+	}))
+	assert.Nil(t, file.Close())
+
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	for i := 0; i < 2; i++ {
+		tree, err := NewLsmTree(dir, options)
+		assert.Nil(t, err)
+		assertValue(t, tree, "a", "new")
+		assertValue(t, tree, "b", "old")
+		assert.Nil(t, tree.Close())
+
+		_, err = os.Stat(path.Join(dir, "wal-old.log"))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func nextChange(t *T, s *ChangeStream) Change {
+	c, err := s.Next(context.Background())
+	assert.Nil(t, err)
+	return c
+}
+
+func TestChangesStreamsWrites(t *T) {
+	options := DefaultOptions()
+	options.MergeOperator = NewInt64AddOperator()
+	tree := newTestTree(t, options)
+
+	assert.Nil(t, tree.Set("a", []byte("1")))
+	assert.Nil(t, tree.Set("b", []byte("2")))
+	s, err := tree.Changes(2)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	assert.Equal(t, Change{Kind: ChangePut, Key: "b", Value: []byte("2"), Seq: 2}, withoutLSN(nextChange(t, s)))
+
+	// New writes are streamed as they're written, also after the root is
+	// pushed to layer 0
+	go func() {
+		assert.Nil(t, tree.Delete("a"))
+		assert.Nil(t, tree.pushRoot())
+		assert.Nil(t, tree.DeleteRange("b", "c"))
+		assert.Nil(t, tree.Merge("n", EncodeInt64(5)))
+	}()
+	assert.Equal(t, Change{Kind: ChangeDelete, Key: "a", Seq: 3}, withoutLSN(nextChange(t, s)))
+	assert.Equal(t, Change{Kind: ChangeDeleteRange, Key: "b", End: "c", Seq: 4}, withoutLSN(nextChange(t, s)))
+	assert.Equal(t, Change{Kind: ChangeMerge, Key: "n", Value: EncodeInt64(5), Seq: 5}, withoutLSN(nextChange(t, s)))
+}
+
+func withoutLSN(c Change) Change {
+	c.lsn = 0
+	return c
+}
+
+func TestChangesKeepWALUntilAcknowledged(t *T) {
+	options := DefaultOptions()
+	options.WALMaxSegmentSize = 500
+	tree := newTestTree(t, options)
+
+	s, err := tree.Changes(1)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, tree.Set(fmt.Sprintf("key%03d", i), []byte("value")))
+	}
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	segments := tree.log.NumSegments()
+	assert.Greater(t, segments, 10)
+
+	var c Change
+	for i := 0; i < 100; i++ {
+		c = nextChange(t, s)
+		assert.Equal(t, fmt.Sprintf("key%03d", i), c.Key)
+	}
+	s.Ack(c)
+	assert.Equal(t, 1, tree.log.NumSegments())
+	assert.Nil(t, s.Close())
+
+	// The merged changes are gone
+	_, err = nextChangeOf(tree, 1)
+	assert.ErrorIs(t, err, ErrChangesUnavailable)
+}
+
+func nextChangeOf(tree *LsmTree, fromSeq uint64) (Change, error) {
+	s, err := tree.Changes(fromSeq)
+	if err != nil {
+		return Change{}, err
+	}
+	defer s.Close()
+	go tree.Set("next", []byte("value"))
+	return s.Next(context.Background())
+}
+
+func TestEncryptedWAL(t *T) {
+	dir := t.TempDir()
+	keys, err := encryption.NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	assert.Nil(t, err)
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	options.WALCompression = true
+	options.KeyProvider = keys
+	tree, err := NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Set("key", []byte("secret")))
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.Close())
+
+	segments, err := os.ReadDir(path.Join(dir, walDir))
+	assert.Nil(t, err)
+	for _, s := range segments {
+		data, err := os.ReadFile(path.Join(dir, walDir, s.Name()))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(data, []byte("secret")))
+	}
+
+	options.KeyProvider = nil
+	_, err = NewLsmTree(dir, options)
+	assert.NotNil(t, err)
+
+	options.KeyProvider = keys
+	tree, err = NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	assertValue(t, tree, "key", "secret")
+}
+
+// Ids of the keys the SSTables of the tree are encrypted with.
+func tableKeyIDs(tree *LsmTree) []string {
+	ids := []string{}
+	for _, l := range tree.layers {
+		for _, c := range l.chunks {
+			if tbl, ok := c.data.(*sstableChunk); ok {
+				ids = append(ids, tbl.tbl.EncryptionKeyID())
+			}
+		}
+	}
+	return ids
+}
+
+func TestKeyRotationByMerges(t *T) {
+	keys, err := encryption.OpenFileKeyProvider(path.Join(t.TempDir(), "keys"))
+	assert.Nil(t, err)
+	options := DefaultOptions()
+	options.KeyProvider = keys
+	tree := newTestTree(t, options)
+
+	setKeys(t, tree, "a", "b")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	assert.Equal(t, []string{"000001"}, tableKeyIDs(tree))
+
+	// Tables merged after the rotation are encrypted with the new key
+	_, err = keys.Rotate()
+	assert.Nil(t, err)
+	setKeys(t, tree, "c")
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	assert.Nil(t, tree.mergeLayer(1))
+	assert.Equal(t, []string{"000002"}, tableKeyIDs(tree))
+	assertValue(t, tree, "a", "value-a")
+	assertValue(t, tree, "c", "value-c")
+}
+
+func TestMergeFailsOnUnreadableChunk(t *T) {
+	keys, err := encryption.NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	assert.Nil(t, err)
+	options := DefaultOptions()
+	options.KeyProvider = keys
+	tree := newTestTree(t, options)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, tree.Set(fmt.Sprintf("key%03d", i), []byte("value")))
+	}
+	assert.Nil(t, tree.pushRoot())
+	assert.Nil(t, tree.mergeLayer(0))
+	name := tree.layers[1].chunks[0].name
+
+	// Corrupt the last entry, so the table is only readable up to it
+	dataFile := path.Join(tree.rootDir, name+".data")
+	data, err := os.ReadFile(dataFile)
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(dataFile, data, 0660))
+
+	assert.NotNil(t, tree.mergeLayer(1), "merge should fail on the unreadable entry")
+	assert.Len(t, tree.layers[1].chunks, 1, "source chunk should be kept")
+	assert.Equal(t, name, tree.layers[1].chunks[0].name)
+	assert.Len(t, tree.layers[2].chunks, 0)
+	_, err = os.Stat(dataFile)
+	assert.Nil(t, err, "source table should not be deleted")
+
+	it := tree.NewIterator("", "")
+	defer it.Close()
+	for it.Next() {
+	}
+	assert.NotNil(t, it.Err(), "iterators should fail on the unreadable entry")
+}
+
+func TestMemtableTypes(t *T) {
+	for _, memtableType := range []MemtableType{MemtableSkipList, MemtableBTree, MemtableHashLinkedList} {
+		dir := t.TempDir()
+		options := DefaultOptions()
+		options.MergeInterval = 0
+		options.MemtableType = memtableType
+		tree, err := NewLsmTree(dir, options)
+		assert.Nil(t, err)
+
+		setKeys(t, tree, "d", "b", "a", "e", "c")
+		assert.Nil(t, tree.Set("b", []byte("new")))
+		assert.Nil(t, tree.DeleteRange("c", "e"))
+		assertValue(t, tree, "b", "new")
+		assertMissing(t, tree, "d")
+		assert.Equal(t, []string{"a", "b", "e"}, scan(t, tree, "", ""), "type %v", memtableType)
+		assert.Equal(t, []string{"b"}, scan(t, tree, "b", "c"), "type %v", memtableType)
+
+		// The memtable is rebuilt from the WAL when the tree is opened
+		assert.Nil(t, tree.pushRoot())
+		setKeys(t, tree, "f")
+		assert.Nil(t, tree.Close())
+		tree, err = NewLsmTree(dir, options)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b", "e", "f"}, scan(t, tree, "", ""), "type %v", memtableType)
+
+		assert.Nil(t, tree.mergeLayer(0))
+		assertValue(t, tree, "b", "new")
+		assertMissing(t, tree, "c")
+		assert.Nil(t, tree.Close())
+	}
+}
+
+func TestMemtableSizeFollowsOverwrites(t *T) {
+	for _, memtableType := range []MemtableType{MemtableBTree, MemtableHashLinkedList} {
+		options := DefaultOptions()
+		options.MemtableType = memtableType
+		tree := newTestTree(t, options)
+
+		empty := tree.MemtableSize()
+		assert.Nil(t, tree.Set("key", make([]byte, 1000)))
+		large := tree.MemtableSize()
+		assert.GreaterOrEqual(t, large, empty+1000, "type %v", memtableType)
+
+		// Smaller values shrink the memtable instead of wrapping its size
+		assert.Nil(t, tree.Set("key", make([]byte, 10)))
+		assert.Equal(t, large-990, tree.MemtableSize(), "type %v", memtableType)
+	}
+}
+
+func TestWriteBufferManagerFlushesLargestMemtables(t *T) {
+	m := NewWriteBufferManager(2 * Megabyte)
+	defer m.Close()
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	options.WriteBufferManager = m
+	large, err := NewLsmTree(t.TempDir(), options)
+	assert.Nil(t, err)
+	small, err := NewLsmTree(t.TempDir(), options)
+	assert.Nil(t, err)
+
+	setKeys(t, small, "a", "b")
+	assert.Equal(t, int64(large.MemtableSize()+small.MemtableSize()), m.Usage())
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, large.Set(fmt.Sprintf("key%03d", i), make([]byte, 10*Kilobyte)))
+	}
+
+	assert.Eventually(t, func() bool { return m.Usage() <= m.Limit() }, 10*time.Second, 10*time.Millisecond)
+	assert.Greater(t, large.LayerSizes()[1], uint64(0), "Should flush the tree using the most memory")
+	assert.Equal(t, uint64(0), small.LayerSizes()[1], "Should keep the memtable of the other tree")
+	assertValue(t, small, "a", "value-a")
+	data, exists, err := large.Get("key042")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Len(t, data, 10*Kilobyte)
+
+	assert.Nil(t, large.Close())
+	assert.Nil(t, small.Close())
+	assert.Equal(t, int64(0), m.Usage(), "Should release the memory of closed trees")
+}
+
+func BenchmarkParallelSet(b *B) {
+	names := map[MemtableType]string{
+		MemtableSkipList:       "skiplist",
+		MemtableBTree:          "btree",
+		MemtableHashLinkedList: "hash",
+	}
+	for _, memtableType := range []MemtableType{MemtableSkipList, MemtableBTree, MemtableHashLinkedList} {
+		b.Run(names[memtableType], func(b *B) {
+			options := DefaultOptions()
+			options.MergeInterval = 0
+			options.MemtableType = memtableType
+			tree, err := NewLsmTree(b.TempDir(), options)
+			assert.Nil(b, err)
+			defer tree.Close()
+
+			value := make([]byte, 100)
+			worker := atomic.Int64{}
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *PB) {
+				w := worker.Add(1)
+				for i := 0; pb.Next(); i++ {
+					if err := tree.Set(fmt.Sprintf("%v-%v", w, i), value); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGet(b *B) {
+	options := DefaultOptions()
+	options.MergeInterval = 0
+	tree, err := NewLsmTree(b.TempDir(), options)
+	assert.Nil(b, err)
+	defer tree.Close()
+
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%05d", i)
+		assert.Nil(b, tree.Set(keys[i], make([]byte, 100)))
+	}
+	assert.Nil(b, tree.pushRoot())
+	assert.Nil(b, tree.mergeLayer(0))
+
+	b.Run("copied", func(b *B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tree.Get(keys[i%len(keys)])
+		}
+	})
+	b.Run("borrowed", func(b *B) {
+		b.ReportAllocs()
+		value := Value{}
+		for i := 0; i < b.N; i++ {
+			tree.GetBorrowed(keys[i%len(keys)], &value)
+			value.Release()
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package lsmtree
+
+import (
+	"hash/maphash"
+	"sync/atomic"
+
+	"github.com/lindend/distdb/internal/collections"
+	"github.com/lindend/distdb/internal/sstable"
+)
+
+// The data structure holding the entries of memtable chunks.
+type MemtableType int
+
+const (
+	// A lock-free skiplist, a good fit for most workloads.
+	MemtableSkipList MemtableType = iota
+	// A B-tree. Keys are packed together in its nodes, making scans faster,
+	// but writes block reads.
+	MemtableBTree
+	// A hash table of linked lists. Point lookups only search the keys with
+	// the same hash, but scans first sort every key written since the
+	// previous scan. Only use it with comparators that consider keys equal
+	// when their bytes are.
+	MemtableHashLinkedList
+)
+
+// Degree of the B-tree of MemtableBTree, giving nodes of up to 63 keys
+const memtableBTreeDegree = 32
+
+// Number of buckets of the hash table of MemtableHashLinkedList
+const memtableHashBuckets = 64 * 1024
+
+// Keeps the entries of a memtable chunk sorted by key. Reads and writes may
+// run concurrently, writes of the same key are serialized by the tree.
+type memtable interface {
+	get(key string) (*memtableEntry, bool)
+	// Adds or replaces the entry of a key, returns the replaced one
+	insert(key string, e memtableEntry) *memtableEntry
+	// Returns an iterator at the first entry, nil if there is none
+	iterator() memtableIterator
+	// Returns an iterator at the first key >= key, nil if there is none
+	seek(key string) memtableIterator
+	len() int
+	// Bytes of memory used by the memtable, its keys and its values
+	memoryUsage() int64
+}
+
+type memtableIterator interface {
+	// Returns an iterator at the next entry, nil if this is the last one
+	next() memtableIterator
+	value() (string, *memtableEntry)
+}
+
+func newMemtable(memtableType MemtableType, cmp sstable.Comparator) memtable {
+	switch memtableType {
+	case MemtableSkipList:
+		return &skipListMemtable{list: collections.NewConcurrentSkipList[memtableEntry](cmp.Compare)}
+	case MemtableBTree:
+		return &btreeMemtable{tree: collections.NewBTree[string, memtableEntry](memtableBTreeDegree, cmp.Compare)}
+	case MemtableHashLinkedList:
+		seed := maphash.MakeSeed()
+		hash := func(key string) uint64 { return maphash.String(seed, key) }
+		return &hashMemtable{list: collections.NewHashLinkedList[string, memtableEntry](memtableHashBuckets, hash, cmp.Compare)}
+	}
+	panic("Unknown MemtableType")
+}
+
+// Updates the bytes of the keys and values of a memtable after inserting e
+// for key, replacing old, nil if it didn't replace an entry.
+func addEntryDataSize(size *atomic.Int64, key string, e memtableEntry, old *memtableEntry) {
+	if old == nil {
+		size.Add(int64(len(key) + len(e.data)))
+	} else {
+		size.Add(int64(len(e.data) - len(old.data)))
+	}
+}
+
+type skipListMemtable struct {
+	list *collections.ConcurrentSkipList[memtableEntry]
+	// Bytes of the values of every entry inserted. Keys are copied into the
+	// arena of the list.
+	dataSize atomic.Int64
+}
+
+func (m *skipListMemtable) get(key string) (*memtableEntry, bool) {
+	return m.list.Get(key)
+}
+
+func (m *skipListMemtable) insert(key string, e memtableEntry) *memtableEntry {
+	// Replaced entries stay in the arena of the list, along with the values
+	// they refer to
+	m.dataSize.Add(int64(len(e.data)))
+	return m.list.Insert(key, e)
+}
+
+func (m *skipListMemtable) iterator() memtableIterator {
+	return newSkipListMemtableIterator(m.list.Iterate())
+}
+
+func (m *skipListMemtable) seek(key string) memtableIterator {
+	return newSkipListMemtableIterator(m.list.Seek(key))
+}
+
+func (m *skipListMemtable) len() int {
+	return m.list.Len()
+}
+
+func (m *skipListMemtable) memoryUsage() int64 {
+	return m.list.ArenaSize() + m.dataSize.Load()
+}
+
+type skipListMemtableIterator struct {
+	element *collections.ConcurrentSkipListElement[memtableEntry]
+}
+
+func newSkipListMemtableIterator(element *collections.ConcurrentSkipListElement[memtableEntry]) memtableIterator {
+	if element == nil {
+		return nil
+	}
+	return skipListMemtableIterator{element}
+}
+
+func (i skipListMemtableIterator) next() memtableIterator {
+	return newSkipListMemtableIterator(i.element.Next())
+}
+
+func (i skipListMemtableIterator) value() (string, *memtableEntry) {
+	return i.element.Value()
+}
+
+type btreeMemtable struct {
+	tree *collections.BTree[string, memtableEntry]
+	// Bytes of the keys and current values of the entries
+	dataSize atomic.Int64
+}
+
+func (m *btreeMemtable) get(key string) (*memtableEntry, bool) {
+	return m.tree.Get(key)
+}
+
+func (m *btreeMemtable) insert(key string, e memtableEntry) *memtableEntry {
+	old := m.tree.Insert(key, e)
+	addEntryDataSize(&m.dataSize, key, e, old)
+	return old
+}
+
+func (m *btreeMemtable) iterator() memtableIterator {
+	return newBTreeMemtableIterator(m.tree.Iterate())
+}
+
+func (m *btreeMemtable) seek(key string) memtableIterator {
+	return newBTreeMemtableIterator(m.tree.Seek(key))
+}
+
+func (m *btreeMemtable) len() int {
+	return m.tree.Len()
+}
+
+func (m *btreeMemtable) memoryUsage() int64 {
+	return m.tree.Size() + m.dataSize.Load()
+}
+
+type btreeMemtableIterator struct {
+	cursor *collections.BTreeCursor[string, memtableEntry]
+}
+
+func newBTreeMemtableIterator(cursor *collections.BTreeCursor[string, memtableEntry]) memtableIterator {
+	if cursor == nil {
+		return nil
+	}
+	return btreeMemtableIterator{cursor}
+}
+
+func (i btreeMemtableIterator) next() memtableIterator {
+	return newBTreeMemtableIterator(i.cursor.Next())
+}
+
+func (i btreeMemtableIterator) value() (string, *memtableEntry) {
+	return i.cursor.Value()
+}
+
+type hashMemtable struct {
+	list *collections.HashLinkedList[string, memtableEntry]
+	// Bytes of the keys and current values of the entries
+	dataSize atomic.Int64
+}
+
+func (m *hashMemtable) get(key string) (*memtableEntry, bool) {
+	return m.list.Get(key)
+}
+
+func (m *hashMemtable) insert(key string, e memtableEntry) *memtableEntry {
+	old := m.list.Insert(key, e)
+	addEntryDataSize(&m.dataSize, key, e, old)
+	return old
+}
+
+func (m *hashMemtable) iterator() memtableIterator {
+	return newHashMemtableIterator(m.list.Iterate())
+}
+
+func (m *hashMemtable) seek(key string) memtableIterator {
+	return newHashMemtableIterator(m.list.Seek(key))
+}
+
+func (m *hashMemtable) len() int {
+	return m.list.Len()
+}
+
+func (m *hashMemtable) memoryUsage() int64 {
+	return m.list.Size() + m.dataSize.Load()
+}
+
+type hashMemtableIterator struct {
+	cursor *collections.HashLinkedListCursor[string, memtableEntry]
+}
+
+func newHashMemtableIterator(cursor *collections.HashLinkedListCursor[string, memtableEntry]) memtableIterator {
+	if cursor == nil {
+		return nil
+	}
+	return hashMemtableIterator{cursor}
+}
+
+func (i hashMemtableIterator) next() memtableIterator {
+	return newHashMemtableIterator(i.cursor.Next())
+}
+
+func (i hashMemtableIterator) value() (string, *memtableEntry) {
+	return i.cursor.Value()
+}
@@ -0,0 +1,284 @@
+package lsmtree
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/lindend/distdb/internal/sstable"
+	"github.com/lindend/distdb/internal/wal"
+)
+
+type memtableEntry struct {
+	kind uint64
+	data []byte
+	// Unix time in nanoseconds when the entry expires, 0 if it never does
+	expiresAt int64
+	seq       uint64
+}
+
+type memtableChunkIterator struct {
+	it memtableIterator
+}
+
+func newMemtableChunkIterator(it memtableIterator) chunkIterator {
+	if it == nil {
+		return nil
+	}
+	return memtableChunkIterator{it}
+}
+
+func (i memtableChunkIterator) next() (chunkIterator, error) {
+	return newMemtableChunkIterator(i.it.next()), nil
+}
+
+func (i memtableChunkIterator) value() (kind uint64, key string, data []byte) {
+	k, d := i.it.value()
+	return d.kind, k, d.data
+}
+
+func (i memtableChunkIterator) expiresAt() int64 {
+	_, d := i.it.value()
+	return d.expiresAt
+}
+
+func (i memtableChunkIterator) seq() uint64 {
+	_, d := i.it.value()
+	return d.seq
+}
+
+// Combines a merge operand with the entry already stored for its key, nil if
+// there is none.
+type mergeMemtableEntryFunc func(key string, existing *memtableEntry, operand memtableEntry) (memtableEntry, error)
+
+// Records of different keys may be written concurrently. The tree serializes
+// writes of the same key, and range deletes with every other write, so merge
+// operands are combined with the entry they were written on top of.
+type memtableChunk struct {
+	entries memtable
+	// Log the records of the chunk are written to, shared by every memtable
+	// chunk of the tree
+	log *wal.Log
+	// LSN of the first record of the chunk. Records appended to the log
+	// after it belong to the chunk, until a newer chunk is created.
+	firstLSN uint64
+	// Lets the log delete the records of the chunk, called once the chunk
+	// is merged into an SSTable
+	releaseLog func()
+	// Bytes of the range tombstones of the chunk
+	tombstonesSize atomic.Int64
+	// Called with the change of the memory used by the chunk, nil if the
+	// memory isn't tracked
+	resized func(delta int64)
+	// Memory used by the chunk when resized was last called
+	reportedSize atomic.Int64
+	merge        mergeMemtableEntryFunc
+	cmp          sstable.Comparator
+	// Largest sequence number written to the chunk
+	lastSeq atomic.Uint64
+	// Range tombstones written to the chunk, in the order they were written
+	tombstones     []sstable.RangeTombstone
+	tombstonesLock sync.RWMutex
+}
+
+// Creates an empty memtable chunk, with the records from firstLSN onwards
+// kept in the log until the chunk is deleted. Changes of the memory used by
+// the chunk are passed to resized, unless it's nil.
+func newMemtableChunk(entries memtable, log *wal.Log, firstLSN uint64, merge mergeMemtableEntryFunc, cmp sstable.Comparator, resized func(delta int64)) *memtableChunk {
+	l := &memtableChunk{
+		entries:    entries,
+		log:        log,
+		firstLSN:   firstLSN,
+		releaseLog: log.Retain(firstLSN),
+		resized:    resized,
+		merge:      merge,
+		cmp:        cmp,
+	}
+	// Even an empty memtable allocates memory
+	l.reportSize()
+	return l
+}
+
+func (l *memtableChunk) get(key string) (entry, bool, error) {
+	v, exists := l.entries.get(key)
+	if !exists || v == nil {
+		return entry{}, false, nil
+	}
+	return entry{key: key, kind: v.kind, data: v.data, expiresAt: v.expiresAt, seq: v.seq}, true, nil
+}
+
+// Entries of memtables are never modified, so their data is returned as is.
+func (l *memtableChunk) getBorrowed(key string, value *sstable.Value) (entry, bool, error) {
+	return l.get(key)
+}
+
+// Writes a record to the WAL and then to the memtable. The WAL orders
+// concurrent appends, the memtable is written without locking.
+func (l *memtableChunk) set(e entry) error {
+	record := memtableEntry{e.kind, e.data, e.expiresAt, e.seq}
+	we := wal.WALEntry{Kind: e.kind, Key: e.key, Data: e.data, ExpiresAt: e.expiresAt, Seq: e.seq}
+	if e.kind == RecordKindRangeDelete {
+		if _, err := l.log.Append(we); err != nil {
+			return err
+		}
+		l.applyRangeDelete(sstable.RangeTombstone{Start: e.key, End: string(e.data)}, e.seq)
+		return nil
+	}
+
+	// Merge operands that can't be combined are rejected before they're
+	// written to the WAL
+	stored, err := l.newEntry(e.key, record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.log.Append(we); err != nil {
+		return err
+	}
+	l.insert(e.key, stored)
+	return nil
+}
+
+// Applies a record to the memtable without writing it to the WAL.
+func (l *memtableChunk) apply(key string, record memtableEntry) error {
+	if record.kind == RecordKindRangeDelete {
+		l.applyRangeDelete(sstable.RangeTombstone{Start: key, End: string(record.data)}, record.seq)
+		return nil
+	}
+
+	stored, err := l.newEntry(key, record)
+	if err != nil {
+		return err
+	}
+	l.insert(key, stored)
+	return nil
+}
+
+// Returns the entry to store for a record. Merge operands are combined with
+// the entry already stored for the key.
+func (l *memtableChunk) newEntry(key string, record memtableEntry) (memtableEntry, error) {
+	if record.kind != RecordKindMerge {
+		return record, nil
+	}
+	existing, _ := l.entries.get(key)
+	return l.merge(key, existing, record)
+}
+
+func (l *memtableChunk) updateMaxSeq(seq uint64) {
+	for {
+		last := l.lastSeq.Load()
+		if seq <= last || l.lastSeq.CompareAndSwap(last, seq) {
+			return
+		}
+	}
+}
+
+func (l *memtableChunk) insert(key string, entry memtableEntry) {
+	l.updateMaxSeq(entry.seq)
+	l.entries.insert(key, entry)
+	l.reportSize()
+}
+
+// Passes the change of the memory used by the chunk since the last call to
+// resized.
+func (l *memtableChunk) reportSize() {
+	if l.resized == nil {
+		return
+	}
+	size := int64(l.size())
+	l.resized(size - l.reportedSize.Swap(size))
+}
+
+// Entries already in the chunk are older than the tombstone, they are
+// replaced with deletes. That way the tombstone only has to be applied to
+// older chunks, and entries written after it take precedence over it.
+func (l *memtableChunk) applyRangeDelete(tombstone sstable.RangeTombstone, seq uint64) {
+	l.updateMaxSeq(seq)
+	for it := l.entries.seek(tombstone.Start); it != nil; it = it.next() {
+		key, _ := it.value()
+		if l.cmp.Compare(key, tombstone.End) >= 0 {
+			break
+		}
+		l.entries.insert(key, memtableEntry{kind: RecordKindDelete, seq: seq})
+	}
+
+	l.tombstonesLock.Lock()
+	l.tombstones = append(l.tombstones, tombstone)
+	l.tombstonesLock.Unlock()
+	l.tombstonesSize.Add(int64(len(tombstone.Start) + len(tombstone.End)))
+	l.reportSize()
+}
+
+func (l *memtableChunk) rangeTombstones() []sstable.RangeTombstone {
+	l.tombstonesLock.RLock()
+	defer l.tombstonesLock.RUnlock()
+	// Tombstones are only appended, so the returned slice is never modified
+	return l.tombstones[:len(l.tombstones):len(l.tombstones)]
+}
+
+func (l *memtableChunk) mayContainPrefix(prefix string) bool {
+	return true
+}
+
+func (l *memtableChunk) keyRange() (sstable.KeyRange, bool) {
+	keyRange := sstable.KeyRange{}
+	exists := false
+	extend := func(smallest string, largest string) {
+		if !exists || l.cmp.Compare(smallest, keyRange.Smallest) < 0 {
+			keyRange.Smallest = smallest
+		}
+		if !exists || l.cmp.Compare(largest, keyRange.Largest) > 0 {
+			keyRange.Largest = largest
+		}
+		exists = true
+	}
+
+	// Memtables have no link to their last element, scan to find it
+	for it := l.entries.iterator(); it != nil; it = it.next() {
+		key, _ := it.value()
+		extend(key, key)
+	}
+	for _, t := range l.rangeTombstones() {
+		extend(t.Start, t.End)
+	}
+	return keyRange, exists
+}
+
+func (l *memtableChunk) properties() (sstable.TableProperties, bool) {
+	return sstable.TableProperties{}, false
+}
+
+// Bytes of memory used by the entries and range tombstones of the chunk.
+func (l *memtableChunk) size() uint64 {
+	return uint64(l.entries.memoryUsage() + l.tombstonesSize.Load())
+}
+
+func (l *memtableChunk) iterator() (chunkIterator, error) {
+	return newMemtableChunkIterator(l.entries.iterator()), nil
+}
+
+func (l *memtableChunk) seek(key string) (chunkIterator, error) {
+	return newMemtableChunkIterator(l.entries.seek(key)), nil
+}
+
+func (l *memtableChunk) maxSeq() uint64 {
+	return l.lastSeq.Load()
+}
+
+func (l *memtableChunk) numEntries() int64 {
+	return int64(l.entries.len())
+}
+
+// The records of the chunk are in an SSTable once it's deleted, so they can
+// be removed from the log.
+func (l *memtableChunk) delete() error {
+	l.releaseLog()
+	l.releaseMemory()
+	return nil
+}
+
+// Stops tracking the memory of the chunk, which is freed once it's no longer
+// referenced.
+func (l *memtableChunk) releaseMemory() {
+	if l.resized != nil {
+		l.resized(-l.reportedSize.Swap(0))
+	}
+}
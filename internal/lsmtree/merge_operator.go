@@ -0,0 +1,223 @@
+package lsmtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/lindend/distdb/internal/sstable"
+)
+
+// Combines the values of keys written with Merge, letting read-modify-write
+// updates like counters be written without reading the key first. Operands
+// are only combined when the key is read or merged, so operators must be
+// associative: operands may be combined with each other, passing the older
+// one as existing, before the value they apply to is known. Must be safe to
+// call concurrently.
+type MergeOperator interface {
+	// Combines the existing value of a key with an operand. existing is nil
+	// if the key has no value.
+	Merge(key string, existing []byte, operand []byte) ([]byte, error)
+}
+
+type int64AddOperator struct{}
+
+// Returns an operator adding int64 operands, encoded by EncodeInt64, to the
+// value of a key. Keys without a value start at 0.
+func NewInt64AddOperator() MergeOperator {
+	return int64AddOperator{}
+}
+
+// Encodes a value or operand of the int64 add operator.
+func EncodeInt64(v int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(v))
+	return buf
+}
+
+// Decodes a value or operand of the int64 add operator.
+func DecodeInt64(data []byte) (int64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("int64 must be 8 bytes, got %v", len(data))
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
+
+func (int64AddOperator) Merge(key string, existing []byte, operand []byte) ([]byte, error) {
+	value := int64(0)
+	if existing != nil {
+		var err error
+		value, err = DecodeInt64(existing)
+		if err != nil {
+			return nil, err
+		}
+	}
+	delta, err := DecodeInt64(operand)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeInt64(value + delta), nil
+}
+
+type stringAppendOperator struct {
+	delimiter string
+}
+
+// Returns an operator appending operands to the value of a key, separated by
+// delimiter.
+func NewStringAppendOperator(delimiter string) MergeOperator {
+	return stringAppendOperator{delimiter}
+}
+
+func (o stringAppendOperator) Merge(key string, existing []byte, operand []byte) ([]byte, error) {
+	if existing == nil {
+		return operand, nil
+	}
+	value := make([]byte, 0, len(existing)+len(o.delimiter)+len(operand))
+	value = append(value, existing...)
+	value = append(value, o.delimiter...)
+	return append(value, operand...), nil
+}
+
+type maxOperator struct{}
+
+// Returns an operator keeping the largest of the value of a key and the
+// operands, compared bytewise.
+func NewMaxOperator() MergeOperator {
+	return maxOperator{}
+}
+
+func (maxOperator) Merge(key string, existing []byte, operand []byte) ([]byte, error) {
+	if existing != nil && bytes.Compare(existing, operand) >= 0 {
+		return existing, nil
+	}
+	return operand, nil
+}
+
+// Writes a merge operand for key, combined with the value of the key by the
+// merge operator of the tree.
+func (tree *LsmTree) Merge(key string, operand []byte) error {
+	if tree.options.MergeOperator == nil {
+		return errors.New("tree has no merge operator")
+	}
+
+	tree.writeLock.RLock()
+	defer tree.writeLock.RUnlock()
+	defer tree.lockKey(key)()
+
+	return tree.write(key, RecordKindMerge, operand, 0)
+}
+
+// Finds the merge operands of a key, newest first, and the newest record
+// below them, nil if there is none. Also returns the sequence number of the
+// newest record of the key, 0 if it has none.
+func (tree *LsmTree) getOperands(key string) (operands [][]byte, base *entry, seq uint64, err error) {
+	err = tree.walkRecords(key, func(e entry) bool {
+		if seq == 0 {
+			seq = e.seq
+		}
+		if e.kind == RecordKindMerge {
+			operands = append(operands, e.data)
+			return true
+		}
+		base = &e
+		return false
+	})
+	return operands, base, seq, err
+}
+
+// Combines merge operands, newest first, into a single operand.
+func (tree *LsmTree) combineOperands(key string, operands [][]byte) ([]byte, error) {
+	if tree.options.MergeOperator == nil {
+		return nil, errors.New("found merge operands in a tree without merge operator")
+	}
+
+	combined := operands[len(operands)-1]
+	for i := len(operands) - 2; i >= 0; i-- {
+		var err error
+		combined, err = tree.options.MergeOperator.Merge(key, combined, operands[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return combined, nil
+}
+
+// Applies merge operands, newest first, to the record they were written on
+// top of, nil if the key has no older record. Returns the value of the key
+// and when it expires, which is when the record expires.
+func (tree *LsmTree) resolveMerge(key string, operands [][]byte, base *entry, now int64) ([]byte, int64, error) {
+	combined, err := tree.combineOperands(key, operands)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var existing []byte
+	expiresAt := int64(0)
+	if base != nil && (base.kind == RecordKindWrite || base.kind == RecordKindValuePointer) && !base.expired(now) {
+		existing, err = tree.resolveValue(base.kind, base.data)
+		if err != nil {
+			return nil, 0, err
+		}
+		expiresAt = base.expiresAt
+	}
+
+	value, err := tree.options.MergeOperator.Merge(key, existing, combined)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, expiresAt, nil
+}
+
+// Combines a merge operand with the entry stored for the key in a memtable
+// chunk, nil if there is none. Without an older value in the chunk the
+// operand is stored as is, to be combined with older chunks when read.
+func (tree *LsmTree) mergeMemtableEntry(key string, existing *memtableEntry, operand memtableEntry) (memtableEntry, error) {
+	if existing == nil {
+		return operand, nil
+	}
+	if existing.kind == RecordKindMerge {
+		combined, err := tree.combineOperands(key, [][]byte{operand.data, existing.data})
+		return memtableEntry{kind: RecordKindMerge, data: combined, seq: operand.seq}, err
+	}
+
+	release := tree.acquireValueLog()
+	defer release()
+
+	base := entry{key: key, kind: existing.kind, data: existing.data, expiresAt: existing.expiresAt}
+	value, expiresAt, err := tree.resolveMerge(key, [][]byte{operand.data}, &base, tree.now().UnixNano())
+	return memtableEntry{kind: RecordKindWrite, data: value, expiresAt: expiresAt, seq: operand.seq}, err
+}
+
+// Merge operands of a key, newest first, collected while merging chunks
+// until the record they apply to is found.
+type pendingOperands struct {
+	key      string
+	operands [][]byte
+	// Sequence number of the newest operand
+	seq    uint64
+	source int
+}
+
+// Writes the value of a key with merge operands. base is the record below
+// the operands, nil if there is none in the merged chunks. Unless the merge
+// is bottommost, an older chunk may still have a value for the key, so the
+// operands are combined and written as a single operand.
+func (tree *LsmTree) writeMergedOperands(ctx CompactionContext, p *pendingOperands, base *entry, now int64, tbl *sstable.SSTableBuilder) error {
+	if base == nil && !ctx.Bottommost {
+		combined, err := tree.combineOperands(p.key, p.operands)
+		if err != nil {
+			return err
+		}
+		return tree.writeMergedEntry(entry{key: p.key, kind: RecordKindMerge, data: combined, seq: p.seq, source: p.source}, tbl)
+	}
+
+	e := entry{key: p.key, kind: RecordKindWrite, seq: p.seq, source: p.source}
+	var err error
+	e.data, e.expiresAt, err = tree.resolveMerge(p.key, p.operands, base, now)
+	if err != nil {
+		return err
+	}
+	return tree.mergeEntry(ctx, e, tbl)
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package lsmtree
+
+const raceEnabled = false
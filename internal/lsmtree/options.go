@@ -0,0 +1,137 @@
+package lsmtree
+
+import (
+	"time"
+
+	"github.com/lindend/distdb/internal/encryption"
+	"github.com/lindend/distdb/internal/sstable"
+)
+
+// Options used when creating or loading an LsmTree.
+type Options struct {
+	// Size in bytes of the block cache shared by all SSTables of the tree. If
+	// 0, no block cache is used.
+	BlockCacheSize int64
+	// Number of shards of the block cache, each with its own lock.
+	BlockCacheShards int
+	// Only admit blocks into the block cache that are read more often than
+	// the blocks they would evict, so scans don't evict the blocks read
+	// often.
+	BlockCacheTinyLFU bool
+	// Keep index and filter blocks in the block cache for as long as the table
+	// is part of the tree.
+	PinIndexAndFilterBlocks bool
+	// Values at least this many bytes large are moved to a value log when
+	// merged into an SSTable, leaving only a pointer to them in the table. If
+	// 0, values are always stored in the SSTables.
+	ValueLogThreshold int
+	// Value log files are rotated once they grow beyond this size.
+	ValueLogMaxFileSize int64
+	// WAL segments are rotated once they grow beyond this size. A segment is
+	// deleted once every memtable chunk with records in it is merged into an
+	// SSTable, so smaller segments are deleted sooner. If 0, segments are
+	// rotated at 64 MB.
+	WALMaxSegmentSize int64
+	// Data structure holding the entries written to the tree until they're
+	// merged into an SSTable.
+	MemtableType MemtableType
+	// Shares a limit of the memory used by memtables with other trees. The
+	// memtables of the trees using the most memory are merged into SSTables
+	// when the limit is exceeded. If nil, only the size of the memtable of
+	// the tree itself is limited.
+	WriteBufferManager *WriteBufferManager
+	// Compress the records of new WAL segments.
+	WALCompression bool
+	// Encrypts new WAL segments and SSTables with the current key of the
+	// provider. Trees with encrypted files can only be opened with a
+	// provider of their keys. After rotating the current key, merges
+	// rewrite the SSTables with the new one. The value log isn't encrypted.
+	// If nil, nothing is encrypted.
+	KeyProvider encryption.KeyProvider
+	// How often the background merge process checks for full layers. If 0,
+	// layers are never merged in the background.
+	MergeInterval time.Duration
+	// Extracts key prefixes to add to the filters of SSTables, letting prefix
+	// iterators skip tables without the prefix. If nil, only whole keys are
+	// added to the filters.
+	PrefixExtractor sstable.PrefixExtractor
+	// Only add key prefixes to the filters of SSTables, not whole keys.
+	PrefixFilterOnly bool
+	// Split the filter of each SSTable into one partition per index block.
+	PartitionedFilters bool
+	// Kind of filter built for new SSTables.
+	FilterType sstable.FilterType
+	// Target false positive rate of the filters of SSTables, per layer. Layers
+	// beyond the end of the list use the last rate. Deeper layers hold most of
+	// the data, so a lower rate there avoids most of the reads of tables
+	// without the key. If empty, every layer uses the default rate of the
+	// filter.
+	FilterFalsePositiveRates []float64
+	// Build a hash index for the index blocks of new SSTables, for faster
+	// point lookups.
+	HashIndex bool
+	// How the files of SSTables are read. Reading with pread or direct IO
+	// instead of mapping the files makes the memory used by the tree
+	// predictable, at the cost of system calls. Pread reads go through a
+	// small read-ahead buffer per file and the OS page cache, direct IO
+	// reads only through the block cache.
+	IOMode sstable.IOMode
+	// Creates the collectors of custom properties for each SSTable built.
+	PropertyCollectors []func() sstable.PropertyCollector
+	// Merge a layer before it's full if at least this fraction of the entries
+	// of its SSTables are deletes, to reclaim the space of deleted keys
+	// sooner. If 0, layers are only merged when full.
+	MergeDeletionRatio float64
+	// Called for every key written by merges, to drop or rewrite entries. If
+	// nil, merges keep the newest value of every key.
+	CompactionFilter CompactionFilter
+	// Combines the operands written by Merge with the value of their key. If
+	// nil, Merge can't be used.
+	MergeOperator MergeOperator
+	// Order of the keys, defaults to bytewise order. Stored with the tree,
+	// which can't be opened with a comparator of another name.
+	Comparator sstable.Comparator
+}
+
+func DefaultOptions() Options {
+	return Options{
+		BlockCacheSize:          64 * Megabyte,
+		BlockCacheShards:        16,
+		PinIndexAndFilterBlocks: false,
+		ValueLogThreshold:       0,
+		ValueLogMaxFileSize:     256 * Megabyte,
+		WALMaxSegmentSize:       16 * Megabyte,
+		MergeInterval:           2 * time.Second,
+	}
+}
+
+// Options used for every SSTable of the tree.
+func (tree *LsmTree) tableOptions() sstable.Options {
+	return sstable.Options{
+		BlockCache:              tree.blockCache,
+		PinIndexAndFilterBlocks: tree.options.PinIndexAndFilterBlocks,
+		PrefixExtractor:         tree.options.PrefixExtractor,
+		PrefixFilterOnly:        tree.options.PrefixFilterOnly,
+		PartitionedFilters:      tree.options.PartitionedFilters,
+		IOMode:                  tree.options.IOMode,
+		Comparator:              tree.cmp,
+		KeyProvider:             tree.options.KeyProvider,
+	}
+}
+
+// Options used to build a new SSTable in a layer.
+func (tree *LsmTree) tableBuildOptions(layer int) sstable.Options {
+	opts := tree.tableOptions()
+	opts.FilterType = tree.options.FilterType
+	opts.HashIndex = tree.options.HashIndex
+	opts.PropertyCollectors = tree.options.PropertyCollectors
+
+	rates := tree.options.FilterFalsePositiveRates
+	if layer >= len(rates) {
+		layer = len(rates) - 1
+	}
+	if layer >= 0 {
+		opts.FilterFalsePositiveRate = rates[layer]
+	}
+	return opts
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package lsmtree
+
+// The race detector makes sync.Pool drop buffers at random
+const raceEnabled = true
@@ -2,23 +2,50 @@ package lsmtree
 
 import (
 	"errors"
+	"io"
+	"unsafe"
 
 	"github.com/lindend/distdb/internal/sstable"
 )
 
 type sstableChunk struct {
 	tbl *sstable.SSTable
+	// Sequence number of every entry of a table ingested from outside the
+	// tree, assigned when it was ingested. 0 for tables written by the tree.
+	ingestedSeq uint64
 }
 
 type sstableChunkIterator struct {
-	it *sstable.SSTableIterator
+	it          *sstable.SSTableIterator
+	ingestedSeq uint64
 }
 
-func (s *sstableChunk) get(key string) (uint64, []byte, bool, error) {
-	return s.tbl.Read(key)
+func (s *sstableChunk) get(key string) (entry, bool, error) {
+	e, exists, err := s.tbl.ReadEntry(key)
+	if err != nil || !exists {
+		return entry{}, exists, err
+	}
+	return entry{key: key, kind: e.Kind, data: e.Data, expiresAt: e.ExpiresAt, seq: s.entrySeq(e.Seq)}, true, nil
+}
+
+func (s *sstableChunk) getBorrowed(key string, value *sstable.Value) (entry, bool, error) {
+	// The table only reads the key, so it can share the bytes of the string
+	exists, err := s.tbl.Get(unsafe.Slice(unsafe.StringData(key), len(key)), value)
+	if err != nil || !exists {
+		return entry{}, exists, err
+	}
+	return entry{key: key, kind: value.Kind, data: value.Data, expiresAt: value.ExpiresAt, seq: s.entrySeq(value.Seq)}, true, nil
+}
+
+// Returns the sequence number of an entry stored with seq.
+func (s *sstableChunk) entrySeq(seq uint64) uint64 {
+	if s.ingestedSeq != 0 {
+		return s.ingestedSeq
+	}
+	return seq
 }
 
-func (s *sstableChunk) set(key string, kind uint64, data []byte) error {
+func (s *sstableChunk) set(e entry) error {
 	return errors.New("write not supported for SSTable chunk")
 }
 
@@ -27,11 +54,54 @@ func (s *sstableChunk) size() uint64 {
 	return uint64(size)
 }
 
-func (s *sstableChunk) iterator() chunkIterator {
-	it, _ := s.tbl.Iterator()
+func (s *sstableChunk) iterator() (chunkIterator, error) {
+	it, err := s.tbl.Iterator()
+	return newSSTableChunkIterator(it, err, s.ingestedSeq)
+}
+
+func (s *sstableChunk) seek(key string) (chunkIterator, error) {
+	it, err := s.tbl.Seek(key)
+	return newSSTableChunkIterator(it, err, s.ingestedSeq)
+}
+
+// Wraps the result of reading an entry of a table. The end of the table is
+// returned as a nil iterator, any other error is returned as is.
+func newSSTableChunkIterator(it *sstable.SSTableIterator, err error, ingestedSeq uint64) (chunkIterator, error) {
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 	return &sstableChunkIterator{
-		it: it,
+		it:          it,
+		ingestedSeq: ingestedSeq,
+	}, nil
+}
+
+func (s *sstableChunk) rangeTombstones() []sstable.RangeTombstone {
+	return s.tbl.RangeTombstones()
+}
+
+func (s *sstableChunk) mayContainPrefix(prefix string) bool {
+	mayContain, err := s.tbl.MayContainPrefix(prefix)
+	// Fall back to scanning the chunk if the filter can't be read
+	return mayContain || err != nil
+}
+
+func (s *sstableChunk) keyRange() (sstable.KeyRange, bool) {
+	return s.tbl.KeyRange()
+}
+
+func (s *sstableChunk) properties() (sstable.TableProperties, bool) {
+	return s.tbl.Properties(), true
+}
+
+func (s *sstableChunk) maxSeq() uint64 {
+	if s.ingestedSeq > s.tbl.MaxSeq() {
+		return s.ingestedSeq
 	}
+	return s.tbl.MaxSeq()
 }
 
 func (s *sstableChunk) numEntries() int64 {
@@ -46,14 +116,18 @@ func (s *sstableChunkIterator) value() (uint64, string, []byte) {
 	return s.it.Value()
 }
 
-func (s *sstableChunkIterator) next() chunkIterator {
-	it, _ := s.it.Next()
+func (s *sstableChunkIterator) expiresAt() int64 {
+	return s.it.ExpiresAt()
+}
 
-	if it == nil {
-		return nil
+func (s *sstableChunkIterator) seq() uint64 {
+	if s.ingestedSeq != 0 {
+		return s.ingestedSeq
 	}
+	return s.it.Seq()
+}
 
-	return &sstableChunkIterator{
-		it: it,
-	}
+func (s *sstableChunkIterator) next() (chunkIterator, error) {
+	it, err := s.it.Next()
+	return newSSTableChunkIterator(it, err, s.ingestedSeq)
 }
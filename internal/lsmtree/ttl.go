@@ -0,0 +1,31 @@
+package lsmtree
+
+import (
+	"errors"
+	"time"
+)
+
+// Checks if a record expiring at expiresAt, in unix nanoseconds, has expired
+// at now. Records with no expiry never do.
+func expired(expiresAt int64, now int64) bool {
+	return expiresAt != 0 && expiresAt <= now
+}
+
+func (e entry) expired(now int64) bool {
+	return expired(e.expiresAt, now)
+}
+
+// Sets the value of a key that expires after ttl. Expired keys are hidden
+// from reads, but older values of the key stay hidden too, until the key is
+// removed by a merge.
+func (tree *LsmTree) SetWithTTL(key string, data []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("ttl must be positive")
+	}
+
+	tree.writeLock.RLock()
+	defer tree.writeLock.RUnlock()
+	defer tree.lockKey(key)()
+
+	return tree.write(key, RecordKindWrite, data, tree.now().Add(ttl).UnixNano())
+}
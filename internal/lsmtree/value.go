@@ -0,0 +1,88 @@
+package lsmtree
+
+import "github.com/lindend/distdb/internal/sstable"
+
+// A value read from the tree without copying it. Data may point into a
+// memtable, a mapped file, the block cache or a pooled buffer, and must not
+// be modified. It's only valid until Release is called.
+type Value struct {
+	Data []byte
+	// Version of the key, see GetWithVersion
+	Version uint64
+	// Value of the table the data was read from, if it was
+	table sstable.Value
+}
+
+// Releases the memory the value borrows. The value can be reused for another
+// read afterwards.
+func (v *Value) Release() {
+	v.table.Release()
+	v.Data = nil
+	v.Version = 0
+}
+
+// Looks up a key like GetWithVersion, without copying its value, so looking
+// up a key stored in a memtable or a table doesn't allocate. Every value read
+// must be released, or the files of the table it was read from are never
+// closed. Values combined from merge operands or stored in the value log
+// are read like GetWithVersion reads them.
+func (tree *LsmTree) GetBorrowed(key string, value *Value) (bool, error) {
+	value.Release()
+
+	e, exists, err := tree.newestRecord(key, &value.table)
+	if err != nil || !exists || e.kind == RecordKindDelete {
+		value.Release()
+		return false, err
+	}
+	if e.kind == RecordKindWrite {
+		if e.expired(tree.now().UnixNano()) {
+			value.Release()
+			return false, nil
+		}
+		value.Data = e.data
+		value.Version = e.seq
+		return true, nil
+	}
+
+	value.Release()
+	data, version, exists, err := tree.GetWithVersion(key)
+	if err != nil || !exists {
+		return false, err
+	}
+	value.Data = data
+	value.Version = version
+	return true, nil
+}
+
+// Returns the newest record of a key, like the first record visited by
+// walkRecords, with the data of records read from tables borrowed through
+// value.
+func (tree *LsmTree) newestRecord(key string, value *sstable.Value) (entry, bool, error) {
+	e, exists, err := getBorrowedFromChunk(tree.root(), key, value, tree.cmp)
+	if err != nil || exists {
+		return e, exists, err
+	}
+
+	// Searched layers stay locked until the lookup is done, so a merge can't
+	// move records into a layer that was already searched
+	for i := range tree.layers {
+		l := &tree.layers[i]
+		l.lock.RLock()
+		for _, c := range l.chunks {
+			e, exists, err = getBorrowedFromChunk(c, key, value, tree.cmp)
+			if err != nil || exists {
+				tree.unlockLayers(i + 1)
+				return e, exists, err
+			}
+		}
+	}
+	tree.unlockLayers(len(tree.layers))
+	return entry{}, false, nil
+}
+
+// Unlocks the first n layers, locked for reading.
+func (tree *LsmTree) unlockLayers(n int) {
+	for i := 0; i < n; i++ {
+		tree.layers[i].lock.RUnlock()
+	}
+}
@@ -0,0 +1,186 @@
+package lsmtree
+
+import (
+	"errors"
+	"os"
+	"path"
+
+	"github.com/lindend/distdb/internal/vlog"
+)
+
+const valueLogDir = "vlog"
+
+// Opens the value log of the tree if it's enabled, or if the tree has
+// values in an existing value log.
+func (tree *LsmTree) openValueLog() error {
+	dir := path.Join(tree.rootDir, valueLogDir)
+	if tree.options.ValueLogThreshold <= 0 {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return nil
+		}
+	}
+
+	v, err := vlog.Open(dir, tree.options.ValueLogMaxFileSize)
+	if err != nil {
+		return err
+	}
+	tree.vlog = v
+	return nil
+}
+
+func (tree *LsmTree) shouldSeparateValue(e entry) bool {
+	return tree.vlog != nil &&
+		tree.options.ValueLogThreshold > 0 &&
+		e.kind == RecordKindWrite &&
+		len(e.data) >= tree.options.ValueLogThreshold
+}
+
+// Returns the value of a record, reading it from the value log if the
+// record is a value pointer.
+func (tree *LsmTree) resolveValue(kind uint64, data []byte) ([]byte, error) {
+	if kind != RecordKindValuePointer {
+		return data, nil
+	}
+	if tree.vlog == nil {
+		return nil, errors.New("found value pointer in a tree without value log")
+	}
+
+	ptr, err := vlog.DecodeValuePointer(data)
+	if err != nil {
+		return nil, err
+	}
+	return tree.vlog.Read(ptr)
+}
+
+// Keeps value log files from being deleted until the returned function is
+// called. Must be held while reading value pointers from the tree.
+func (tree *LsmTree) acquireValueLog() func() {
+	if tree.vlog == nil {
+		return func() {}
+	}
+	return tree.vlog.Acquire()
+}
+
+// Live value of a key in the value log
+type livePointer struct {
+	// Record pointing to the value
+	record *entry
+	// Merge operands written on top of the record, newest first
+	operands [][]byte
+	// Sequence number of the newest record of the key
+	seq uint64
+}
+
+// Checks if the newest record of key, below any merge operands, is a
+// pointer to ptr that hasn't expired.
+func (tree *LsmTree) pointsTo(key string, ptr vlog.ValuePointer) (livePointer, bool, error) {
+	operands, base, seq, err := tree.getOperands(key)
+	if err != nil || base == nil || base.kind != RecordKindValuePointer || base.expired(tree.now().UnixNano()) {
+		return livePointer{}, false, err
+	}
+
+	current, err := vlog.DecodeValuePointer(base.data)
+	if err != nil {
+		return livePointer{}, false, err
+	}
+	return livePointer{record: base, operands: operands, seq: seq}, current == ptr, nil
+}
+
+// Garbage collects the oldest file of the value log. Values that are still
+// used by the tree are copied to the head of the log and the tree is updated
+// to point to the new copies, then the file is removed. If less than
+// discardRatio of the values in the file are garbage, the file is kept.
+// Returns true if a file was collected.
+func (tree *LsmTree) CollectValueLog(discardRatio float64) (bool, error) {
+	if tree.vlog == nil {
+		return false, nil
+	}
+
+	tree.valueLogGC.Lock()
+	defer tree.valueLogGC.Unlock()
+
+	file, exists, err := tree.vlog.OldestFile()
+	if err != nil || !exists {
+		return false, err
+	}
+
+	type liveValue struct {
+		key string
+		ptr vlog.ValuePointer
+	}
+
+	live := []liveValue{}
+	totalBytes := int64(0)
+	liveBytes := int64(0)
+
+	release := tree.vlog.Acquire()
+	err = tree.vlog.Iterate(file, func(key []byte, ptr vlog.ValuePointer) error {
+		totalBytes += int64(ptr.Length)
+		_, isLive, err := tree.pointsTo(string(key), ptr)
+		if err != nil {
+			return err
+		}
+		if isLive {
+			live = append(live, liveValue{string(key), ptr})
+			liveBytes += int64(ptr.Length)
+		}
+		return nil
+	})
+	release()
+	if err != nil {
+		return false, err
+	}
+
+	if totalBytes > 0 && float64(totalBytes-liveBytes)/float64(totalBytes) < discardRatio {
+		return false, nil
+	}
+
+	moved := make([]vlog.ValuePointer, len(live))
+	for i, v := range live {
+		data, err := tree.vlog.Read(v.ptr)
+		if err != nil {
+			return false, err
+		}
+		moved[i], err = tree.vlog.Append([]byte(v.key), data)
+		if err != nil {
+			return false, err
+		}
+	}
+	if err := tree.vlog.Sync(); err != nil {
+		return false, err
+	}
+
+	// Pause writes while updating the pointers, so values written while
+	// copying aren't overwritten with the old ones. Keys with merge operands
+	// on top of the pointer are written with the operands applied, since the
+	// new pointer replaces them. The version of the keys is kept, their value
+	// doesn't change.
+	tree.writeLock.Lock()
+	for i, v := range live {
+		p, stillLive, err := tree.pointsTo(v.key, v.ptr)
+		if err == nil && stillLive {
+			e := *p.record
+			e.data = moved[i].Encode()
+			e.seq = p.seq
+			if len(p.operands) > 0 {
+				e.kind = RecordKindWrite
+				e.data, e.expiresAt, err = tree.resolveMerge(v.key, p.operands, p.record, tree.now().UnixNano())
+			}
+			if err == nil {
+				err = tree.writeEntry(e)
+			}
+		}
+		if err != nil {
+			tree.writeLock.Unlock()
+			return false, err
+		}
+	}
+	tree.writeLock.Unlock()
+
+	// A crash after removing the file must not replay the old pointers from
+	// the WAL
+	if err := tree.log.Sync(); err != nil {
+		return false, err
+	}
+	return true, tree.vlog.RemoveFile(file)
+}
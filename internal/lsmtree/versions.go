@@ -0,0 +1,129 @@
+package lsmtree
+
+import (
+	"bytes"
+	"hash/maphash"
+)
+
+// Number of locks the keys of a tree are spread over. Writes of keys sharing
+// a lock wait for each other.
+const keyLockStripes = 64
+
+var keyLockSeed = maphash.MakeSeed()
+
+// Locks a key for writing. Returns a function unlocking it.
+func (tree *LsmTree) lockKey(key string) func() {
+	lock := &tree.keyLocks[maphash.String(keyLockSeed, key)%keyLockStripes]
+	lock.Lock()
+	return lock.Unlock
+}
+
+// Locks every key for writing, used by writes of key ranges. Returns a
+// function unlocking them.
+func (tree *LsmTree) lockAllKeys() func() {
+	for i := range tree.keyLocks {
+		tree.keyLocks[i].Lock()
+	}
+	return func() {
+		for i := range tree.keyLocks {
+			tree.keyLocks[i].Unlock()
+		}
+	}
+}
+
+// Continues the sequence numbers of a loaded tree after the largest one in
+// any of its chunks.
+func (tree *LsmTree) initSeq() {
+	seq := tree.rootChunk.data.maxSeq()
+	for i := range tree.layers {
+		for _, c := range tree.layers[i].chunks {
+			if s := c.data.maxSeq(); s > seq {
+				seq = s
+			}
+		}
+	}
+	tree.seq.Store(seq)
+}
+
+// Returns the value of a key along with its version. Every write is assigned
+// the next sequence number of the tree, and the version of a key is the
+// sequence number of its last write. Keys written before versions were
+// stored have version 0.
+func (tree *LsmTree) GetWithVersion(key string) ([]byte, uint64, bool, error) {
+	release := tree.acquireValueLog()
+	defer release()
+
+	operands, base, seq, err := tree.getOperands(key)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	now := tree.now().UnixNano()
+	if len(operands) > 0 {
+		data, _, err := tree.resolveMerge(key, operands, base, now)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		return data, seq, true, nil
+	}
+
+	if base == nil || base.kind == RecordKindDelete || base.expired(now) {
+		return nil, 0, false, nil
+	}
+
+	data, err := tree.resolveValue(base.kind, base.data)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return data, seq, true, nil
+}
+
+// Writes a record if check accepts the current value of the key. No other
+// write of the key can happen between the check and the write. Returns false
+// if the check failed.
+func (tree *LsmTree) writeIf(key string, kind uint64, data []byte, check func(value []byte, version uint64, exists bool) bool) (bool, error) {
+	tree.writeLock.RLock()
+	defer tree.writeLock.RUnlock()
+	defer tree.lockKey(key)()
+
+	value, version, exists, err := tree.GetWithVersion(key)
+	if err != nil || !check(value, version, exists) {
+		return false, err
+	}
+	return true, tree.write(key, kind, data, 0)
+}
+
+// Sets the value of a key if it doesn't have one.
+func (tree *LsmTree) SetIfAbsent(key string, data []byte) (bool, error) {
+	return tree.writeIf(key, RecordKindWrite, data, func(_ []byte, _ uint64, exists bool) bool {
+		return !exists
+	})
+}
+
+// Sets the value of a key if it exists and its version is version.
+func (tree *LsmTree) SetIfVersion(key string, version uint64, data []byte) (bool, error) {
+	return tree.writeIf(key, RecordKindWrite, data, func(_ []byte, current uint64, exists bool) bool {
+		return exists && current == version
+	})
+}
+
+// Sets the value of a key if its current value is expected.
+func (tree *LsmTree) SetIfEquals(key string, expected []byte, data []byte) (bool, error) {
+	return tree.writeIf(key, RecordKindWrite, data, func(value []byte, _ uint64, exists bool) bool {
+		return exists && bytes.Equal(value, expected)
+	})
+}
+
+// Deletes a key if it exists and its version is version.
+func (tree *LsmTree) DeleteIfVersion(key string, version uint64) (bool, error) {
+	return tree.writeIf(key, RecordKindDelete, nil, func(_ []byte, current uint64, exists bool) bool {
+		return exists && current == version
+	})
+}
+
+// Deletes a key if its current value is expected.
+func (tree *LsmTree) DeleteIfEquals(key string, expected []byte) (bool, error) {
+	return tree.writeIf(key, RecordKindDelete, nil, func(value []byte, _ uint64, exists bool) bool {
+		return exists && bytes.Equal(value, expected)
+	})
+}
@@ -0,0 +1,90 @@
+package lsmtree
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/lindend/distdb/internal/wal"
+
+	"github.com/rs/zerolog/log"
+)
+
+const walDir = "wal"
+
+func (tree *LsmTree) openWAL() error {
+	l, err := wal.OpenLog(path.Join(tree.rootDir, walDir), wal.LogOptions{
+		MaxSegmentSize: tree.options.WALMaxSegmentSize,
+		WALOptions: wal.WALOptions{
+			Compress:    tree.options.WALCompression,
+			KeyProvider: tree.options.KeyProvider,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	tree.log = l
+	return nil
+}
+
+// Creates an empty memtable chunk. Records appended to the WAL from firstLSN
+// onwards belong to it.
+func (tree *LsmTree) newMemtableChunk(firstLSN uint64) *memtableChunk {
+	var resized func(delta int64)
+	if tree.options.WriteBufferManager != nil {
+		resized = tree.options.WriteBufferManager.resize
+	}
+	return newMemtableChunk(newMemtable(tree.options.MemtableType, tree.cmp), tree.log, firstLSN, tree.mergeMemtableEntry, tree.cmp, resized)
+}
+
+// Rebuilds memtable chunks from the records in the WAL. A record belongs to
+// the newest chunk created before it was appended.
+func (tree *LsmTree) replayWAL(chunks []*memtableChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].firstLSN < chunks[j].firstLSN })
+
+	stats, err := tree.log.Replay(chunks[0].firstLSN, func(e wal.WALEntry) error {
+		i := sort.Search(len(chunks), func(i int) bool { return chunks[i].firstLSN > e.LSN }) - 1
+		return chunks[i].apply(e.Key, memtableEntry{e.Kind, e.Data, e.ExpiresAt, e.Seq})
+	})
+	if err != nil {
+		return err
+	}
+	logReplayStats(stats, "Replayed WAL")
+	return nil
+}
+
+func logReplayStats(stats wal.ReplayStats, msg string) {
+	event := log.Info()
+	if stats.TruncatedTail {
+		event = log.Warn().Int64("truncatedBytes", stats.TruncatedBytes)
+	}
+	event.
+		Int("records", stats.Records).
+		Int64("bytes", stats.Bytes).
+		Bool("truncatedTail", stats.TruncatedTail).
+		Msg(msg)
+}
+
+// WAL file of a memtable chunk written before the WAL was segmented, when
+// every chunk had a file of its own.
+func (tree *LsmTree) legacyWALFileName(chunkName string) string {
+	return path.Join(tree.rootDir, fmt.Sprintf("wal-%v.log", chunkName))
+}
+
+// Loads a memtable chunk from its own WAL file, moving its records into the
+// log. The file can be deleted once the tree is saved.
+func (tree *LsmTree) migrateLegacyWAL(chunkName string) (*memtableChunk, error) {
+	sl := tree.newMemtableChunk(tree.log.NextLSN())
+	stats, err := wal.ReplayWAL(tree.legacyWALFileName(chunkName), nil, func(e wal.WALEntry) error {
+		return sl.set(entry{key: e.Key, kind: e.Kind, data: e.Data, expiresAt: e.ExpiresAt, seq: e.Seq})
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	logReplayStats(stats, "Migrated WAL of "+chunkName)
+	return sl, nil
+}
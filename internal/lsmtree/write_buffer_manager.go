@@ -0,0 +1,124 @@
+package lsmtree
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Caps the memory used by the memtable chunks of a set of trees. Once they
+// use more than the limit, the memtables of the trees using the most memory
+// are merged into SSTables, until the memory used is within the limit again.
+// Merges run in the background, writes aren't blocked while they do.
+type WriteBufferManager struct {
+	limit int64
+	// Bytes of memory used by the memtable chunks of the trees
+	usage atomic.Int64
+	// Held while flushing, and while adding or removing trees
+	lock  sync.Mutex
+	trees map[*LsmTree]struct{}
+	// Signals the flush process that the limit was exceeded
+	flush chan struct{}
+	exit  chan struct{}
+	done  chan struct{}
+}
+
+// Creates a WriteBufferManager limiting memtables to limit bytes of memory.
+func NewWriteBufferManager(limit int64) *WriteBufferManager {
+	m := &WriteBufferManager{
+		limit: limit,
+		trees: map[*LsmTree]struct{}{},
+		flush: make(chan struct{}, 1),
+		exit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go m.flushProcess()
+	return m
+}
+
+// Bytes of memory used by the memtable chunks of the trees.
+func (m *WriteBufferManager) Usage() int64 {
+	return m.usage.Load()
+}
+
+func (m *WriteBufferManager) Limit() int64 {
+	return m.limit
+}
+
+// Stops flushing memtables. The trees using the manager must be closed
+// first.
+func (m *WriteBufferManager) Close() {
+	close(m.exit)
+	<-m.done
+}
+
+func (m *WriteBufferManager) register(tree *LsmTree) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.trees[tree] = struct{}{}
+}
+
+// Removes a tree, so it isn't flushed anymore. Flushes already running keep
+// the lock, the tree is removed once they are done.
+func (m *WriteBufferManager) unregister(tree *LsmTree) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.trees, tree)
+}
+
+// Called with the change of the memory used by a memtable chunk.
+func (m *WriteBufferManager) resize(delta int64) {
+	if m.usage.Add(delta) > m.limit {
+		select {
+		case m.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (m *WriteBufferManager) flushProcess() {
+	defer close(m.done)
+	for {
+		select {
+		case <-m.exit:
+			return
+		case <-m.flush:
+			m.flushLargest()
+		}
+	}
+}
+
+// Flushes the memtables of the trees using the most memory, until the
+// memory used is within the limit.
+func (m *WriteBufferManager) flushLargest() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	type treeUsage struct {
+		tree  *LsmTree
+		usage uint64
+	}
+	trees := make([]treeUsage, 0, len(m.trees))
+	for tree := range m.trees {
+		trees = append(trees, treeUsage{tree, tree.MemtableSize()})
+	}
+	sort.Slice(trees, func(i, j int) bool { return trees[i].usage > trees[j].usage })
+
+	// Every tree is flushed at most once. Memtables read by iterators stay
+	// in memory until the iterators are closed, flushing their tree again
+	// wouldn't help.
+	for _, t := range trees {
+		if m.usage.Load() <= m.limit {
+			return
+		}
+		log.Debug().
+			Str("tree", t.tree.rootDir).
+			Uint64("usage", t.usage).
+			Msg("Write buffer full, flushing memtables")
+		if err := t.tree.flushMemtables(); err != nil {
+			log.Error().Err(err).Str("tree", t.tree.rootDir).Msg("Failed to flush memtables")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package sstable
+
+import (
+	"sync/atomic"
+
+	"github.com/lindend/distdb/internal/collections"
+)
+
+const defaultBlockCacheShards = 16
+
+type blockKind byte
+
+const (
+	blockKindIndex  blockKind = 1
+	blockKindData   blockKind = 2
+	blockKindFilter blockKind = 3
+)
+
+// Identifies a block of a single loaded SSTable.
+type blockCacheKey struct {
+	tableId uint64
+	kind    blockKind
+	offset  int64
+}
+
+type BlockCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Blocks kept out of a TinyLFU cache
+	Rejections  uint64
+	Usage       int64
+	PinnedUsage int64
+	Capacity    int64
+}
+
+// A size bounded LRU cache for SSTable blocks, meant to be shared between
+// all the tables of an LsmTree. The cache is split into shards with one
+// lock each to reduce contention between concurrent readers.
+type BlockCache struct {
+	cache *collections.Cache[blockCacheKey, any]
+}
+
+var nextTableCacheId atomic.Uint64
+
+// Creates a new block cache holding at most capacity bytes, split over
+// numShards shards. If numShards is 0 a default is used.
+func NewBlockCache(capacity int64, numShards int) *BlockCache {
+	return &BlockCache{cache: collections.NewLRUCache(blockCacheOptions(capacity, numShards))}
+}
+
+// Creates a new block cache like NewBlockCache, that only admits blocks
+// read more often than the blocks they would evict. Scans reading many
+// blocks once don't evict the blocks read often.
+func NewTinyLFUBlockCache(capacity int64, numShards int) *BlockCache {
+	return &BlockCache{cache: collections.NewTinyLFUCache(blockCacheOptions(capacity, numShards))}
+}
+
+// Size of the blocks the frequency sketch of TinyLFU block caches is sized
+// for
+const expectedBlockSize = 4096
+
+func blockCacheOptions(capacity int64, numShards int) collections.CacheOptions[blockCacheKey, any] {
+	if numShards <= 0 {
+		numShards = defaultBlockCacheShards
+	}
+	return collections.CacheOptions[blockCacheKey, any]{
+		Capacity:        capacity,
+		Shards:          numShards,
+		Hash:            hashBlockCacheKey,
+		ExpectedEntries: int(capacity / expectedBlockSize),
+	}
+}
+
+func hashBlockCacheKey(key blockCacheKey) uint64 {
+	return key.tableId*0x9E3779B97F4A7C15 ^ uint64(key.offset)*0xC2B2AE3D27D4EB4F ^ uint64(key.kind)
+}
+
+func (c *BlockCache) get(key blockCacheKey) (any, bool) {
+	return c.cache.Get(key)
+}
+
+// Inserts a block into the cache, evicting the least recently used blocks
+// of the shard if it is over capacity. Pinned blocks are never evicted, they
+// live until the table is removed from the cache.
+func (c *BlockCache) insert(key blockCacheKey, value any, charge int64, pinned bool) {
+	if pinned {
+		c.cache.InsertPinned(key, value, charge)
+	} else {
+		c.cache.Insert(key, value, charge)
+	}
+}
+
+// Drops all blocks, including pinned ones, belonging to a table.
+func (c *BlockCache) eraseTable(tableId uint64) {
+	c.cache.RemoveIf(func(key blockCacheKey) bool { return key.tableId == tableId })
+}
+
+func (c *BlockCache) Stats() BlockCacheStats {
+	stats := c.cache.Stats()
+	return BlockCacheStats{
+		Hits:        stats.Hits,
+		Misses:      stats.Misses,
+		Rejections:  stats.Rejections,
+		Usage:       stats.Usage,
+		PinnedUsage: stats.PinnedUsage,
+		Capacity:    stats.Capacity,
+	}
+}
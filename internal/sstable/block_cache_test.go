@@ -0,0 +1,77 @@
+package sstable
+
+import (
+	"fmt"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockCacheEvictsLeastRecentlyUsed(t *T) {
+	c := NewBlockCache(30, 1)
+	c.insert(blockCacheKey{tableId: 1, offset: 0}, "a", 10, false)
+	c.insert(blockCacheKey{tableId: 1, offset: 1}, "b", 10, false)
+	c.insert(blockCacheKey{tableId: 1, offset: 2}, "c", 10, false)
+
+	// Touch a so b becomes the least recently used
+	_, exists := c.get(blockCacheKey{tableId: 1, offset: 0})
+	assert.True(t, exists)
+
+	c.insert(blockCacheKey{tableId: 1, offset: 3}, "d", 10, false)
+
+	_, exists = c.get(blockCacheKey{tableId: 1, offset: 1})
+	assert.False(t, exists, "b should have been evicted")
+	v, exists := c.get(blockCacheKey{tableId: 1, offset: 0})
+	assert.True(t, exists, "a should still be cached")
+	assert.Equal(t, "a", v)
+
+	stats := c.Stats()
+	assert.Equal(t, int64(30), stats.Usage)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+}
+
+func TestBlockCacheKeepsPinnedEntries(t *T) {
+	c := NewBlockCache(20, 1)
+	c.insert(blockCacheKey{tableId: 1, kind: blockKindFilter}, "filter", 15, true)
+	c.insert(blockCacheKey{tableId: 1, offset: 1}, "b", 10, false)
+	c.insert(blockCacheKey{tableId: 1, offset: 2}, "c", 10, false)
+
+	_, exists := c.get(blockCacheKey{tableId: 1, kind: blockKindFilter})
+	assert.True(t, exists, "pinned entry should never be evicted")
+	assert.Equal(t, int64(15), c.Stats().PinnedUsage)
+
+	c.eraseTable(1)
+	stats := c.Stats()
+	assert.Equal(t, int64(0), stats.Usage)
+	assert.Equal(t, int64(0), stats.PinnedUsage)
+}
+
+func TestReadUsesBlockCache(t *T) {
+	cache := NewBlockCache(1024*1024, 4)
+	builder, err := NewSSTable(100, t.TempDir(), "cached", Options{
+		BlockCache:              cache,
+		PinIndexAndFilterBlocks: true,
+	})
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		builder.Write(fmt.Sprintf("key%03d", i), 0, []byte(fmt.Sprintf("value%d", i)))
+	}
+	tbl, err := builder.Build()
+	assert.Nil(t, err)
+	defer tbl.Close()
+
+	_, data, exists, err := tbl.Read("key042")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []byte("value42"), data)
+	missesAfterFirstRead := cache.Stats().Misses
+
+	_, data, exists, err = tbl.Read("key042")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []byte("value42"), data)
+	assert.Equal(t, missesAfterFirstRead, cache.Stats().Misses, "second read should only hit the cache")
+	assert.True(t, cache.Stats().PinnedUsage > 0, "index and filter should be pinned")
+}
@@ -0,0 +1,124 @@
+package sstable
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Orders the keys of tables. Keys are arbitrary bytes, held in strings so
+// they can be compared and stored without copying. Keys that compare as
+// equal must have the same bytes.
+type Comparator interface {
+	// Returns a negative number if a is before b, 0 if they're equal and a
+	// positive number if a is after b.
+	Compare(a string, b string) int
+	// Identifies the order of the keys. It's stored with every table, and a
+	// table can only be loaded with a comparator of the same name.
+	Name() string
+}
+
+// Optionally implemented by comparators that keep the keys starting with a
+// prefix next to each other, letting prefix scans seek to them instead of
+// starting from the first key.
+type PrefixSeeker interface {
+	// Returns the key to seek to for the keys starting with prefix. Keys
+	// without the prefix may come before the first key with it, but not
+	// after the last one. Returns false if the scan must start from the
+	// first key.
+	PrefixSeekKey(prefix string) (string, bool)
+}
+
+type bytewiseComparator struct{}
+
+// Orders keys by their bytes, which also orders big-endian encoded unsigned
+// integers by value. The default comparator.
+var BytewiseComparator Comparator = bytewiseComparator{}
+
+func (bytewiseComparator) Compare(a string, b string) int {
+	return strings.Compare(a, b)
+}
+
+func (bytewiseComparator) Name() string {
+	return "bytewise"
+}
+
+func (bytewiseComparator) PrefixSeekKey(prefix string) (string, bool) {
+	return prefix, true
+}
+
+type reverseBytewiseComparator struct{}
+
+// Orders keys by their bytes, in descending order.
+var ReverseBytewiseComparator Comparator = reverseBytewiseComparator{}
+
+func (reverseBytewiseComparator) Compare(a string, b string) int {
+	return strings.Compare(b, a)
+}
+
+func (reverseBytewiseComparator) Name() string {
+	return "reverse-bytewise"
+}
+
+// The largest key starting with prefix comes first, and the only key
+// without the prefix before it is the end of the prefix itself.
+func (reverseBytewiseComparator) PrefixSeekKey(prefix string) (string, bool) {
+	end := PrefixEnd(prefix)
+	return end, end != ""
+}
+
+// Returns the first key, in bytewise order, after every key starting with
+// prefix, or an empty string if there is no such key.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i] += 1
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// Returns the comparator of the options, the bytewise comparator if none is
+// set.
+func (o Options) comparator() Comparator {
+	if o.Comparator == nil {
+		return BytewiseComparator
+	}
+	return o.Comparator
+}
+
+// Views a key read as bytes as a string without copying it. The bytes must
+// not be modified while the string is used.
+func keyString(key []byte) string {
+	return unsafe.String(unsafe.SliceData(key), len(key))
+}
+
+// A key stored in a JSON file. JSON strings can only hold valid UTF-8, so
+// other keys are stored base64 encoded, as {"b": "..."}.
+type jsonKey string
+
+type binaryJSONKey struct {
+	B []byte `json:"b"`
+}
+
+func (k jsonKey) MarshalJSON() ([]byte, error) {
+	if utf8.ValidString(string(k)) {
+		return json.Marshal(string(k))
+	}
+	return json.Marshal(binaryJSONKey{[]byte(k)})
+}
+
+func (k *jsonKey) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		binary := binaryJSONKey{}
+		if err := json.Unmarshal(data, &binary); err != nil {
+			return err
+		}
+		*k = jsonKey(binary.B)
+		return nil
+	}
+	return json.Unmarshal(data, (*string)(k))
+}
@@ -0,0 +1,93 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixEnd(t *T) {
+	assert.Equal(t, "b", PrefixEnd("a"))
+	assert.Equal(t, "user;", PrefixEnd("user:"))
+	assert.Equal(t, "b", PrefixEnd("a\xff"))
+	assert.Equal(t, "", PrefixEnd("\xff\xff"))
+}
+
+func TestReverseComparator(t *T) {
+	root := t.TempDir()
+	keys := []string{}
+	for i := 999; i >= 0; i-- {
+		keys = append(keys, fmt.Sprintf("key:%03d", i))
+	}
+	opts := Options{
+		Comparator:         ReverseBytewiseComparator,
+		PrefixExtractor:    NewFixedPrefixExtractor(6),
+		PartitionedFilters: true,
+	}
+
+	builder, err := NewSSTable(10, root, "ascending", opts)
+	assert.Nil(t, err)
+	assert.Nil(t, builder.Write("a", 0, nil))
+	assert.NotNil(t, builder.Write("b", 0, nil), "keys must be written in the order of the comparator")
+
+	tbl := buildTable(t, root, "reverse", keys, opts)
+	keyRange, _ := tbl.KeyRange()
+	assert.Equal(t, KeyRange{Smallest: "key:999", Largest: "key:000"}, keyRange)
+
+	_, data, exists, err := tbl.Read("key:500")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "value-key:500", string(data))
+
+	it, err := tbl.Seek("key:5001")
+	assert.Nil(t, err)
+	_, key, _ := it.Value()
+	assert.Equal(t, "key:500", key)
+
+	mayContain, err := tbl.MayContainPrefix("key:50")
+	assert.Nil(t, err)
+	assert.True(t, mayContain)
+	mayContain, err = tbl.MayContainPrefix("key:ab")
+	assert.Nil(t, err)
+	assert.False(t, mayContain)
+	assert.Nil(t, tbl.Close())
+
+	// Tables can only be loaded with the comparator they were built with
+	_, err = LoadSSTable(root, "reverse", Options{})
+	assert.NotNil(t, err)
+	tbl, err = LoadSSTable(root, "reverse", opts)
+	assert.Nil(t, err)
+	assert.Nil(t, tbl.Close())
+}
+
+func TestBinaryKeys(t *T) {
+	root := t.TempDir()
+	builder, err := NewSSTable(1000, root, "binary", Options{})
+	assert.Nil(t, err)
+	key := func(i uint64) string {
+		return string(binary.BigEndian.AppendUint64([]byte{0xff}, i))
+	}
+	for i := uint64(0); i < 1000; i++ {
+		assert.Nil(t, builder.Write(key(i), 0, []byte{byte(i)}))
+	}
+	assert.Nil(t, builder.AddRangeTombstone(RangeTombstone{Start: "\x00\xfe", End: "\x00\xff"}))
+	tbl, err := builder.Build()
+	assert.Nil(t, err)
+	assert.Nil(t, tbl.Close())
+
+	// Keys that aren't valid UTF-8 survive the JSON files of the table
+	tbl, err = LoadSSTable(root, "binary", Options{})
+	assert.Nil(t, err)
+	defer tbl.Close()
+	keyRange, _ := tbl.KeyRange()
+	assert.Equal(t, KeyRange{Smallest: "\x00\xfe", Largest: key(999)}, keyRange)
+	assert.Equal(t, []RangeTombstone{{Start: "\x00\xfe", End: "\x00\xff"}}, tbl.RangeTombstones())
+	for _, i := range []uint64{0, 255, 256, 999} {
+		_, data, exists, err := tbl.Read(key(i))
+		assert.Nil(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte{byte(i)}, data)
+	}
+}
@@ -0,0 +1,335 @@
+package sstable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/lindend/distdb/internal/encryption"
+)
+
+// Holds the data key of an encrypted table, wrapped by a key of the key
+// provider. Tables without it aren't encrypted.
+const keyFileExtension = ".key"
+
+// Size of the plaintext of the blocks of encrypted data, index and hash
+// index files. Each block is sealed on its own, so reads only decrypt the
+// blocks they cover.
+const encryptionBlockSize = 4096
+
+// Encrypts the files of a table with its data key. The data, index and hash
+// index files are split into blocks, the small files read whole, like the
+// metadata and sparse index, are sealed as a single message. Filters only
+// hold hashes of the keys and aren't encrypted.
+type tableCipher struct {
+	cipher *encryption.Cipher
+	// Id of the key of the provider the data key is wrapped with
+	keyID string
+}
+
+// Creates a data key for a new table and saves it, wrapped by the current
+// key of keys.
+func newTableCipher(root string, name string, keys encryption.KeyProvider) (*tableCipher, error) {
+	dataKey, err := encryption.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := encryption.WrapKey(keys, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path.Join(root, name+keyFileExtension), data, 0660); err != nil {
+		return nil, err
+	}
+
+	c, err := encryption.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tableCipher{cipher: c, keyID: wrapped.KeyID}, nil
+}
+
+// Loads the data key of a table. Returns nil if the table isn't encrypted.
+func loadTableCipher(root string, name string, keys encryption.KeyProvider) (*tableCipher, error) {
+	data, err := os.ReadFile(path.Join(root, name+keyFileExtension))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("table %v is encrypted, but no key provider was given", name)
+	}
+
+	wrapped := encryption.WrappedKey{}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, err
+	}
+	dataKey, err := encryption.UnwrapKey(keys, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	c, err := encryption.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tableCipher{cipher: c, keyID: wrapped.KeyID}, nil
+}
+
+// Data authenticated with every block, so blocks can't be moved to another
+// file or position without failing to decrypt.
+func blockAdditionalData(ext string, block int64) []byte {
+	ad := make([]byte, 8, 8+len(ext))
+	binary.BigEndian.PutUint64(ad, uint64(block))
+	return append(ad, ext...)
+}
+
+// Size of an encrypted block of a file
+func (c *tableCipher) sealedBlockSize() int64 {
+	return int64(encryptionBlockSize + c.cipher.Overhead())
+}
+
+// Writes a file of a table, buffered and encrypted if the table is.
+type tableFileWriter struct {
+	*bufio.Writer
+	file *os.File
+	// Encrypts the file, nil if it isn't encrypted
+	encrypter *blockEncrypter
+}
+
+func createTableFile(root string, name string, ext string, c *tableCipher) (*tableFileWriter, error) {
+	file, err := os.Create(path.Join(root, name+ext))
+	if err != nil {
+		return nil, err
+	}
+	w := &tableFileWriter{file: file}
+	if c == nil {
+		w.Writer = bufio.NewWriter(file)
+		return w, nil
+	}
+	w.encrypter = &blockEncrypter{w: file, cipher: c, ext: ext}
+	w.Writer = bufio.NewWriter(w.encrypter)
+	return w, nil
+}
+
+// Writes everything buffered to the file, syncs and closes it.
+func (w *tableFileWriter) finish() error {
+	defer w.file.Close()
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if w.encrypter != nil {
+		if err := w.encrypter.finish(); err != nil {
+			return err
+		}
+	}
+	return w.file.Sync()
+}
+
+// Splits what's written to it into blocks and writes them encrypted.
+type blockEncrypter struct {
+	w      io.Writer
+	cipher *tableCipher
+	ext    string
+	// Plaintext of the block being written
+	block []byte
+	// Index of the block being written
+	index int64
+}
+
+func (e *blockEncrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(encryptionBlockSize-len(e.block), len(p))
+		e.block = append(e.block, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(e.block) == encryptionBlockSize {
+			if err := e.writeBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *blockEncrypter) writeBlock() error {
+	sealed, err := e.cipher.cipher.Seal(nil, e.block, blockAdditionalData(e.ext, e.index))
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.block = e.block[:0]
+	e.index++
+	return nil
+}
+
+// Writes the last block, which may be shorter than the others.
+func (e *blockEncrypter) finish() error {
+	if len(e.block) == 0 {
+		return nil
+	}
+	return e.writeBlock()
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Decrypts a file written by a blockEncrypter, giving random access to the
+// plaintext. The last block read is kept decrypted, since reads like
+// iterating the index read a few bytes at a time.
+type blockDecrypter struct {
+	r      fileReader
+	cipher *tableCipher
+	ext    string
+	// Size of the plaintext
+	size int
+	lock sync.Mutex
+	// Index and plaintext of the last block read, -1 if none was read
+	lastIndex int64
+	last      []byte
+}
+
+func newBlockDecrypter(r fileReader, c *tableCipher, ext string) (*blockDecrypter, error) {
+	sealedSize := c.sealedBlockSize()
+	size := int64(r.Len())
+	// Every block is sealed with the same overhead, the last one may be
+	// shorter but can't be empty
+	if rem := size % sealedSize; rem != 0 && rem <= int64(c.cipher.Overhead()) {
+		return nil, fmt.Errorf("encrypted file %v has invalid size %v", ext, size)
+	}
+	numBlocks := (size + sealedSize - 1) / sealedSize
+	plainSize := size - numBlocks*int64(c.cipher.Overhead())
+	return &blockDecrypter{r: r, cipher: c, ext: ext, size: int(plainSize), lastIndex: -1}, nil
+}
+
+func (d *blockDecrypter) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	n := 0
+	for n < len(p) && off+int64(n) < int64(d.size) {
+		pos := off + int64(n)
+		index := pos / encryptionBlockSize
+		block, err := d.readBlock(index)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos-index*encryptionBlockSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Returns the plaintext of a block. The lock must be held.
+func (d *blockDecrypter) readBlock(index int64) ([]byte, error) {
+	if index == d.lastIndex {
+		return d.last, nil
+	}
+
+	sealedSize := d.cipher.sealedBlockSize()
+	start := index * sealedSize
+	length := min(int(sealedSize), d.r.Len()-int(start))
+	sealed, buffer, err := readBorrowed(d.r, start, length)
+	if err != nil {
+		return nil, err
+	}
+	block, err := d.cipher.cipher.Open(sealed, blockAdditionalData(d.ext, index))
+	if buffer != nil {
+		putReadBuffer(buffer)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt block %v of %v: %w", index, d.ext, err)
+	}
+	d.lastIndex = index
+	d.last = block
+	return block, nil
+}
+
+func (d *blockDecrypter) Len() int {
+	return d.size
+}
+
+func (d *blockDecrypter) Close() error {
+	return d.r.Close()
+}
+
+// Opens a data, index or hash index file of a table for random access,
+// decrypting it if the table is encrypted.
+func openTableFile(root string, name string, ext string, mode IOMode, c *tableCipher) (fileReader, error) {
+	r, err := openFileReader(path.Join(root, name+ext), mode)
+	if err != nil || c == nil {
+		return r, err
+	}
+	d, err := newBlockDecrypter(r, c, ext)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Saves a small file of a table as JSON, sealed as a whole if the table is
+// encrypted.
+func saveTableJson(root string, name string, ext string, c *tableCipher, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		data, err = c.cipher.Seal(nil, data, []byte(ext))
+		if err != nil {
+			return err
+		}
+	} else {
+		data = append(data, '\n')
+	}
+
+	file, err := os.Create(path.Join(root, name+ext))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Loads a small file of a table saved by saveTableJson.
+func loadTableJson(root string, name string, ext string, c *tableCipher, v any) error {
+	data, err := os.ReadFile(path.Join(root, name+ext))
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		data, err = c.cipher.Open(data, []byte(ext))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %v of table %v: %w", ext, name, err)
+		}
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,102 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	. "testing"
+
+	"github.com/lindend/distdb/internal/encryption"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedTables(t *T) {
+	root := t.TempDir()
+	keys, err := encryption.OpenFileKeyProvider(path.Join(root, "keys"))
+	assert.Nil(t, err)
+
+	builder, err := NewSSTable(1000, root, "tbl", Options{KeyProvider: keys, HashIndex: true})
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("secret-key-%04d", i)
+		assert.Nil(t, builder.WriteEntry(key, Entry{Kind: 1, Data: []byte("secret-value-" + key), Seq: uint64(i)}))
+	}
+	assert.Nil(t, builder.AddRangeTombstone(RangeTombstone{Start: "secret-a", End: "secret-b"}))
+	tbl, err := builder.Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "000001", tbl.EncryptionKeyID())
+	assert.Nil(t, tbl.Close())
+
+	for _, ext := range []string{dataFileExtension, indexFileExtension, metadataFileExtension, sparseIndexFileExtension, rangeDelFileExtension, hashIndexFileExtension} {
+		data, err := os.ReadFile(path.Join(root, "tbl"+ext))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(data, []byte("secret")), "%v is encrypted", ext)
+	}
+
+	_, err = LoadSSTable(root, "tbl", Options{})
+	assert.NotNil(t, err)
+
+	// Tables keep the key they were built with after the key is rotated
+	_, err = keys.Rotate()
+	assert.Nil(t, err)
+	for _, mode := range []IOMode{IOModeMmap, IOModePread, IOModeDirect} {
+		tbl, err := LoadSSTable(root, "tbl", Options{KeyProvider: keys, IOMode: mode})
+		if mode == IOModeDirect && err != nil {
+			t.Skip("direct IO not supported:", err)
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, "000001", tbl.EncryptionKeyID())
+		assert.Equal(t, []RangeTombstone{{Start: "secret-a", End: "secret-b"}}, tbl.RangeTombstones())
+
+		for _, i := range []int{0, 1, 500, 999} {
+			key := fmt.Sprintf("secret-key-%04d", i)
+			e, exists, err := tbl.ReadEntry(key)
+			assert.Nil(t, err)
+			assert.True(t, exists)
+			assert.Equal(t, Entry{Kind: 1, Data: []byte("secret-value-" + key), Seq: uint64(i)}, e)
+		}
+		_, exists, err := tbl.ReadEntry("secret-key-1000")
+		assert.Nil(t, err)
+		assert.False(t, exists)
+
+		it, err := tbl.Seek("secret-key-0990")
+		for i := 990; err == nil; i++ {
+			_, key, value := it.Value()
+			assert.Equal(t, fmt.Sprintf("secret-key-%04d", i), key)
+			assert.Equal(t, "secret-value-"+key, string(value))
+			it, err = it.Next()
+		}
+		assert.Nil(t, tbl.Close())
+	}
+}
+
+func TestEncryptedBlocksAreAuthenticated(t *T) {
+	root := t.TempDir()
+	keys, err := encryption.NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	assert.Nil(t, err)
+
+	builder, err := NewSSTable(10, root, "tbl", Options{KeyProvider: keys})
+	assert.Nil(t, err)
+	assert.Nil(t, builder.Write("key", 1, bytes.Repeat([]byte("value"), 2000)))
+	tbl, err := builder.Build()
+	assert.Nil(t, err)
+	assert.Nil(t, tbl.Close())
+
+	// Swapping the first two blocks of the data file fails to decrypt
+	fileName := path.Join(root, "tbl"+dataFileExtension)
+	data, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	c, err := encryption.NewCipher(bytes.Repeat([]byte{1}, 32))
+	assert.Nil(t, err)
+	blockSize := encryptionBlockSize + c.Overhead()
+	swapped := append(append(append([]byte{}, data[blockSize:2*blockSize]...), data[:blockSize]...), data[2*blockSize:]...)
+	assert.Nil(t, os.WriteFile(fileName, swapped, 0660))
+
+	tbl, err = LoadSSTable(root, "tbl", Options{KeyProvider: keys})
+	assert.Nil(t, err)
+	defer tbl.Close()
+	_, _, err = tbl.ReadEntry("key")
+	assert.NotNil(t, err)
+}
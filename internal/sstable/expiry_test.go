@@ -0,0 +1,71 @@
+package sstable
+
+import (
+	"fmt"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryStoredInIndex(t *T) {
+	for _, hashIndex := range []bool{false, true} {
+		builder, err := NewSSTable(1000, t.TempDir(), "tbl", Options{HashIndex: hashIndex})
+		assert.Nil(t, err)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key-%04d", i)
+			assert.Nil(t, builder.WriteWithExpiry(key, 0, []byte("value-"+key), int64(i)))
+		}
+		tbl, err := builder.Build()
+		assert.Nil(t, err)
+
+		for _, i := range []int{0, 1, 500, 999} {
+			key := fmt.Sprintf("key-%04d", i)
+			_, data, expiresAt, exists, err := tbl.ReadWithExpiry(key)
+			assert.Nil(t, err)
+			assert.True(t, exists)
+			assert.Equal(t, "value-"+key, string(data))
+			assert.Equal(t, int64(i), expiresAt)
+
+			value := Value{}
+			exists, err = tbl.Get([]byte(key), &value)
+			assert.Nil(t, err)
+			assert.True(t, exists)
+			assert.Equal(t, int64(i), value.ExpiresAt)
+			value.Release()
+		}
+
+		it, err := tbl.Iterator()
+		for i := 0; err == nil; i++ {
+			_, key, _ := it.Value()
+			assert.Equal(t, fmt.Sprintf("key-%04d", i), key)
+			assert.Equal(t, int64(i), it.ExpiresAt())
+			it, err = it.Next()
+		}
+		assert.Nil(t, tbl.Close())
+	}
+}
+
+func TestSeqStoredInIndex(t *T) {
+	builder, err := NewSSTable(100, t.TempDir(), "tbl", Options{})
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%04d", i)
+		// Sequence numbers don't have to be in key order
+		entry := Entry{Kind: 1, Data: []byte("value-" + key), ExpiresAt: int64(i), Seq: uint64(1000 - i)}
+		assert.Nil(t, builder.WriteEntry(key, entry))
+	}
+	tbl, err := builder.Build()
+	assert.Nil(t, err)
+	defer tbl.Close()
+	assert.Equal(t, uint64(1000), tbl.MaxSeq())
+
+	entry, exists, err := tbl.ReadEntry("key-0042")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, Entry{Kind: 1, Data: []byte("value-key-0042"), ExpiresAt: 42, Seq: 958}, entry)
+
+	it, err := tbl.Seek("key-0010")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(990), it.Seq())
+	assert.Equal(t, int64(10), it.ExpiresAt())
+}
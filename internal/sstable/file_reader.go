@@ -0,0 +1,127 @@
+package sstable
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// How the data, index and hash index files of a table are read.
+type IOMode int
+
+const (
+	// Map the files into memory. Reads need no system calls, but the memory
+	// used is up to the OS, and page faults stall the reading goroutine.
+	IOModeMmap IOMode = iota
+	// Read the files with pread, through a small read-ahead buffer per
+	// file, so the many small reads of iterating a table take few system
+	// calls. Memory use is limited to the buffers, the OS page cache and the
+	// block cache.
+	IOModePread
+	// Read the files with O_DIRECT, bypassing the OS page cache. Reads are
+	// aligned to the block size of the disk, so only the block cache caches
+	// anything. Only supported on Linux.
+	IOModeDirect
+)
+
+// Size of the read-ahead buffer of each file read with pread. Reads at least
+// this large go to the file directly.
+const preadBufferSize = 16 * 1024
+
+// O_DIRECT reads must start at and cover whole blocks of the disk, in
+// buffers aligned in memory. 4KB covers the block size of common disks.
+const directIOAlignment = 4096
+
+// Random access to a file of a table.
+type fileReader interface {
+	io.ReaderAt
+	Len() int
+	Close() error
+}
+
+// Implemented by readers that can lend out their content, like mapped files.
+type slicer interface {
+	slice(off int64, n int) ([]byte, error)
+}
+
+func openFileReader(name string, mode IOMode) (fileReader, error) {
+	switch mode {
+	case IOModeMmap:
+		return openMmapReader(name)
+	case IOModePread:
+		return openPreadReader(name)
+	case IOModeDirect:
+		return openDirectReader(name)
+	}
+	return nil, fmt.Errorf("unknown IO mode %v", mode)
+}
+
+// Reads a file with pread, through a read-ahead buffer. Table files are
+// never modified, so the buffer is never stale.
+type preadReader struct {
+	file *os.File
+	size int
+	// Held while using the buffer. Reads that find it held go to the file,
+	// so concurrent reads don't wait for each other.
+	lock sync.Mutex
+	// Bytes of the file from bufferOffset, allocated by the first read
+	buffer       []byte
+	bufferOffset int64
+}
+
+func openPreadReader(name string) (*preadReader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &preadReader{file: file, size: int(stat.Size())}, nil
+}
+
+func (r *preadReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) >= preadBufferSize || !r.lock.TryLock() {
+		return r.file.ReadAt(p, off)
+	}
+	defer r.lock.Unlock()
+
+	end := off + int64(len(p))
+	if off < r.bufferOffset || end > r.bufferOffset+int64(len(r.buffer)) {
+		if err := r.fill(off); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buffer[off-r.bufferOffset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Reads the bytes of the file from off into the buffer. The lock must be
+// held.
+func (r *preadReader) fill(off int64) error {
+	if r.buffer == nil {
+		r.buffer = make([]byte, preadBufferSize)
+	}
+	n, err := r.file.ReadAt(r.buffer[:preadBufferSize], off)
+	if err != nil && err != io.EOF {
+		r.buffer = r.buffer[:0]
+		return err
+	}
+	r.buffer = r.buffer[:n]
+	r.bufferOffset = off
+	return nil
+}
+
+func (r *preadReader) Len() int {
+	return r.size
+}
+
+func (r *preadReader) Close() error {
+	return r.file.Close()
+}
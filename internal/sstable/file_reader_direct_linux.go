@@ -0,0 +1,110 @@
+//go:build linux
+
+package sstable
+
+import (
+	"io"
+	"math/bits"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+type directReader struct {
+	file *os.File
+	size int
+}
+
+func openDirectReader(name string) (*directReader, error) {
+	file, err := os.OpenFile(name, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &directReader{file: file, size: int(stat.Size())}, nil
+}
+
+// Allocates a buffer with its start aligned to directIOAlignment.
+func alignedBuffer(size int) []byte {
+	buffer := make([]byte, size+directIOAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buffer[0])) & (directIOAlignment - 1)); rem != 0 {
+		offset = directIOAlignment - rem
+	}
+	return buffer[offset : offset+size : offset+size]
+}
+
+// Number of sizes of pooled aligned buffers. Each size class is twice the
+// size of the one before, from directIOAlignment up to 1MB.
+const alignedBufferClasses = 9
+
+// Aligned buffers by size class. Pointers to slices are pooled, so putting
+// them back doesn't allocate.
+var alignedBufferPools [alignedBufferClasses]sync.Pool
+
+// Returns the size class of buffers of size bytes, a multiple of
+// directIOAlignment.
+func alignedBufferClass(size int) int {
+	return bits.Len(uint((size - 1) / directIOAlignment))
+}
+
+// Returns an aligned buffer of size bytes, which must be returned with
+// putAlignedBuffer. Buffers larger than the largest size class aren't
+// pooled.
+func getAlignedBuffer(size int) *[]byte {
+	class := alignedBufferClass(size)
+	if class >= alignedBufferClasses {
+		buffer := alignedBuffer(size)
+		return &buffer
+	}
+	if buffer, ok := alignedBufferPools[class].Get().(*[]byte); ok {
+		*buffer = (*buffer)[:size]
+		return buffer
+	}
+	buffer := alignedBuffer(directIOAlignment << class)[:size]
+	return &buffer
+}
+
+func putAlignedBuffer(buffer *[]byte) {
+	class := alignedBufferClass(cap(*buffer))
+	if class < alignedBufferClasses && cap(*buffer) == directIOAlignment<<class {
+		alignedBufferPools[class].Put(buffer)
+	}
+}
+
+func (r *directReader) ReadAt(p []byte, off int64) (int, error) {
+	start := off &^ (directIOAlignment - 1)
+	end := (off + int64(len(p)) + directIOAlignment - 1) &^ (directIOAlignment - 1)
+
+	pooled := getAlignedBuffer(int(end - start))
+	defer putAlignedBuffer(pooled)
+	buffer := *pooled
+	n, err := r.file.ReadAt(buffer, start)
+	// The last block of the file is short
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	available := n - int(off-start)
+	if available <= 0 {
+		return 0, io.EOF
+	}
+	copied := copy(p, buffer[off-start:n])
+	if copied < len(p) {
+		return copied, io.EOF
+	}
+	return copied, nil
+}
+
+func (r *directReader) Len() int {
+	return r.size
+}
+
+func (r *directReader) Close() error {
+	return r.file.Close()
+}
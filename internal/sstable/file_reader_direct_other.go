@@ -0,0 +1,9 @@
+//go:build !linux
+
+package sstable
+
+import "errors"
+
+func openDirectReader(name string) (fileReader, error) {
+	return nil, errors.New("direct IO is only supported on linux")
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package sstable
+
+import "golang.org/x/exp/mmap"
+
+func openMmapReader(name string) (fileReader, error) {
+	return mmap.Open(name)
+}
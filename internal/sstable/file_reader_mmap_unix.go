@@ -0,0 +1,75 @@
+//go:build linux || darwin || freebsd
+
+package sstable
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// A file mapped into memory. Unlike golang.org/x/exp/mmap it gives access to
+// the mapped bytes, so reads can borrow them instead of copying.
+type mmapReader struct {
+	data []byte
+}
+
+func openMmapReader(name string) (fileReader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Empty files can't be mapped
+	if stat.Size() == 0 {
+		return &mmapReader{}, nil
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &mmapReader{data: data}, nil
+}
+
+func (r *mmapReader) ReadAt(p []byte, off int64) (int, error) {
+	if r.data == nil && len(p) > 0 {
+		return 0, errors.New("mmap: closed or empty file")
+	}
+	if off < 0 || off > int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Returns n bytes at off without copying them. The bytes are only valid until
+// the reader is closed.
+func (r *mmapReader) slice(off int64, n int) ([]byte, error) {
+	if off < 0 || off+int64(n) > int64(len(r.data)) {
+		return nil, io.EOF
+	}
+	return r.data[off : off+int64(n) : off+int64(n)], nil
+}
+
+func (r *mmapReader) Len() int {
+	return len(r.data)
+}
+
+func (r *mmapReader) Close() error {
+	if r.data == nil {
+		return nil
+	}
+	data := r.data
+	r.data = nil
+	return syscall.Munmap(data)
+}
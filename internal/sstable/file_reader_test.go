@@ -0,0 +1,137 @@
+package sstable
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadWithIOModes(t *T) {
+	root := t.TempDir()
+	keys := []string{}
+	for i := 0; i < 2000; i++ {
+		keys = append(keys, fmt.Sprintf("key%05d", i))
+	}
+	tbl := buildTable(t, root, "modes", keys, Options{HashIndex: true})
+	assert.Nil(t, tbl.Close())
+
+	for _, mode := range []IOMode{IOModeMmap, IOModePread, IOModeDirect} {
+		tbl, err := LoadSSTable(root, "modes", Options{IOMode: mode})
+		if mode == IOModeDirect && err != nil {
+			t.Logf("direct IO not supported: %v", err)
+			continue
+		}
+		assert.Nil(t, err)
+
+		for _, k := range []string{"key00000", "key01234", "key01999"} {
+			_, data, exists, err := tbl.Read(k)
+			assert.Nil(t, err)
+			assert.True(t, exists, "%v in mode %v", k, mode)
+			assert.Equal(t, []byte("value-"+k), data)
+		}
+
+		it, err := tbl.Seek("key01990")
+		count := 0
+		for err == nil {
+			count += 1
+			it, err = it.Next()
+		}
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, 10, count)
+		assert.Nil(t, tbl.Close())
+	}
+}
+
+func TestPreadReaderBuffersReads(t *T) {
+	name := path.Join(t.TempDir(), "file")
+	content := make([]byte, 2*preadBufferSize)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	assert.Nil(t, os.WriteFile(name, content, 0660))
+
+	r, err := openFileReader(name, IOModePread)
+	assert.Nil(t, err)
+	defer r.Close()
+
+	buf := make([]byte, 100)
+	n, err := r.ReadAt(buf, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, content[10:110], buf)
+
+	// Change the file behind the reader, reads within the buffer return
+	// what was read ahead
+	changed := make([]byte, len(content))
+	file, err := os.OpenFile(name, os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = file.WriteAt(changed, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	_, err = r.ReadAt(buf, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, content[1000:1100], buf, "Should read from the buffer")
+	_, err = r.ReadAt(buf, preadBufferSize+500)
+	assert.Nil(t, err)
+	assert.Equal(t, changed[:100], buf, "Should read past the buffer from the file")
+
+	// Reads past the end of the file are short
+	n, err = r.ReadAt(buf, int64(len(content)-50))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 50, n)
+}
+
+func TestDirectReaderUnalignedReads(t *T) {
+	name := path.Join(t.TempDir(), "file")
+	content := make([]byte, 3*directIOAlignment+100)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	assert.Nil(t, os.WriteFile(name, content, 0660))
+
+	r, err := openFileReader(name, IOModeDirect)
+	if err != nil {
+		t.Skipf("direct IO not supported: %v", err)
+	}
+	defer r.Close()
+	assert.Equal(t, len(content), r.Len())
+
+	buf := make([]byte, 200)
+	n, err := r.ReadAt(buf, directIOAlignment-50)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, n)
+	assert.Equal(t, content[directIOAlignment-50:directIOAlignment+150], buf)
+
+	// Reads past the end of the file are short
+	n, err = r.ReadAt(buf, int64(len(content)-50))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 50, n)
+	assert.Equal(t, content[len(content)-50:], buf[:50])
+}
+
+func TestDirectReaderPoolsBuffers(t *T) {
+	if raceEnabled {
+		t.Skip("allocations are not stable with the race detector")
+	}
+	name := path.Join(t.TempDir(), "file")
+	assert.Nil(t, os.WriteFile(name, make([]byte, 3*directIOAlignment), 0660))
+
+	r, err := openFileReader(name, IOModeDirect)
+	if err != nil {
+		t.Skipf("direct IO not supported: %v", err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, 200)
+	allocs := AllocsPerRun(100, func() {
+		if _, err := r.ReadAt(buf, directIOAlignment-50); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Equal(t, float64(0), allocs)
+}
@@ -0,0 +1,403 @@
+package sstable
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+const filterPartitionIndexFileExtension = ".bloomidx"
+
+const defaultFilterFalsePositiveRate = 0.01
+
+// The kind of filter used to reject reads of keys that aren't in a table.
+type FilterType string
+
+const (
+	// A bloom filter, can be built incrementally
+	BloomFilterType FilterType = "bloom"
+	// A xor filter, uses less space than a bloom filter for the same false
+	// positive rate
+	XorFilterType FilterType = "xor"
+)
+
+// A probabilistic set of keys. MayContain never returns false for a key in
+// the set, but may return true for keys that aren't.
+type Filter interface {
+	MayContain(key []byte) bool
+	// Size of the filter in memory, in bytes
+	Size() int64
+	WriteTo(w io.Writer) (int64, error)
+}
+
+// Adds keys to a filter before it's built.
+type filterWriter interface {
+	add(key []byte)
+	build() (Filter, error)
+}
+
+func newFilterWriter(filterType FilterType, numElements uint, falsePositiveRate float64) (filterWriter, error) {
+	if falsePositiveRate <= 0 {
+		falsePositiveRate = defaultFilterFalsePositiveRate
+	}
+	switch filterType {
+	case BloomFilterType, "":
+		return &bloomFilterWriter{bloom.NewWithEstimates(numElements, falsePositiveRate)}, nil
+	case XorFilterType:
+		return newXorFilterWriter(numElements, falsePositiveRate), nil
+	}
+	return nil, fmt.Errorf("unknown filter type %v", filterType)
+}
+
+// Reads a filter written by WriteTo. Tables without a recorded filter type
+// use bloom filters.
+func readFilter(filterType FilterType, r io.Reader) (Filter, error) {
+	switch filterType {
+	case BloomFilterType, "":
+		filter := bloom.BloomFilter{}
+		_, err := filter.ReadFrom(r)
+		if err != nil {
+			return nil, err
+		}
+		return bloomFilter{&filter}, nil
+	case XorFilterType:
+		return readXorFilter(r)
+	}
+	return nil, fmt.Errorf("unknown filter type %v", filterType)
+}
+
+func loadFilter(root string, name string, filterType FilterType) (Filter, error) {
+	file, err := os.Open(path.Join(root, name+bloomFilterFileExtension))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readFilter(filterType, bufio.NewReader(file))
+}
+
+type bloomFilter struct {
+	filter *bloom.BloomFilter
+}
+
+func (f bloomFilter) MayContain(key []byte) bool {
+	return f.filter.Test(key)
+}
+
+func (f bloomFilter) Size() int64 {
+	return int64(f.filter.Cap() / 8)
+}
+
+func (f bloomFilter) WriteTo(w io.Writer) (int64, error) {
+	return f.filter.WriteTo(w)
+}
+
+type bloomFilterWriter struct {
+	filter *bloom.BloomFilter
+}
+
+func (w *bloomFilterWriter) add(key []byte) {
+	w.filter.Add(key)
+}
+
+func (w *bloomFilterWriter) build() (Filter, error) {
+	return bloomFilter{w.filter}, nil
+}
+
+// Builds the filter of a table while entries are written to it. The filter
+// contains whole keys, key prefixes or both. A partitioned filter is split
+// into one filter per index block, written to the .bloom file one
+// after the other. The offsets of the partitions are stored in the
+// .bloomidx file.
+type filterBuilder struct {
+	prefixExtractor   PrefixExtractor
+	wholeKeys         bool
+	filterType        FilterType
+	falsePositiveRate float64
+	// Filter of the whole table, nil if partitioned
+	filter filterWriter
+	// Keys added to the partition of the current index block
+	partitionKeys [][]byte
+	// Offsets of the partitions in the .bloom file, followed by the size of
+	// the file
+	partitionOffsets []int64
+	file             *os.File
+	writer           *bufio.Writer
+	position         int64
+}
+
+func newFilterBuilder(numElements uint, root string, name string, opts Options) (*filterBuilder, error) {
+	f := &filterBuilder{
+		prefixExtractor:   opts.PrefixExtractor,
+		wholeKeys:         !opts.PrefixFilterOnly || opts.PrefixExtractor == nil,
+		filterType:        opts.FilterType,
+		falsePositiveRate: opts.FilterFalsePositiveRate,
+	}
+	if f.filterType == "" {
+		f.filterType = BloomFilterType
+	}
+
+	if !opts.PartitionedFilters {
+		filter, err := newFilterWriter(f.filterType, numElements, f.falsePositiveRate)
+		if err != nil {
+			return nil, err
+		}
+		f.filter = filter
+		return f, nil
+	}
+
+	file, err := os.Create(path.Join(root, name+bloomFilterFileExtension))
+	if err != nil {
+		return nil, err
+	}
+	f.file = file
+	f.writer = bufio.NewWriter(file)
+	f.partitionOffsets = []int64{}
+	return f, nil
+}
+
+func (f *filterBuilder) partitioned() bool {
+	return f.filter == nil
+}
+
+func (f *filterBuilder) add(key []byte) {
+	if f.wholeKeys {
+		f.addToFilter(key)
+	}
+	if f.prefixExtractor != nil && f.prefixExtractor.InDomain(key) {
+		f.addToFilter(f.prefixExtractor.Transform(key))
+	}
+}
+
+func (f *filterBuilder) addToFilter(data []byte) {
+	if f.partitioned() {
+		f.partitionKeys = append(f.partitionKeys, data)
+	} else {
+		f.filter.add(data)
+	}
+}
+
+// Writes the filter partition of the current index block. Must be called
+// once for every index block, when the block is complete.
+func (f *filterBuilder) finishPartition() error {
+	if !f.partitioned() {
+		return nil
+	}
+
+	writer, err := newFilterWriter(f.filterType, uint(len(f.partitionKeys)), f.falsePositiveRate)
+	if err != nil {
+		return err
+	}
+	for _, k := range f.partitionKeys {
+		writer.add(k)
+	}
+	f.partitionKeys = f.partitionKeys[:0]
+
+	filter, err := writer.build()
+	if err != nil {
+		return err
+	}
+
+	f.partitionOffsets = append(f.partitionOffsets, f.position)
+	n, err := filter.WriteTo(f.writer)
+	f.position += n
+	return err
+}
+
+func (f *filterBuilder) save(root string, name string) error {
+	if !f.partitioned() {
+		filter, err := f.filter.build()
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(path.Join(root, name+bloomFilterFileExtension))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		writer := bufio.NewWriter(file)
+		if _, err = filter.WriteTo(writer); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+
+	defer f.file.Close()
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path.Join(root, name+filterPartitionIndexFileExtension))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(append(f.partitionOffsets, f.position))
+}
+
+func (f *filterBuilder) setMetadata(meta *SSTableMetaData) {
+	if f.prefixExtractor != nil {
+		meta.FilterPrefixExtractor = f.prefixExtractor.Name()
+	}
+	meta.FilterPrefixOnly = !f.wholeKeys
+	meta.FilterPartitioned = f.partitioned()
+	meta.FilterType = f.filterType
+}
+
+func loadFilterPartitionIndex(root string, name string) ([]int64, error) {
+	file, err := os.Open(path.Join(root, name+filterPartitionIndexFileExtension))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	offsets := []int64{}
+	err = json.NewDecoder(file).Decode(&offsets)
+	if err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// Sets up the filter of a loaded table. Without a block cache a whole table
+// filter is always kept in memory, and with pinning it is put in the cache
+// right away. Otherwise filters are loaded into the cache on first use.
+func (s *SSTable) loadFilter() error {
+	extractor := s.opts.PrefixExtractor
+	if extractor != nil && extractor.Name() == s.meta.FilterPrefixExtractor {
+		s.prefixExtractor = extractor
+	}
+
+	if s.meta.FilterPartitioned {
+		offsets, err := loadFilterPartitionIndex(s.root, s.name)
+		if err != nil {
+			return err
+		}
+		s.filterPartitions = offsets
+
+		s.filterFile, err = os.Open(path.Join(s.root, s.name+bloomFilterFileExtension))
+		return err
+	}
+
+	var err error
+	if s.opts.BlockCache == nil {
+		s.filter, err = loadFilter(s.root, s.name, s.meta.FilterType)
+	} else if s.opts.PinIndexAndFilterBlocks {
+		_, err = s.getFilter(0)
+	}
+	return err
+}
+
+// Returns the filter for keys in the given index block. Partitioned filters
+// are read from disk on every call when the table has no block cache.
+func (s *SSTable) getFilter(block int) (Filter, error) {
+	cache := s.opts.BlockCache
+	if cache == nil && !s.meta.FilterPartitioned {
+		return s.filter, nil
+	}
+
+	key := blockCacheKey{tableId: s.cacheId, kind: blockKindFilter}
+	if s.meta.FilterPartitioned {
+		key.offset = int64(block)
+	}
+
+	if cache != nil {
+		if filter, exists := cache.get(key); exists {
+			return filter.(Filter), nil
+		}
+	}
+
+	var filter Filter
+	var err error
+	if s.meta.FilterPartitioned {
+		start, end := s.filterPartitions[block], s.filterPartitions[block+1]
+		filter, err = readFilter(s.meta.FilterType, io.NewSectionReader(s.filterFile, start, end-start))
+	} else {
+		filter, err = loadFilter(s.root, s.name, s.meta.FilterType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != nil {
+		cache.insert(key, filter, filter.Size(), s.opts.PinIndexAndFilterBlocks)
+	}
+	return filter, nil
+}
+
+// Checks the filter of the index block a key would be in. Tables with a
+// prefix only filter can only reject keys based on their prefix.
+func (s *SSTable) keyMayMatch(key []byte, block int) (bool, error) {
+	probe := key
+	if s.meta.FilterPrefixOnly {
+		if s.prefixExtractor == nil || !s.prefixExtractor.InDomain(key) {
+			return true, nil
+		}
+		probe = s.prefixExtractor.Transform(key)
+	}
+
+	filter, err := s.getFilter(block)
+	if err != nil {
+		return false, err
+	}
+	return filter.MayContain(probe), nil
+}
+
+// Checks if the table may contain keys starting with prefix. Only tables
+// with a prefix filter, built by the extractor the table is loaded with, can
+// rule out a prefix. The prefix must also be in the domain of the extractor.
+func (s *SSTable) MayContainPrefix(prefix string) (bool, error) {
+	prefixBytes := []byte(prefix)
+	if s.prefixExtractor == nil || !s.prefixExtractor.InDomain(prefixBytes) {
+		return true, nil
+	}
+	probe := s.prefixExtractor.Transform(prefixBytes)
+
+	if !s.meta.FilterPartitioned {
+		filter, err := s.getFilter(0)
+		if err != nil {
+			return false, err
+		}
+		return filter.MayContain(probe), nil
+	}
+
+	// Keys with the prefix are in the block the seek key of the prefix would
+	// be in, and any following blocks starting with the prefix. Without a
+	// seek key every block may have keys with the prefix.
+	first := 0
+	seeker, contiguous := s.cmp.(PrefixSeeker)
+	if contiguous {
+		if key, ok := seeker.PrefixSeekKey(prefix); ok {
+			first = s.getIndexBlock([]byte(key))
+		}
+		if first < 0 {
+			first = 0
+		}
+	}
+	for i := first; i < len(s.sparseIndex); i++ {
+		if contiguous && i > first && !strings.HasPrefix(string(s.sparseIndex[i].Key), prefix) {
+			break
+		}
+
+		filter, err := s.getFilter(i)
+		if err != nil {
+			return false, err
+		}
+		if filter.MayContain(probe) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,115 @@
+package sstable
+
+import (
+	"fmt"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTable(t *T, root string, name string, keys []string, opts Options) *SSTable {
+	builder, err := NewSSTable(uint(len(keys)), root, name, opts)
+	assert.Nil(t, err)
+	for _, k := range keys {
+		assert.Nil(t, builder.Write(k, 0, []byte("value-"+k)))
+	}
+	tbl, err := builder.Build()
+	assert.Nil(t, err)
+	return tbl
+}
+
+func TestFixedPrefixExtractor(t *T) {
+	e := NewFixedPrefixExtractor(3)
+	assert.Equal(t, "fixed:3", e.Name())
+	assert.False(t, e.InDomain([]byte("ab")))
+	assert.True(t, e.InDomain([]byte("abc")))
+	assert.Equal(t, []byte("abc"), e.Transform([]byte("abcdef")))
+}
+
+func TestDelimitedPrefixExtractor(t *T) {
+	e := NewDelimitedPrefixExtractor(':')
+	assert.False(t, e.InDomain([]byte("user")))
+	assert.True(t, e.InDomain([]byte("user:1")))
+	assert.Equal(t, []byte("user:"), e.Transform([]byte("user:1:name")))
+}
+
+func TestPrefixFilterSkipsMissingPrefix(t *T) {
+	keys := []string{}
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("user:%03d", i))
+	}
+	tbl := buildTable(t, t.TempDir(), "prefix", keys, Options{
+		PrefixExtractor:  NewDelimitedPrefixExtractor(':'),
+		PrefixFilterOnly: true,
+	})
+	defer tbl.Close()
+
+	mayContain, err := tbl.MayContainPrefix("user:")
+	assert.Nil(t, err)
+	assert.True(t, mayContain)
+
+	mayContain, err = tbl.MayContainPrefix("order:")
+	assert.Nil(t, err)
+	assert.False(t, mayContain)
+
+	// Prefixes outside the domain of the extractor can't be ruled out
+	mayContain, err = tbl.MayContainPrefix("order")
+	assert.Nil(t, err)
+	assert.True(t, mayContain)
+
+	_, data, exists, err := tbl.Read("user:042")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []byte("value-user:042"), data)
+}
+
+func TestPrefixFilterIgnoredForOtherExtractor(t *T) {
+	root := t.TempDir()
+	tbl := buildTable(t, root, "prefix", []string{"user:1", "user:2"}, Options{
+		PrefixExtractor: NewDelimitedPrefixExtractor(':'),
+	})
+	assert.Nil(t, tbl.Close())
+
+	tbl, err := LoadSSTable(root, "prefix", Options{PrefixExtractor: NewFixedPrefixExtractor(2)})
+	assert.Nil(t, err)
+	defer tbl.Close()
+
+	mayContain, err := tbl.MayContainPrefix("or")
+	assert.Nil(t, err)
+	assert.True(t, mayContain, "filter built by another extractor should not be used")
+}
+
+func TestPartitionedFilters(t *T) {
+	keys := []string{}
+	for i := 0; i < 2000; i++ {
+		keys = append(keys, fmt.Sprintf("key:%05d", i))
+	}
+
+	for _, cache := range []*BlockCache{nil, NewBlockCache(1024*1024, 4)} {
+		tbl := buildTable(t, t.TempDir(), "partitioned", keys, Options{
+			BlockCache:         cache,
+			PrefixExtractor:    NewFixedPrefixExtractor(7),
+			PartitionedFilters: true,
+		})
+		assert.True(t, len(tbl.filterPartitions) > 2, "should have several partitions")
+
+		for _, k := range []string{"key:00000", "key:01000", "key:01999"} {
+			_, data, exists, err := tbl.Read(k)
+			assert.Nil(t, err)
+			assert.True(t, exists, k)
+			assert.Equal(t, []byte("value-"+k), data)
+		}
+		_, _, exists, err := tbl.Read("key:99999")
+		assert.Nil(t, err)
+		assert.False(t, exists)
+
+		mayContain, err := tbl.MayContainPrefix("key:010")
+		assert.Nil(t, err)
+		assert.True(t, mayContain)
+		mayContain, err = tbl.MayContainPrefix("key:990")
+		assert.Nil(t, err)
+		assert.False(t, mayContain)
+
+		assert.Nil(t, tbl.Close())
+	}
+}
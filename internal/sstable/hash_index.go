@@ -0,0 +1,111 @@
+package sstable
+
+import (
+	"encoding/binary"
+)
+
+const hashIndexFileExtension = ".hashidx"
+
+// Number of buckets per entry of a block. Fewer buckets give more
+// collisions, which fall back to scanning the block.
+const hashIndexBucketsPerEntry = 1.5
+
+const (
+	// No key in the block hashes to the bucket
+	hashBucketEmpty uint32 = 0
+	// Several keys hash to the bucket, the block has to be scanned
+	hashBucketCollision uint32 = 0xffffffff
+)
+
+// Builds one hash table per index block, mapping the hash of every key in the
+// block to the offset of its entry. The tables are written to the .hashidx
+// file one after the other, each bucket as 4 bytes holding the offset of the
+// entry in the block plus one, or one of the markers for empty and
+// colliding buckets. The position and number of buckets of each table are
+// stored in the sparse index.
+type hashIndexBuilder struct {
+	file     *tableFileWriter
+	position int64
+	// Hashes and offsets of the entries of the current block
+	hashes  []uint32
+	offsets []uint32
+}
+
+func newHashIndexBuilder(root string, name string, c *tableCipher) (*hashIndexBuilder, error) {
+	file, err := createTableFile(root, name, hashIndexFileExtension, c)
+	if err != nil {
+		return nil, err
+	}
+	return &hashIndexBuilder{file: file}, nil
+}
+
+func hashIndexKey(key []byte) uint32 {
+	return uint32(hashKey(key))
+}
+
+// Adds an entry starting at offset in the current block.
+func (h *hashIndexBuilder) add(key []byte, offset int64) {
+	h.hashes = append(h.hashes, hashIndexKey(key))
+	h.offsets = append(h.offsets, uint32(offset))
+}
+
+// Writes the hash table of the current block and records it in the sparse
+// index entry of the block.
+func (h *hashIndexBuilder) finishBlock(block *indexEntry) error {
+	numBuckets := int(float64(len(h.hashes))*hashIndexBucketsPerEntry) + 1
+	buckets := make([]uint32, numBuckets)
+	for i, hash := range h.hashes {
+		b := hash % uint32(numBuckets)
+		if buckets[b] == hashBucketEmpty {
+			buckets[b] = h.offsets[i] + 1
+		} else {
+			buckets[b] = hashBucketCollision
+		}
+	}
+	h.hashes = h.hashes[:0]
+	h.offsets = h.offsets[:0]
+
+	block.HashOffset = h.position
+	block.HashBuckets = numBuckets
+
+	buf := make([]byte, 4)
+	for _, b := range buckets {
+		binary.BigEndian.PutUint32(buf, b)
+		if _, err := h.file.Write(buf); err != nil {
+			return err
+		}
+	}
+	h.position += int64(4 * numBuckets)
+	return nil
+}
+
+func (h *hashIndexBuilder) save() error {
+	return h.file.finish()
+}
+
+// Looks up a key in the hash index of a block. Returns the offset of the
+// entry in the block if the key has a bucket of its own, and false as second
+// value if the key is definitely not in the block. If the key collides with
+// other keys, the offset is -1 and the block has to be scanned.
+func (s *SSTable) lookupHashIndex(key []byte, block int) (int64, bool, error) {
+	entry := s.sparseIndex[block]
+	bucket := int64(hashIndexKey(key) % uint32(entry.HashBuckets))
+
+	buf, buffer, err := readBorrowed(s.hashIndex, entry.HashOffset+4*bucket, 4)
+	if err != nil {
+		return 0, false, err
+	}
+	value := binary.BigEndian.Uint32(buf)
+	if buffer != nil {
+		putReadBuffer(buffer)
+	}
+
+	switch value {
+	case hashBucketEmpty:
+		return 0, false, nil
+	case hashBucketCollision:
+		return -1, true, nil
+	default:
+		return int64(value - 1), true, nil
+	}
+}
@@ -0,0 +1,94 @@
+package sstable
+
+import (
+	"fmt"
+	"math/rand"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadWithHashIndex(t *T) {
+	keys := []string{}
+	for i := 0; i < 5000; i++ {
+		keys = append(keys, fmt.Sprintf("hej%012d", i))
+	}
+	tbl := buildTable(t, t.TempDir(), "hashed", keys, Options{HashIndex: true})
+	defer tbl.Close()
+	assert.True(t, len(tbl.sparseIndex) > 1, "should have several blocks")
+
+	for _, k := range keys {
+		_, data, exists, err := tbl.Read(k)
+		assert.Nil(t, err)
+		assert.True(t, exists, k)
+		assert.Equal(t, []byte("value-"+k), data)
+	}
+
+	for _, k := range []string{"hej000000000000x", "hej", "zzz"} {
+		_, _, exists, err := tbl.Read(k)
+		assert.Nil(t, err)
+		assert.False(t, exists, k)
+	}
+}
+
+func TestHashIndexFallsBackOnCollisions(t *T) {
+	keys := []string{}
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, fmt.Sprintf("key%04d", i))
+	}
+	tbl := buildTable(t, t.TempDir(), "hashed", keys, Options{HashIndex: true})
+	defer tbl.Close()
+
+	collisions := 0
+	for _, k := range keys {
+		offset, mayExist, err := tbl.lookupHashIndex([]byte(k), tbl.getIndexBlock([]byte(k)))
+		assert.Nil(t, err)
+		assert.True(t, mayExist)
+		if offset < 0 {
+			collisions += 1
+		}
+
+		_, _, exists, err := tbl.Read(k)
+		assert.Nil(t, err)
+		assert.True(t, exists, k)
+	}
+	assert.True(t, collisions > 0, "expected some keys to share buckets")
+}
+
+// Random point lookups like the perfProf workload in main.go, with and without
+// the hash index.
+func BenchmarkRead(b *B) {
+	const numElements = 200000
+	root := b.TempDir()
+
+	for _, hashIndex := range []bool{false, true} {
+		name := "scan"
+		if hashIndex {
+			name = "hash"
+		}
+
+		builder, err := NewSSTable(numElements, root, name, Options{HashIndex: hashIndex})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < numElements; i++ {
+			builder.Write(fmt.Sprintf("hej%012d", i), 0, []byte(fmt.Sprintf("hejsanthisisaslightlylongermessage%d", i)))
+		}
+		tbl, err := builder.Build()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		keys := make([]string, 1024)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("hej%012d", rand.Intn(numElements))
+		}
+
+		b.Run(name, func(b *B) {
+			for i := 0; i < b.N; i++ {
+				tbl.Read(keys[i%len(keys)])
+			}
+		})
+		tbl.Close()
+	}
+}
@@ -6,19 +6,27 @@ import (
 )
 
 type SSTableIterator struct {
-	tbl             *SSTable
-	key             []byte
-	kind            uint64
-	value           []byte
+	tbl *SSTable
+	// Reused for the key of every entry
+	key       []byte
+	kind      uint64
+	value     []byte
+	expiresAt int64
+	seq       uint64
+	// Scratch space for the numbers of index entries
+	numBuf          [8]byte
 	nextIndexOffset int64
 }
 
-func (s SSTableIterator) Next() (*SSTableIterator, error) {
+// Advances the iterator to the next entry and returns it, or returns nil and
+// io.EOF at the end of the table. The iterator is advanced in place, reusing
+// its buffers, so only the value of the entry is allocated.
+func (s *SSTableIterator) Next() (*SSTableIterator, error) {
 	if s.nextIndexOffset == int64(s.tbl.index.Len()) {
 		return nil, io.EOF
 	}
 
-	numBuf := make([]byte, 8)
+	numBuf := s.numBuf[:]
 
 	_, err := s.tbl.index.ReadAt(numBuf, s.nextIndexOffset)
 	if err != nil {
@@ -32,7 +40,10 @@ func (s SSTableIterator) Next() (*SSTableIterator, error) {
 	}
 	keyLen := int64(binary.BigEndian.Uint64(numBuf))
 
-	keyBuffer := make([]byte, keyLen)
+	if int64(cap(s.key)) < keyLen {
+		s.key = make([]byte, keyLen)
+	}
+	keyBuffer := s.key[:keyLen]
 	_, err = s.tbl.index.ReadAt(keyBuffer, s.nextIndexOffset+8+8)
 	if err != nil {
 		return nil, err
@@ -44,21 +55,52 @@ func (s SSTableIterator) Next() (*SSTableIterator, error) {
 	}
 	dataOffset := int64(binary.BigEndian.Uint64(numBuf))
 
-	dataBuffer, err := s.tbl.getDataEntry(dataOffset)
+	expiresAt := int64(0)
+	if s.tbl.meta.IndexVersion >= indexVersionExpiry {
+		_, err = s.tbl.index.ReadAt(numBuf, s.nextIndexOffset+8+8+keyLen+8)
+		if err != nil {
+			return nil, err
+		}
+		expiresAt = int64(binary.BigEndian.Uint64(numBuf))
+	}
+
+	seq := uint64(0)
+	if s.tbl.meta.IndexVersion >= indexVersionSeq {
+		_, err = s.tbl.index.ReadAt(numBuf, s.nextIndexOffset+8+8+keyLen+8+8)
+		if err != nil {
+			return nil, err
+		}
+		seq = binary.BigEndian.Uint64(numBuf)
+	}
+
+	dataBuffer, err := s.tbl.getDataEntry(dataOffset, false)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &SSTableIterator{
-		tbl:             s.tbl,
-		kind:            kind,
-		key:             keyBuffer,
-		value:           dataBuffer,
-		nextIndexOffset: s.nextIndexOffset + 8 + keyLen + 8,
-	}, nil
+	s.kind = kind
+	s.key = keyBuffer
+	s.value = dataBuffer
+	s.expiresAt = expiresAt
+	s.seq = seq
+	s.nextIndexOffset += 8 + 8 + keyLen + int64(s.tbl.indexEntryTrailerSize())
+	return s, nil
 }
 
-func (s SSTableIterator) Value() (uint64, string, []byte) {
+// Returns the current entry. The key is a copy and the value isn't reused,
+// both stay valid after the iterator is advanced.
+func (s *SSTableIterator) Value() (uint64, string, []byte) {
 	return s.kind, string(s.key), s.value
 }
+
+// Unix time in nanoseconds when the current entry expires, 0 if it never
+// does.
+func (s *SSTableIterator) ExpiresAt() int64 {
+	return s.expiresAt
+}
+
+// Sequence number of the current entry.
+func (s *SSTableIterator) Seq() uint64 {
+	return s.seq
+}
@@ -0,0 +1,78 @@
+package sstable
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// The smallest and largest key of a table, both inclusive. Range tombstones
+// are included, with their exclusive end counted as the largest key.
+type KeyRange struct {
+	Smallest string
+	Largest  string
+}
+
+type keyRangeJSON struct {
+	Smallest jsonKey `json:"s"`
+	Largest  jsonKey `json:"l"`
+}
+
+func (r KeyRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(keyRangeJSON{jsonKey(r.Smallest), jsonKey(r.Largest)})
+}
+
+func (r *KeyRange) UnmarshalJSON(data []byte) error {
+	stored := keyRangeJSON{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*r = KeyRange{Smallest: string(stored.Smallest), Largest: string(stored.Largest)}
+	return nil
+}
+
+func (r KeyRange) Overlaps(other KeyRange, cmp Comparator) bool {
+	return cmp.Compare(r.Smallest, other.Largest) <= 0 && cmp.Compare(other.Smallest, r.Largest) <= 0
+}
+
+// Returns a range that also covers [smallest, largest]. A nil range is
+// empty.
+func extendKeyRange(r *KeyRange, smallest string, largest string, cmp Comparator) *KeyRange {
+	if r == nil {
+		return &KeyRange{Smallest: smallest, Largest: largest}
+	}
+	if cmp.Compare(smallest, r.Smallest) < 0 {
+		r.Smallest = smallest
+	}
+	if cmp.Compare(largest, r.Largest) > 0 {
+		r.Largest = largest
+	}
+	return r
+}
+
+// Finds the key range of a table by reading its first and last key. Only
+// needed for tables written before the range was stored in the metadata.
+func (s *SSTable) scanKeyRange() (*KeyRange, error) {
+	var keyRange *KeyRange
+	it, err := s.Iterator()
+	for err == nil {
+		_, key, _ := it.Value()
+		keyRange = extendKeyRange(keyRange, key, key, s.cmp)
+		it, err = it.Next()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+
+	for _, t := range s.rangeTombstones {
+		keyRange = extendKeyRange(keyRange, t.Start, t.End, s.cmp)
+	}
+	return keyRange, nil
+}
+
+// Returns the key range of the table, false if the table is empty.
+func (s *SSTable) KeyRange() (KeyRange, bool) {
+	if s.meta.KeyRange == nil {
+		return KeyRange{}, false
+	}
+	return *s.meta.KeyRange, true
+}
@@ -0,0 +1,63 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Extracts the prefix of keys, used to build filters on key prefixes
+// instead of whole keys.
+type PrefixExtractor interface {
+	// Stored with the tables built using the extractor. A table only uses its
+	// prefix filter if it's loaded with an extractor with the same name.
+	Name() string
+	// Returns true if the key has a prefix
+	InDomain(key []byte) bool
+	// Returns the prefix of a key in the domain of the extractor
+	Transform(key []byte) []byte
+}
+
+type fixedPrefixExtractor struct {
+	length int
+}
+
+// Creates an extractor using the first length bytes of keys as prefix. Keys
+// shorter than length have no prefix.
+func NewFixedPrefixExtractor(length int) PrefixExtractor {
+	return fixedPrefixExtractor{length}
+}
+
+func (e fixedPrefixExtractor) Name() string {
+	return fmt.Sprintf("fixed:%v", e.length)
+}
+
+func (e fixedPrefixExtractor) InDomain(key []byte) bool {
+	return len(key) >= e.length
+}
+
+func (e fixedPrefixExtractor) Transform(key []byte) []byte {
+	return key[:e.length]
+}
+
+type delimitedPrefixExtractor struct {
+	delimiter byte
+}
+
+// Creates an extractor using everything up to, and including, the first
+// delimiter of keys as prefix. For example "tenant1:" of "tenant1:key". Keys
+// without the delimiter have no prefix.
+func NewDelimitedPrefixExtractor(delimiter byte) PrefixExtractor {
+	return delimitedPrefixExtractor{delimiter}
+}
+
+func (e delimitedPrefixExtractor) Name() string {
+	return fmt.Sprintf("delimited:%q", e.delimiter)
+}
+
+func (e delimitedPrefixExtractor) InDomain(key []byte) bool {
+	return bytes.IndexByte(key, e.delimiter) >= 0
+}
+
+func (e delimitedPrefixExtractor) Transform(key []byte) []byte {
+	return key[:bytes.IndexByte(key, e.delimiter)+1]
+}
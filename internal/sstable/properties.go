@@ -0,0 +1,70 @@
+package sstable
+
+// Statistics of a table, collected while it's built and stored in its
+// metadata.
+type TableProperties struct {
+	// Total size of all keys and values written to the table
+	RawKeySize   int64
+	RawValueSize int64
+	// Number of entries of each kind
+	EntriesByKind map[uint64]int64 `json:",omitempty"`
+	// Number of range tombstones
+	NumRangeDeletions int64
+	// Properties added by the property collectors of the table
+	UserProperties map[string]string `json:",omitempty"`
+}
+
+// Computes custom properties of a table, for example the smallest and largest
+// timestamp of keys. A new collector is created for every table built, it's
+// called for each entry in the order they're written.
+type PropertyCollector interface {
+	Add(key string, kind uint64, data []byte)
+	// Returns the properties to store with the table, called once every
+	// entry has been added. Names should be prefixed by the collector to
+	// avoid clashes with other collectors.
+	Finish() map[string]string
+}
+
+type propertiesBuilder struct {
+	props      TableProperties
+	collectors []PropertyCollector
+}
+
+func newPropertiesBuilder(factories []func() PropertyCollector) *propertiesBuilder {
+	collectors := make([]PropertyCollector, len(factories))
+	for i, f := range factories {
+		collectors[i] = f()
+	}
+	return &propertiesBuilder{
+		props:      TableProperties{EntriesByKind: map[uint64]int64{}},
+		collectors: collectors,
+	}
+}
+
+func (p *propertiesBuilder) add(key string, kind uint64, data []byte) {
+	p.props.RawKeySize += int64(len(key))
+	p.props.RawValueSize += int64(len(data))
+	p.props.EntriesByKind[kind] += 1
+	for _, c := range p.collectors {
+		c.Add(key, kind, data)
+	}
+}
+
+func (p *propertiesBuilder) finish(numRangeDeletions int) TableProperties {
+	p.props.NumRangeDeletions = int64(numRangeDeletions)
+	for _, c := range p.collectors {
+		for name, value := range c.Finish() {
+			if p.props.UserProperties == nil {
+				p.props.UserProperties = map[string]string{}
+			}
+			p.props.UserProperties[name] = value
+		}
+	}
+	return p.props
+}
+
+// Returns the properties of the table. Tables written before properties were
+// collected only have NumEntries of the metadata set.
+func (s *SSTable) Properties() TableProperties {
+	return s.meta.Properties
+}
@@ -0,0 +1,57 @@
+package sstable
+
+import (
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tracks the smallest and largest timestamp suffix of keys
+type timestampCollector struct {
+	min string
+	max string
+}
+
+func (c *timestampCollector) Add(key string, kind uint64, data []byte) {
+	ts := key[strings.LastIndexByte(key, '@')+1:]
+	if c.min == "" || ts < c.min {
+		c.min = ts
+	}
+	if ts > c.max {
+		c.max = ts
+	}
+}
+
+func (c *timestampCollector) Finish() map[string]string {
+	return map[string]string{"ts.min": c.min, "ts.max": c.max}
+}
+
+func TestTableProperties(t *T) {
+	root := t.TempDir()
+	builder, err := NewSSTable(3, root, "props", Options{
+		PropertyCollectors: []func() PropertyCollector{
+			func() PropertyCollector { return &timestampCollector{} },
+		},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, builder.Write("a@0003", 1, []byte("12345")))
+	assert.Nil(t, builder.Write("b@0001", 2, nil))
+	assert.Nil(t, builder.Write("c@0002", 1, []byte("123")))
+	assert.Nil(t, builder.AddRangeTombstone(RangeTombstone{Start: "d", End: "e"}))
+	tbl, err := builder.Build()
+	assert.Nil(t, err)
+	assert.Nil(t, tbl.Close())
+
+	// Properties are persisted with the table
+	tbl, err = LoadSSTable(root, "props", Options{})
+	assert.Nil(t, err)
+	defer tbl.Close()
+
+	props := tbl.Properties()
+	assert.Equal(t, int64(18), props.RawKeySize)
+	assert.Equal(t, int64(8), props.RawValueSize)
+	assert.Equal(t, map[uint64]int64{1: 2, 2: 1}, props.EntriesByKind)
+	assert.Equal(t, int64(1), props.NumRangeDeletions)
+	assert.Equal(t, map[string]string{"ts.min": "0001", "ts.max": "0003"}, props.UserProperties)
+}
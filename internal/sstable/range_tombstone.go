@@ -0,0 +1,54 @@
+package sstable
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const rangeDelFileExtension = ".rangedel"
+
+// Marks every key in [Start, End) as deleted.
+type RangeTombstone struct {
+	Start string
+	End   string
+}
+
+type rangeTombstoneJSON struct {
+	Start jsonKey `json:"s"`
+	End   jsonKey `json:"e"`
+}
+
+func (t RangeTombstone) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rangeTombstoneJSON{jsonKey(t.Start), jsonKey(t.End)})
+}
+
+func (t *RangeTombstone) UnmarshalJSON(data []byte) error {
+	stored := rangeTombstoneJSON{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*t = RangeTombstone{Start: string(stored.Start), End: string(stored.End)}
+	return nil
+}
+
+func (t RangeTombstone) Covers(key string, cmp Comparator) bool {
+	return cmp.Compare(t.Start, key) <= 0 && cmp.Compare(key, t.End) < 0
+}
+
+func saveRangeTombstones(root string, name string, c *tableCipher, tombstones []RangeTombstone) error {
+	return saveTableJson(root, name, rangeDelFileExtension, c, tombstones)
+}
+
+// Loads the range tombstones of a table. Tables written before range
+// tombstones were supported have no range-del file, and no tombstones.
+func loadRangeTombstones(root string, name string, c *tableCipher) ([]RangeTombstone, error) {
+	tombstones := []RangeTombstone{}
+	err := loadTableJson(root, name, rangeDelFileExtension, c, &tombstones)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
@@ -0,0 +1,90 @@
+package sstable
+
+import "sync"
+
+// Scratch buffers for reads from files that can't be borrowed from. Pointers
+// to slices are pooled, so putting them back doesn't allocate.
+var readBufferPool = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, 0, defaultSparseIndexBlockSize)
+		return &buffer
+	},
+}
+
+func getReadBuffer(n int) *[]byte {
+	buffer := readBufferPool.Get().(*[]byte)
+	if cap(*buffer) < n {
+		*buffer = make([]byte, n)
+	}
+	*buffer = (*buffer)[:n]
+	return buffer
+}
+
+func putReadBuffer(buffer *[]byte) {
+	readBufferPool.Put(buffer)
+}
+
+// Reads n bytes at off. The bytes are borrowed from the file if it's mapped
+// into memory, and the returned buffer is nil. Otherwise they're read into a
+// pooled buffer, which must be returned with putReadBuffer once the bytes
+// aren't used anymore.
+func readBorrowed(r fileReader, off int64, n int) ([]byte, *[]byte, error) {
+	if s, ok := r.(slicer); ok {
+		data, err := s.slice(off, n)
+		return data, nil, err
+	}
+
+	buffer := getReadBuffer(n)
+	_, err := r.ReadAt(*buffer, off)
+	if err != nil {
+		putReadBuffer(buffer)
+		return nil, nil, err
+	}
+	return *buffer, buffer, nil
+}
+
+// A value read from a table without copying it. Data may point into the
+// mapped data file, the block cache or a pooled buffer, and must not be
+// modified. It's only valid until Release is called.
+type Value struct {
+	Kind uint64
+	Data []byte
+	// Unix time in nanoseconds when the entry expires, 0 if it never does
+	ExpiresAt int64
+	// Sequence number of the entry
+	Seq uint64
+	// Set if the data points into a mapped file
+	mapped bool
+	// Set while the value borrows the mapped files of the table, which stay
+	// open until the value is released
+	tbl *SSTable
+	// Pooled buffer holding the data
+	buffer *[]byte
+}
+
+// Releases the memory the value borrows. The value can be reused for another
+// read afterwards. Every value read must be released, or the files of its
+// table are never closed.
+func (v *Value) Release() {
+	if v.buffer != nil {
+		putReadBuffer(v.buffer)
+		v.buffer = nil
+	}
+	if v.tbl != nil {
+		v.tbl.release()
+		v.tbl = nil
+	}
+	v.mapped = false
+	v.Data = nil
+}
+
+// Returns a copy of the data owned by the caller, unless the data is shared
+// with the block cache, which is never modified.
+func (v *Value) detach() []byte {
+	if !v.mapped && v.buffer == nil {
+		return v.Data
+	}
+	data := make([]byte, len(v.Data))
+	copy(data, v.Data)
+	return data
+}
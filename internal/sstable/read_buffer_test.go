@@ -0,0 +1,147 @@
+package sstable
+
+import (
+	"fmt"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildReadTable(t TB, root string, opts Options) *SSTable {
+	builder, err := NewSSTable(10000, root, "read", opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10000; i++ {
+		builder.Write(fmt.Sprintf("hej%012d", i), 0, []byte(fmt.Sprintf("value%d", i)))
+	}
+	tbl, err := builder.Build()
+	assert.Nil(t, err)
+	return tbl
+}
+
+func TestGetBorrowsValue(t *T) {
+	tbl := buildReadTable(t, t.TempDir(), Options{})
+
+	value := Value{}
+	exists, err := tbl.Get([]byte("hej000000001234"), &value)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []byte("value1234"), value.Data)
+
+	// Reusing the value releases the previous read
+	exists, err = tbl.Get([]byte("hej000000005678"), &value)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []byte("value5678"), value.Data)
+	value.Release()
+
+	exists, err = tbl.Get([]byte("missing"), &value)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.Nil(t, tbl.Close())
+}
+
+func TestBorrowedValuesOutliveClose(t *T) {
+	tbl := buildReadTable(t, t.TempDir(), Options{})
+
+	borrowed := Value{}
+	exists, err := tbl.Get([]byte("hej000000001234"), &borrowed)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	// Reads don't wait for borrowed values, and closing doesn't either
+	other := Value{}
+	exists, err = tbl.Get([]byte("hej000000005678"), &other)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	other.Release()
+	assert.Nil(t, tbl.Close())
+	assert.Nil(t, tbl.Close())
+
+	// The files stay mapped until the value is released
+	assert.Equal(t, []byte("value1234"), borrowed.Data)
+	_, err = tbl.Get([]byte("hej000000001234"), &other)
+	assert.NotNil(t, err, "closed table should not be read")
+	borrowed.Release()
+	assert.Equal(t, int64(0), tbl.refs.Load())
+}
+
+func TestGetDoesNotAllocate(t *T) {
+	if raceEnabled {
+		t.Skip("allocations are not stable with the race detector")
+	}
+	for _, opts := range []Options{
+		{BlockCache: NewBlockCache(1024*1024, 1), PinIndexAndFilterBlocks: true},
+		{BlockCache: NewBlockCache(1024*1024, 1), HashIndex: true},
+		{IOMode: IOModeMmap},
+		{IOMode: IOModePread},
+	} {
+		tbl := buildReadTable(t, t.TempDir(), opts)
+		key := []byte("hej000000004242")
+		value := Value{}
+		// Fill the block cache
+		tbl.Get(key, &value)
+
+		allocs := AllocsPerRun(100, func() {
+			exists, err := tbl.Get(key, &value)
+			if !exists || err != nil {
+				t.Fatal("key should exist")
+			}
+			value.Release()
+		})
+		assert.Equal(t, float64(0), allocs, "options %+v", opts)
+		assert.Nil(t, tbl.Close())
+	}
+}
+
+func TestIteratorOnlyAllocatesValues(t *T) {
+	if raceEnabled {
+		t.Skip("allocations are not stable with the race detector")
+	}
+	tbl := buildReadTable(t, t.TempDir(), Options{})
+	defer tbl.Close()
+
+	it, err := tbl.Iterator()
+	assert.Nil(t, err)
+	allocs := AllocsPerRun(100, func() {
+		it, err = it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	// Values are copied out of the mapped file, so they stay valid after
+	// the iterator is advanced
+	assert.Equal(t, float64(1), allocs)
+
+	_, key, value := it.Value()
+	_, err = it.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "hej000000000101", key, "keys should stay valid after advancing")
+	assert.Equal(t, []byte("value101"), value)
+}
+
+func BenchmarkGet(b *B) {
+	for _, cached := range []bool{false, true} {
+		opts := Options{}
+		name := "mmap"
+		if cached {
+			opts.BlockCache = NewBlockCache(64*1024*1024, 4)
+			name = "cached"
+		}
+		tbl := buildReadTable(b, b.TempDir(), opts)
+
+		keys := make([][]byte, 1000)
+		for i := range keys {
+			keys[i] = []byte(fmt.Sprintf("hej%012d", i*10))
+		}
+
+		b.Run(name, func(b *B) {
+			b.ReportAllocs()
+			value := Value{}
+			for i := 0; i < b.N; i++ {
+				tbl.Get(keys[i%len(keys)], &value)
+				value.Release()
+			}
+		})
+		tbl.Close()
+	}
+}
@@ -3,13 +3,15 @@ package sstable
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
 
-	"github.com/bits-and-blooms/bloom/v3"
-	"golang.org/x/exp/mmap"
+	"github.com/lindend/distdb/internal/encryption"
 )
 
 const dataFileExtension = ".data"
@@ -18,13 +20,119 @@ const metadataFileExtension = ".meta"
 const bloomFilterFileExtension = ".bloom"
 const sparseIndexFileExtension = ".spindex"
 
+// Extensions of every file that can be part of a table
+var fileExtensions = []string{
+	dataFileExtension,
+	indexFileExtension,
+	metadataFileExtension,
+	bloomFilterFileExtension,
+	filterPartitionIndexFileExtension,
+	sparseIndexFileExtension,
+	rangeDelFileExtension,
+	hashIndexFileExtension,
+	keyFileExtension,
+}
+
+// Versions of the layout of the entries in the index file
+const (
+	// kind | key length | key | data offset
+	indexVersionInitial = 0
+	// kind | key length | key | data offset | expires at
+	indexVersionExpiry = 1
+	// kind | key length | key | data offset | expires at | sequence number
+	indexVersionSeq = 2
+)
+
 type indexEntry struct {
-	Key    string `json:"k"`
-	Offset int64  `json:"o"`
+	Key    jsonKey `json:"k"`
+	Offset int64   `json:"o"`
+	// Position and number of buckets of the hash table of the block in the
+	// hash index file. Zero buckets if the table has no hash index.
+	HashOffset  int64 `json:"ho,omitempty"`
+	HashBuckets int   `json:"hb,omitempty"`
 }
 
 type SSTableMetaData struct {
 	NumEntries int64
+	// Layout of the entries in the index file
+	IndexVersion int `json:",omitempty"`
+	// Largest sequence number of the entries of the table
+	MaxSeq uint64 `json:",omitempty"`
+	// Name of the comparator ordering the keys, empty for tables written
+	// before it was recorded, which are in bytewise order
+	Comparator string `json:",omitempty"`
+	// Name of the prefix extractor used to add key prefixes to the filter,
+	// empty if the filter has no prefixes
+	FilterPrefixExtractor string `json:",omitempty"`
+	// Set if only key prefixes, and not whole keys, are in the filter
+	FilterPrefixOnly bool `json:",omitempty"`
+	// Set if the filter is split into one partition per index block
+	FilterPartitioned bool `json:",omitempty"`
+	// Kind of filter of the table, empty for tables with a bloom filter
+	// written before the type was recorded
+	FilterType FilterType `json:",omitempty"`
+	// Set if the table has a hash index
+	HashIndex  bool `json:",omitempty"`
+	Properties TableProperties
+	// Range of keys in the table, nil if the table is empty
+	KeyRange *KeyRange `json:",omitempty"`
+	// Set once the key range is stored, tables written before that have it
+	// computed when loaded
+	HasKeyRange bool `json:",omitempty"`
+}
+
+// Options used when creating and loading SSTables.
+type Options struct {
+	// Cache for index, data and filter blocks, shared between tables. If nil,
+	// blocks are read from the mapped files on every access and the filter is
+	// kept in memory for as long as the table is loaded.
+	BlockCache *BlockCache
+	// Keep the index and filter blocks of the table in the block cache for as
+	// long as the table is loaded, instead of letting them be evicted.
+	PinIndexAndFilterBlocks bool
+	// Extracts key prefixes to add to the filter when building a table, so
+	// prefix scans can skip tables without the prefix. A table only uses its
+	// prefix filter when loaded with an extractor with the same name.
+	PrefixExtractor PrefixExtractor
+	// Only add key prefixes, and not whole keys, to the filter. Gives smaller
+	// filters, but point lookups can only be rejected based on their prefix.
+	PrefixFilterOnly bool
+	// Build one filter per index block instead of one for the whole table,
+	// so only the filters of the blocks being read have to be in memory.
+	PartitionedFilters bool
+	// Kind of filter to build, defaults to a bloom filter. Tables are always
+	// loaded with the kind of filter they were built with.
+	FilterType FilterType
+	// Target false positive rate of the filter, defaults to 1%. Xor filters
+	// round it down to 2^-8, 2^-16 or 2^-32.
+	FilterFalsePositiveRate float64
+	// How the files of the table are read, defaults to mapping them into
+	// memory.
+	IOMode IOMode
+	// Build a hash index for every index block, letting point lookups go
+	// straight to the entry of a key instead of scanning the block.
+	HashIndex bool
+	// Creates the collectors of custom properties for each table built.
+	PropertyCollectors []func() PropertyCollector
+	// Order of the keys of the table, defaults to bytewise order. Tables
+	// must be loaded with the comparator they were built with.
+	Comparator Comparator
+	// Encrypts new tables with a data key of their own, wrapped by the
+	// current key of the provider. Encrypted tables can only be loaded with
+	// a provider of the key their data key is wrapped with. If nil, new
+	// tables aren't encrypted.
+	KeyProvider encryption.KeyProvider
+}
+
+// An entry of a table, along with the fields stored in its index entry.
+type Entry struct {
+	Kind uint64
+	Data []byte
+	// Unix time in nanoseconds when the entry expires, 0 if it never does
+	ExpiresAt int64
+	// Sequence number of the entry, set by the writer of the table. Tables
+	// don't assign sequence numbers themselves.
+	Seq uint64
 }
 
 type sparseIndex []indexEntry
@@ -32,13 +140,25 @@ type sparseIndex []indexEntry
 // Immutable data structure used for quick key-value lookups from disk.
 type SSTable struct {
 	// Used to quickly filter queries for elements that definitely does not exist
-	// in the table.
-	filter *bloom.BloomFilter
+	// in the table. Only set when the table doesn't use a block cache, otherwise
+	// the filter is kept in the cache.
+	filter Filter
+	// Offsets of the filter partitions of the index blocks in the filter file,
+	// followed by the size of the file. Only set for partitioned filters.
+	filterPartitions []int64
+	// Handle to the file where filter partitions are stored
+	filterFile *os.File
+	// Extractor used for the prefix filter, nil if the table has no prefix
+	// filter or it was built by another extractor
+	prefixExtractor PrefixExtractor
 	// Handle to the file where data entries are stored
-	data *mmap.ReaderAt
+	data fileReader
 	// Handle to the file where the full index is stored. The index associates
 	// keys with values in the data file.
-	index *mmap.ReaderAt
+	index fileReader
+	// Handle to the file with the hash tables of the index blocks, nil if
+	// the table has no hash index
+	hashIndex fileReader
 	// An in-memory sparsely populated version of the on disk index. Offset here points to
 	// the next entry in the index file.
 	sparseIndex sparseIndex
@@ -48,32 +168,31 @@ type SSTable struct {
 	name string
 	// Metadata
 	meta SSTableMetaData
+	// Deleted key ranges, stored in the range-del block
+	rangeTombstones []RangeTombstone
+	// Options the table was loaded with
+	opts Options
+	// Order of the keys
+	cmp Comparator
+	// Identifies the table in the block cache
+	cacheId uint64
+	// Decrypts the files of the table, nil if it isn't encrypted
+	cipher *tableCipher
+	// References to the files of the table. The table holds one until it's
+	// closed, and reads and values borrowing memory from the mapped files
+	// hold one each. The files are closed when the last one is released.
+	refs atomic.Int64
+	// Set once the table is closed, new reads are refused even if the files
+	// are still open
+	closed    atomic.Bool
+	closeOnce sync.Once
 }
 
-func loadBloomFilter(root string, name string) (*bloom.BloomFilter, error) {
-	file, err := os.Open(path.Join(root, name+bloomFilterFileExtension))
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	bloomFilter := bloom.BloomFilter{}
-	bloomFilter.ReadFrom(file)
-
-	return &bloomFilter, nil
-}
-
-func loadSparseIndex(root string, name string) (sparseIndex, error) {
-	file, err := os.Open(path.Join(root, name+sparseIndexFileExtension))
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
+var errTableClosed = errors.New("table is closed")
 
+func loadSparseIndex(root string, name string, c *tableCipher) (sparseIndex, error) {
 	idx := sparseIndex{}
-	err = decoder.Decode(&idx)
+	err := loadTableJson(root, name, sparseIndexFileExtension, c, &idx)
 	if err != nil {
 		return nil, err
 	}
@@ -81,17 +200,9 @@ func loadSparseIndex(root string, name string) (sparseIndex, error) {
 	return idx, nil
 }
 
-func loadMetadata(root string, name string) (*SSTableMetaData, error) {
-	file, err := os.Open(path.Join(root, name+metadataFileExtension))
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-
+func loadMetadata(root string, name string, c *tableCipher) (*SSTableMetaData, error) {
 	m := SSTableMetaData{}
-	err = decoder.Decode(&m)
+	err := loadTableJson(root, name, metadataFileExtension, c, &m)
 	if err != nil {
 		return nil, err
 	}
@@ -102,160 +213,480 @@ func loadMetadata(root string, name string) (*SSTableMetaData, error) {
 // Loads an SSTable from disk. An SSTable is stored in a few different files:
 // .index - all the keys, with offsets pointing to the .data file
 // .data - data of the SSTable
-// .bloom - a bloom filter used to quickly reject items not in this SSTable
+// .bloom - a bloom or xor filter used to quickly reject items not in this SSTable,
+// or one filter per index block for partitioned filters
+// .bloomidx - offsets of the filter partitions in the .bloom file
 // .spindex - the sparse index, which is loaded into memory. Used to
 //
 //	look up actual index locations in the index file.
-func LoadSSTable(root string, name string) (*SSTable, error) {
-	bloomFilter, err := loadBloomFilter(root, name)
+//
+// .rangedel - the range tombstones of the table, loaded into memory
+// .key - the data key of an encrypted table, wrapped by a key of the key
+// provider. The other files, except for the filters, are encrypted with it.
+func LoadSSTable(root string, name string, opts Options) (*SSTable, error) {
+	cipher, err := loadTableCipher(root, name, opts.KeyProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	sparseIndex, err := loadSparseIndex(root, name, cipher)
 	if err != nil {
 		return nil, err
 	}
 
-	sparseIndex, err := loadSparseIndex(root, name)
+	metadata, err := loadMetadata(root, name, cipher)
 	if err != nil {
 		return nil, err
 	}
 
-	metadata, err := loadMetadata(root, name)
+	cmp := opts.comparator()
+	builtWith := metadata.Comparator
+	if builtWith == "" {
+		builtWith = BytewiseComparator.Name()
+	}
+	if builtWith != cmp.Name() {
+		return nil, fmt.Errorf("table %v was built with comparator %v, not %v", name, builtWith, cmp.Name())
+	}
+
+	rangeTombstones, err := loadRangeTombstones(root, name, cipher)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := mmap.Open(path.Join(root, name+dataFileExtension))
+	data, err := openTableFile(root, name, dataFileExtension, opts.IOMode, cipher)
 	if err != nil {
 		return nil, err
 	}
 
-	index, err := mmap.Open(path.Join(root, name+indexFileExtension))
+	index, err := openTableFile(root, name, indexFileExtension, opts.IOMode, cipher)
 	if err != nil {
+		data.Close()
 		return nil, err
 	}
 
 	sstable := &SSTable{
-		filter:      bloomFilter,
-		data:        data,
-		index:       index,
-		sparseIndex: sparseIndex,
-		meta:        *metadata,
-		root:        root,
-		name:        name,
+		data:            data,
+		index:           index,
+		sparseIndex:     sparseIndex,
+		meta:            *metadata,
+		root:            root,
+		rangeTombstones: rangeTombstones,
+		name:            name,
+		opts:            opts,
+		cmp:             cmp,
+		cacheId:         nextTableCacheId.Add(1),
+		cipher:          cipher,
+	}
+	sstable.refs.Store(1)
+
+	err = sstable.loadFilter()
+	if err != nil {
+		sstable.Close()
+		return nil, err
+	}
+
+	if sstable.meta.HashIndex {
+		sstable.hashIndex, err = openTableFile(root, name, hashIndexFileExtension, opts.IOMode, cipher)
+		if err != nil {
+			sstable.Close()
+			return nil, err
+		}
+	}
+
+	if !sstable.meta.HasKeyRange {
+		sstable.meta.KeyRange, err = sstable.scanKeyRange()
+		if err != nil {
+			sstable.Close()
+			return nil, err
+		}
+		sstable.meta.HasKeyRange = true
 	}
 
 	return sstable, nil
 }
 
-// Performs a lookup in the sparse index to determine range of index offsets where
-// the key can be present.
-func (s *SSTable) getIndexRange(key string) (start int64, end int64) {
-	min := 0
-	max := len(s.sparseIndex) - 1
-
-	// Last segment is a special case
-	if s.sparseIndex[max].Key <= key {
-		return s.sparseIndex[max].Offset, int64(s.index.Len())
-	}
-
-	// Perform a binary search over the index blocks
-	for max != min {
-		// Divide by 2 rounded up
-		idx := (min+max)/2 + (min+max)%2
-		v := s.sparseIndex[idx]
-		if v.Key > key {
-			max = idx - 1
-		} else if v.Key < key {
-			min = idx
-		} else {
-			return s.sparseIndex[idx].Offset, s.sparseIndex[idx+1].Offset
+// Reads the part of the index file between start and end, either from the
+// block cache or from disk. If the returned buffer isn't nil it must be
+// returned with putReadBuffer once the block isn't used anymore.
+func (s *SSTable) readIndexBlock(start int64, end int64) ([]byte, *[]byte, error) {
+	cache := s.opts.BlockCache
+	key := blockCacheKey{tableId: s.cacheId, kind: blockKindIndex, offset: start}
+	if cache != nil {
+		if block, exists := cache.get(key); exists {
+			return block.([]byte), nil, nil
 		}
 	}
-	return s.sparseIndex[min].Offset, s.sparseIndex[min+1].Offset
+
+	block, buffer, err := readBorrowed(s.index, start, int(end-start))
+	if err != nil || cache == nil {
+		return block, buffer, err
+	}
+
+	// The cache keeps the block after the table is closed, so it needs a
+	// copy of its own
+	owned := make([]byte, len(block))
+	copy(owned, block)
+	if buffer != nil {
+		putReadBuffer(buffer)
+	}
+	cache.insert(key, owned, int64(len(owned)), s.opts.PinIndexAndFilterBlocks)
+	return owned, nil, nil
 }
 
-// Scans the on disk index from byte offsets start to end looking for the specified key.
-// Returns the offset in the data file where result can be found
-func (s *SSTable) scanIndex(key []byte, start int64, end int64) (uint64, int64, bool, error) {
-	// TODO: pool the buffer
-	buffer := make([]byte, end-start)
+// Performs a lookup in the sparse index to determine which index block the
+// key can be present in. Returns -1 if the key is before the first block.
+func (s *SSTable) getIndexBlock(key []byte) int {
+	// Find the first block starting after the key, the key is in the one
+	// before
+	return sort.Search(len(s.sparseIndex), func(i int) bool {
+		return s.cmp.Compare(string(s.sparseIndex[i].Key), keyString(key)) > 0
+	}) - 1
+}
+
+// Returns the range of offsets in the index file of an index block.
+func (s *SSTable) getIndexRange(block int) (start int64, end int64) {
+	if block < 0 {
+		return 0, 0
+	}
+	if block == len(s.sparseIndex)-1 {
+		return s.sparseIndex[block].Offset, int64(s.index.Len())
+	}
+	return s.sparseIndex[block].Offset, s.sparseIndex[block+1].Offset
+}
 
+// An entry of the index file
+type indexRecord struct {
+	kind       uint64
+	key        []byte
+	dataOffset int64
+	// Unix time in nanoseconds when the entry expires, 0 if it never does
+	expiresAt int64
+	seq       uint64
+}
+
+// Scans the on disk index from byte offsets start to end looking for the specified key.
+// Returns the index entry pointing to the data of the key
+func (s *SSTable) scanIndex(key []byte, start int64, end int64) (indexRecord, bool, error) {
 	// Load the whole range we are interested of into a buffer
-	_, err := s.index.ReadAt(buffer, start)
+	block, buffer, err := s.readIndexBlock(start, end)
 	if err != nil {
-		return 0, 0, false, err
+		return indexRecord{}, false, err
+	}
+	if buffer != nil {
+		defer putReadBuffer(buffer)
 	}
 
 	// Start looking for the key
 	for i := int64(0); i < end-start; {
-		kind := binary.BigEndian.Uint64(buffer[i : i+8])
-		i += 8
-		keyLen := int64(binary.BigEndian.Uint64(buffer[i : i+8]))
-		i += 8
-		bufferKey := buffer[i : i+keyLen]
+		record, next := s.parseIndexEntry(block, i)
 		// Check if it's the correct key
-		if bytes.Equal(bufferKey, key) {
-			offset := binary.BigEndian.Uint64(buffer[i+keyLen : i+keyLen+8])
-			return kind, int64(offset), true, nil
+		if bytes.Equal(record.key, key) {
+			return record, true, nil
 		}
-		i += keyLen + 8
+		i = next
+	}
+	return indexRecord{}, false, nil
+}
+
+// Size of the fields following the key of an index entry
+func (s *SSTable) indexEntryTrailerSize() int {
+	switch {
+	case s.meta.IndexVersion >= indexVersionSeq:
+		return 8 + 8 + 8
+	case s.meta.IndexVersion >= indexVersionExpiry:
+		return 8 + 8
 	}
-	return 0, 0, false, nil
+	return 8
 }
 
-func (s *SSTable) getDataEntry(offset int64) ([]byte, error) {
-	kindBuf := make([]byte, 1)
-	_, err := s.data.ReadAt(kindBuf, offset)
+// Reads a single entry of the index file. If the returned buffer isn't nil
+// it must be returned with putReadBuffer once the entry isn't used anymore.
+func (s *SSTable) readIndexEntry(offset int64) ([]byte, *[]byte, error) {
+	header, buffer, err := readBorrowed(s.index, offset, 8+8)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	keyLen := int(binary.BigEndian.Uint64(header[8:]))
+	if buffer != nil {
+		putReadBuffer(buffer)
+	}
+
+	return readBorrowed(s.index, offset, 8+8+keyLen+s.indexEntryTrailerSize())
+}
+
+// Parses the index entry at position i of an index block. Returns the entry
+// and the position of the next entry. The key of the entry points into
+// buffer.
+func (s *SSTable) parseIndexEntry(buffer []byte, i int64) (indexRecord, int64) {
+	record := indexRecord{}
+	record.kind = binary.BigEndian.Uint64(buffer[i : i+8])
+	i += 8
+	keyLen := int64(binary.BigEndian.Uint64(buffer[i : i+8]))
+	i += 8
+	record.key = buffer[i : i+keyLen]
+	i += keyLen
+	record.dataOffset = int64(binary.BigEndian.Uint64(buffer[i : i+8]))
+	i += 8
+	if s.meta.IndexVersion >= indexVersionExpiry {
+		record.expiresAt = int64(binary.BigEndian.Uint64(buffer[i : i+8]))
+		i += 8
+	}
+	if s.meta.IndexVersion >= indexVersionSeq {
+		record.seq = binary.BigEndian.Uint64(buffer[i : i+8])
+		i += 8
+	}
+	return record, i
+}
+
+// Finds a key in an index block using the hash index. Falls back to scanning
+// the block if the key shares its bucket with other keys.
+func (s *SSTable) findInBlock(key []byte, block int) (indexRecord, bool, error) {
+	start, end := s.getIndexRange(block)
+	if s.hashIndex == nil {
+		return s.scanIndex(key, start, end)
 	}
-	// TODO: act on kind of entry (checksum, etc)
-	// kind := kindBuf[0]
 
-	numBuf := make([]byte, 8)
-	_, err = s.data.ReadAt(numBuf, offset+1)
+	entryOffset, mayExist, err := s.lookupHashIndex(key, block)
+	if err != nil || !mayExist {
+		return indexRecord{}, false, err
+	}
+	if entryOffset < 0 {
+		return s.scanIndex(key, start, end)
+	}
+
+	var entries []byte
+	var buffer *[]byte
+	if s.opts.BlockCache != nil {
+		entries, buffer, err = s.readIndexBlock(start, end)
+	} else {
+		// Without a cache only the entry itself has to be read
+		entries, buffer, err = s.readIndexEntry(start + entryOffset)
+		entryOffset = 0
+	}
 	if err != nil {
-		return nil, err
+		return indexRecord{}, false, err
 	}
-	dataLen := binary.BigEndian.Uint64(numBuf)
-	data := make([]byte, dataLen)
-	_, err = s.data.ReadAt(data, offset+1+8)
-	return data, err
+	if buffer != nil {
+		defer putReadBuffer(buffer)
+	}
+	// Another key with the same bucket may be in the block instead
+	record, _ := s.parseIndexEntry(entries, entryOffset)
+	if !bytes.Equal(record.key, key) {
+		return indexRecord{}, false, nil
+	}
+	return record, true, nil
 }
 
-func (s *SSTable) Read(key string) (uint64, []byte, bool, error) {
-	if !s.filter.Test([]byte(key)) {
-		return 0, nil, false, nil
+// Size of the kind of a data entry followed by the length of its data
+const dataEntryHeaderSize = 1 + 8
+
+// Reads the data entry at offset in the data file into value. If fillCache
+// is false the entry is not added to the block cache, used for scans that
+// would otherwise push out more frequently used blocks.
+func (s *SSTable) readDataEntry(offset int64, fillCache bool, value *Value) error {
+	cache := s.opts.BlockCache
+	key := blockCacheKey{tableId: s.cacheId, kind: blockKindData, offset: offset}
+	if cache != nil {
+		if data, exists := cache.get(key); exists {
+			value.Data = data.([]byte)
+			return nil
+		}
 	}
 
-	keyBytes := []byte(key)
+	header, buffer, err := readBorrowed(s.data, offset, dataEntryHeaderSize)
+	if err != nil {
+		return err
+	}
+	// TODO: act on kind of entry (checksum, etc)
+	// kind := header[0]
+	dataLen := int(binary.BigEndian.Uint64(header[1:]))
+	if buffer != nil {
+		putReadBuffer(buffer)
+	}
 
-	indexStart, indexEnd := s.getIndexRange(key)
+	data, buffer, err := readBorrowed(s.data, offset+dataEntryHeaderSize, dataLen)
+	if err != nil {
+		return err
+	}
 
-	kind, dataOffset, exists, err := s.scanIndex(keyBytes, indexStart, indexEnd)
+	if cache != nil && fillCache {
+		owned := make([]byte, len(data))
+		copy(owned, data)
+		if buffer != nil {
+			putReadBuffer(buffer)
+		}
+		cache.insert(key, owned, int64(len(owned)+dataEntryHeaderSize), false)
+		value.Data = owned
+		return nil
+	}
 
+	value.Data = data
+	value.buffer = buffer
+	value.mapped = buffer == nil
+	return nil
+}
+
+// Reads the data entry at offset in the data file, returning data owned by
+// the caller or shared with the block cache.
+func (s *SSTable) getDataEntry(offset int64, fillCache bool) ([]byte, error) {
+	value := Value{}
+	err := s.readDataEntry(offset, fillCache, &value)
 	if err != nil {
-		return 0, nil, false, err
+		return nil, err
+	}
+	data := value.detach()
+	value.Release()
+	return data, nil
+}
+
+// Looks up a key in the table without copying the value. The value borrows
+// memory from the table, a table with borrowed values can't be closed until
+// they're released. Reads where the index, filter and data are in the block
+// cache don't allocate.
+func (s *SSTable) Get(key []byte, value *Value) (bool, error) {
+	value.Release()
+
+	if !s.acquire() {
+		return false, errTableClosed
+	}
+	exists, err := s.get(key, value)
+	if err == nil && value.mapped {
+		value.tbl = s
+		return exists, nil
+	}
+	s.release()
+	return exists, err
+}
+
+// Takes a reference to the files of the table, keeping them open. Returns
+// false if the table is closed.
+func (s *SSTable) acquire() bool {
+	for {
+		refs := s.refs.Load()
+		if refs == 0 {
+			return false
+		}
+		if s.refs.CompareAndSwap(refs, refs+1) {
+			break
+		}
+	}
+	if s.closed.Load() {
+		s.release()
+		return false
+	}
+	return true
+}
+
+// Releases a reference to the files of the table, closing them if it was
+// the last one.
+func (s *SSTable) release() error {
+	if s.refs.Add(-1) == 0 {
+		return s.closeFiles()
+	}
+	return nil
+}
+
+func (s *SSTable) get(key []byte, value *Value) (bool, error) {
+	block := s.getIndexBlock(key)
+	if block < 0 {
+		return false, nil
 	}
 
-	if !exists {
-		return 0, nil, false, nil
+	mayMatch, err := s.keyMayMatch(key, block)
+	if err != nil || !mayMatch {
+		return false, err
 	}
 
-	data, err := s.getDataEntry(dataOffset)
+	record, exists, err := s.findInBlock(key, block)
+	if err != nil || !exists {
+		return false, err
+	}
 
+	value.Kind = record.kind
+	value.ExpiresAt = record.expiresAt
+	value.Seq = record.seq
+	err = s.readDataEntry(record.dataOffset, true, value)
 	if err != nil {
-		return 0, nil, false, err
+		value.Release()
+		return false, err
 	}
+	return true, nil
+}
+
+// Looks up a key in the table. The returned data may be shared with the
+// block cache and must not be modified.
+func (s *SSTable) Read(key string) (uint64, []byte, bool, error) {
+	kind, data, _, exists, err := s.ReadWithExpiry(key)
+	return kind, data, exists, err
+}
 
-	return kind, data, true, nil
+// Looks up a key in the table like Read, also returning the unix time in
+// nanoseconds when the entry expires, 0 if it never does. Expired entries
+// are returned like any other, it's up to the caller to hide them.
+func (s *SSTable) ReadWithExpiry(key string) (uint64, []byte, int64, bool, error) {
+	e, exists, err := s.ReadEntry(key)
+	return e.Kind, e.Data, e.ExpiresAt, exists, err
+}
+
+// Looks up a key in the table like Read, returning the whole entry of the
+// key.
+func (s *SSTable) ReadEntry(key string) (Entry, bool, error) {
+	value := Value{}
+	exists, err := s.Get([]byte(key), &value)
+	if err != nil || !exists {
+		return Entry{}, false, err
+	}
+
+	data := value.detach()
+	value.Release()
+	return Entry{Kind: value.Kind, Data: data, ExpiresAt: value.ExpiresAt, Seq: value.Seq}, true, nil
+}
+
+// Largest sequence number of the entries of the table, 0 if the entries
+// have none.
+func (s *SSTable) MaxSeq() uint64 {
+	return s.meta.MaxSeq
+}
+
+// Id of the key of the key provider the data key of the table is wrapped
+// with, empty if the table isn't encrypted. Tables are encrypted with the
+// current key of the provider when built, so after rotating the key, merges
+// rewrite the data with the new key.
+func (s *SSTable) EncryptionKeyID() string {
+	if s.cipher == nil {
+		return ""
+	}
+	return s.cipher.keyID
 }
 
 func (s *SSTable) Size() (int64, error) {
 	return int64(s.data.Len()), nil
 }
 
+// Closes the table. Its files stay open until reads in progress finish and
+// borrowed values are released, errors closing them then are dropped. A
+// borrowed value that's never released keeps the files open.
 func (s *SSTable) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.closed.Store(true)
+		err = s.release()
+	})
+	return err
+}
+
+func (s *SSTable) closeFiles() error {
+	if s.opts.BlockCache != nil {
+		s.opts.BlockCache.eraseTable(s.cacheId)
+	}
 	err := s.data.Close()
 	err2 := s.index.Close()
+	if s.filterFile != nil {
+		err = errors.Join(err, s.filterFile.Close())
+	}
+	if s.hashIndex != nil {
+		err = errors.Join(err, s.hashIndex.Close())
+	}
 	return errors.Join(err, err2)
 }
 
@@ -263,23 +694,56 @@ func (s *SSTable) NumEntries() int64 {
 	return s.meta.NumEntries
 }
 
+// Range tombstones of the table. The tombstones only apply to keys in older
+// tables, not to the entries of the table itself.
+func (s *SSTable) RangeTombstones() []RangeTombstone {
+	return s.rangeTombstones
+}
+
 func (s *SSTable) Iterator() (*SSTableIterator, error) {
-	it := SSTableIterator{
+	it := &SSTableIterator{
 		tbl:             s,
-		key:             nil,
-		value:           nil,
 		nextIndexOffset: 0,
 	}
 	return it.Next()
 }
 
+// Returns an iterator positioned at the first key >= key. Returns nil and
+// io.EOF if there is no such key.
+func (s *SSTable) Seek(key string) (*SSTableIterator, error) {
+	start, _ := s.getIndexRange(s.getIndexBlock([]byte(key)))
+	it := &SSTableIterator{
+		tbl:             s,
+		nextIndexOffset: start,
+	}
+
+	for {
+		_, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if s.cmp.Compare(keyString(it.key), key) >= 0 {
+			return it, nil
+		}
+	}
+}
+
 func (s *SSTable) Delete() error {
-	s.data.Close()
-	s.index.Close()
-	os.Remove(path.Join(s.root, s.name+bloomFilterFileExtension))
-	os.Remove(path.Join(s.root, s.name+dataFileExtension))
-	os.Remove(path.Join(s.root, s.name+indexFileExtension))
-	os.Remove(path.Join(s.root, s.name+sparseIndexFileExtension))
-	os.Remove(path.Join(s.root, s.name+metadataFileExtension))
+	s.Close()
+	for _, ext := range fileExtensions {
+		os.Remove(path.Join(s.root, s.name+ext))
+	}
+	return nil
+}
+
+// Moves the files of a table that isn't loaded to a new directory and name.
+// Optional files that don't exist are skipped.
+func MoveSSTable(root string, name string, newRoot string, newName string) error {
+	for _, ext := range fileExtensions {
+		err := os.Rename(path.Join(root, name+ext), path.Join(newRoot, newName+ext))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	return nil
 }
@@ -1,18 +1,10 @@
 package sstable
 
 import (
-	"bufio"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
-	"os"
-	"path"
-
-	"github.com/bits-and-blooms/bloom/v3"
 )
 
-const bloomFalsePositiveRate = 0.01
-
 const (
 	dataEntry     byte = 0x01
 	checksumEntry byte = 0x13
@@ -26,19 +18,15 @@ const defaultSparseIndexBlockSize = 8 * 1024
 type SSTableBuilder struct {
 	// Used to quickly filter queries for elements that definitely does not exist
 	// in the table.
-	filter *bloom.BloomFilter
-	// Handle to the file where data entries are stored
-	data *os.File
-	// Buffered writer
-	dataWriter *bufio.Writer
+	filter *filterBuilder
+	// File where data entries are stored
+	data *tableFileWriter
 	// Position in the data stream where writing of next element begins. Used for
 	// writing only.
 	dataPosition int64
-	// Handle to the file where the full index is stored. The index associates
-	// keys with values in the data file.
-	index *os.File
-	// Buffered writer
-	indexWriter *bufio.Writer
+	// File where the full index is stored. The index associates keys with
+	// values in the data file.
+	index *tableFileWriter
 	// Position in the index stream where writing of the next element begins. Used
 	// for writing only.
 	indexPosition int64
@@ -54,34 +42,70 @@ type SSTableBuilder struct {
 	built bool
 	// The last key that was added to the SSTable, used to detect out-of-order writes.
 	previousKey string
+	// The first key that was added to the SSTable
+	firstKey string
 	// Root directory of SSTables
 	root string
 	// Name of this SSTable
 	name string
 	// Metadata
 	meta SSTableMetaData
+	// Range tombstones to write to the range-del block
+	rangeTombstones []RangeTombstone
+	// Options used to load the table once built
+	opts Options
+	// Order of the keys
+	cmp Comparator
+	// Builds the hash index of the index blocks, nil if disabled
+	hashIndex *hashIndexBuilder
+	// Collects the properties of the table
+	properties *propertiesBuilder
+	// Encrypts the files of the table, nil if it isn't encrypted
+	cipher *tableCipher
 }
 
 // Creates a new SSTableBuilder. numElements is the approximate number of elements that will be stored,
-// it's used to set up the bloom filter.
-func NewSSTable(numElements uint, root string, name string) (*SSTableBuilder, error) {
-	data, err := os.Create(path.Join(root, name+dataFileExtension))
+// it's used to set up the filter.
+func NewSSTable(numElements uint, root string, name string, opts Options) (*SSTableBuilder, error) {
+	var cipher *tableCipher
+	if opts.KeyProvider != nil {
+		var err error
+		cipher, err = newTableCipher(root, name, opts.KeyProvider)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := createTableFile(root, name, dataFileExtension, cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := createTableFile(root, name, indexFileExtension, cipher)
 	if err != nil {
 		return nil, err
 	}
 
-	index, err := os.Create(path.Join(root, name+indexFileExtension))
+	filter, err := newFilterBuilder(numElements, root, name, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	var hashIndex *hashIndexBuilder
+	if opts.HashIndex {
+		hashIndex, err = newHashIndexBuilder(root, name, cipher)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &SSTableBuilder{
-		filter:               bloom.NewWithEstimates(numElements, bloomFalsePositiveRate),
+		hashIndex:            hashIndex,
+		properties:           newPropertiesBuilder(opts.PropertyCollectors),
+		filter:               filter,
 		data:                 data,
-		dataWriter:           bufio.NewWriter(data),
 		dataPosition:         0,
 		index:                index,
-		indexWriter:          bufio.NewWriter(index),
 		indexPosition:        0,
 		sparseIndex:          sparseIndex{},
 		sparseIndexBlockSize: defaultSparseIndexBlockSize,
@@ -89,62 +113,28 @@ func NewSSTable(numElements uint, root string, name string) (*SSTableBuilder, er
 		root:                 root,
 		name:                 name,
 		built:                false,
-		meta:                 SSTableMetaData{NumEntries: 0},
+		meta:                 SSTableMetaData{NumEntries: 0, IndexVersion: indexVersionSeq, Comparator: opts.comparator().Name()},
+		opts:                 opts,
+		cmp:                  opts.comparator(),
+		cipher:               cipher,
 	}, nil
 }
 
-func (s *SSTableBuilder) saveBloomFilter() error {
-	file, err := os.Create(path.Join(s.root, s.name+bloomFilterFileExtension))
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	_, err = s.filter.WriteTo(file)
-	return err
-}
-
 func (s *SSTableBuilder) saveSparseIndex() error {
-	file, err := os.Create(path.Join(s.root, s.name+sparseIndexFileExtension))
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-
-	err = encoder.Encode(s.sparseIndex)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return saveTableJson(s.root, s.name, sparseIndexFileExtension, s.cipher, s.sparseIndex)
 }
 
 func (s *SSTableBuilder) saveMetadata() error {
-	file, err := os.Create(path.Join(s.root, s.name+metadataFileExtension))
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-
-	err = encoder.Encode(s.meta)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return saveTableJson(s.root, s.name, metadataFileExtension, s.cipher, s.meta)
 }
 
-func (s *SSTableBuilder) writeIndexEntry(key []byte, kind uint64) (int64, error) {
+func (s *SSTableBuilder) writeIndexEntry(key []byte, e Entry) (int64, error) {
 	bytesWritten := int64(0)
 	numBuf := make([]byte, 8)
 
 	// Write kind of entry
-	binary.BigEndian.PutUint64(numBuf, kind)
-	n, err := s.indexWriter.Write(numBuf)
+	binary.BigEndian.PutUint64(numBuf, e.Kind)
+	n, err := s.index.Write(numBuf)
 	if err != nil {
 		return 0, err
 	}
@@ -153,14 +143,14 @@ func (s *SSTableBuilder) writeIndexEntry(key []byte, kind uint64) (int64, error)
 	// Write size of key
 	keyLen := len(key)
 	binary.BigEndian.PutUint64(numBuf, uint64(keyLen))
-	n, err = s.indexWriter.Write(numBuf)
+	n, err = s.index.Write(numBuf)
 	if err != nil {
 		return 0, err
 	}
 	bytesWritten += int64(n)
 
 	// Write key
-	n, err = s.indexWriter.Write(key)
+	n, err = s.index.Write(key)
 	if err != nil {
 		return 0, err
 	}
@@ -168,7 +158,23 @@ func (s *SSTableBuilder) writeIndexEntry(key []byte, kind uint64) (int64, error)
 
 	// Write offset in data file where data will be written
 	binary.BigEndian.PutUint64(numBuf, uint64(s.dataPosition))
-	n, err = s.indexWriter.Write(numBuf)
+	n, err = s.index.Write(numBuf)
+	if err != nil {
+		return 0, err
+	}
+	bytesWritten += int64(n)
+
+	// Write time when the entry expires
+	binary.BigEndian.PutUint64(numBuf, uint64(e.ExpiresAt))
+	n, err = s.index.Write(numBuf)
+	if err != nil {
+		return 0, err
+	}
+	bytesWritten += int64(n)
+
+	// Write sequence number
+	binary.BigEndian.PutUint64(numBuf, e.Seq)
+	n, err = s.index.Write(numBuf)
 	if err != nil {
 		return 0, err
 	}
@@ -181,7 +187,7 @@ func (s *SSTableBuilder) writeDataEntry(data []byte) (int64, error) {
 	bytesWritten := int64(0)
 	numBuf := make([]byte, 8)
 
-	n, err := s.dataWriter.Write([]byte{dataEntry})
+	n, err := s.data.Write([]byte{dataEntry})
 	if err != nil {
 		return 0, err
 	}
@@ -190,14 +196,14 @@ func (s *SSTableBuilder) writeDataEntry(data []byte) (int64, error) {
 	// Write size of data
 	dataLen := len(data)
 	binary.BigEndian.PutUint64(numBuf, uint64(dataLen))
-	n, err = s.dataWriter.Write(numBuf)
+	n, err = s.data.Write(numBuf)
 	if err != nil {
 		return 0, err
 	}
 	bytesWritten += int64(n)
 
 	// Write the data
-	n, err = s.dataWriter.Write(data)
+	n, err = s.data.Write(data)
 	if err != nil {
 		return 0, err
 	}
@@ -216,44 +222,95 @@ func (s *SSTableBuilder) currentSparseIndexBlockSize() int64 {
 	}
 }
 
-// Writes a new entry to the SSTable. Entries must be added in ascending order. The table
+// Completes the last index block. Every index block has its own filter
+// partition and hash table.
+func (s *SSTableBuilder) finishBlock() error {
+	if err := s.filter.finishPartition(); err != nil {
+		return err
+	}
+	if s.hashIndex != nil {
+		return s.hashIndex.finishBlock(&s.sparseIndex[len(s.sparseIndex)-1])
+	}
+	return nil
+}
+
+// Writes a new entry to the SSTable. Entries must be added in ascending order, as given by
+// the comparator of the table. The table
 // cannot have been built. A table loaded from disk cannot have additional entries added.
 func (s *SSTableBuilder) Write(key string, kind uint64, data []byte) error {
+	return s.WriteWithExpiry(key, kind, data, 0)
+}
+
+// Writes an entry that expires at the given unix time in nanoseconds, 0 if it
+// never expires. The expiry is stored in the index entry of the key.
+func (s *SSTableBuilder) WriteWithExpiry(key string, kind uint64, data []byte, expiresAt int64) error {
+	return s.WriteEntry(key, Entry{Kind: kind, Data: data, ExpiresAt: expiresAt})
+}
+
+// Writes an entry along with its expiry time and sequence number, which are
+// stored in the index entry of the key.
+func (s *SSTableBuilder) WriteEntry(key string, e Entry) error {
 	if s.built {
 		return errors.New("cannot write to a built SSTable, data structure is immutable")
 	}
 
-	if s.previousKey > key {
+	if s.meta.NumEntries > 0 && s.cmp.Compare(s.previousKey, key) > 0 {
 		return errors.New("must add keys in ascending order to SSTable")
 	}
 	s.previousKey = key
+	if s.meta.NumEntries == 0 {
+		s.firstKey = key
+	}
 
 	if s.sparseIndexBlockSize <= s.currentSparseIndexBlockSize() {
+		if len(s.sparseIndex) > 0 {
+			if err := s.finishBlock(); err != nil {
+				return err
+			}
+		}
 		s.sparseIndex = append(s.sparseIndex, indexEntry{
-			Key:    key,
+			Key:    jsonKey(key),
 			Offset: s.indexPosition,
 		})
 	}
 
 	keyBytes := []byte(key)
-	indexBytesWritten, err := s.writeIndexEntry(keyBytes, kind)
+	if s.hashIndex != nil {
+		block := s.sparseIndex[len(s.sparseIndex)-1]
+		s.hashIndex.add(keyBytes, s.indexPosition-block.Offset)
+	}
+
+	indexBytesWritten, err := s.writeIndexEntry(keyBytes, e)
 	if err != nil {
 		return err
 	}
 	s.indexPosition += indexBytesWritten
 
-	dataBytesWritten, err := s.writeDataEntry(data)
+	dataBytesWritten, err := s.writeDataEntry(e.Data)
 	if err != nil {
 		return err
 	}
 	s.dataPosition += int64(dataBytesWritten)
 
-	s.filter.Add(keyBytes)
+	s.filter.add(keyBytes)
+	s.properties.add(key, e.Kind, e.Data)
 	s.meta.NumEntries += 1
+	if e.Seq > s.meta.MaxSeq {
+		s.meta.MaxSeq = e.Seq
+	}
 
 	return nil
 }
 
+// Adds a range tombstone to the table. Tombstones can be added in any order.
+func (s *SSTableBuilder) AddRangeTombstone(tombstone RangeTombstone) error {
+	if s.built {
+		return errors.New("cannot write to a built SSTable, data structure is immutable")
+	}
+	s.rangeTombstones = append(s.rangeTombstones, tombstone)
+	return nil
+}
+
 // Saves everything to disk and returns a new SSTable
 // ready for reading.
 func (s *SSTableBuilder) Build() (*SSTable, error) {
@@ -261,37 +318,51 @@ func (s *SSTableBuilder) Build() (*SSTable, error) {
 		return nil, errors.New("sstable already built")
 	}
 
-	if err := s.saveBloomFilter(); err != nil {
+	if len(s.sparseIndex) > 0 {
+		if err := s.finishBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.filter.save(s.root, s.name); err != nil {
 		return nil, err
 	}
+	if s.hashIndex != nil {
+		if err := s.hashIndex.save(); err != nil {
+			return nil, err
+		}
+		s.meta.HashIndex = true
+	}
+	s.filter.setMetadata(&s.meta)
 
-	if err := s.saveSparseIndex(); err != nil {
-		return nil, err
+	if s.meta.NumEntries > 0 {
+		s.meta.KeyRange = extendKeyRange(s.meta.KeyRange, s.firstKey, s.previousKey, s.cmp)
+	}
+	for _, t := range s.rangeTombstones {
+		s.meta.KeyRange = extendKeyRange(s.meta.KeyRange, t.Start, t.End, s.cmp)
 	}
+	s.meta.HasKeyRange = true
+	s.meta.Properties = s.properties.finish(len(s.rangeTombstones))
 
-	if err := s.saveMetadata(); err != nil {
+	if err := s.saveSparseIndex(); err != nil {
 		return nil, err
 	}
 
-	if err := s.dataWriter.Flush(); err != nil {
+	if err := s.saveMetadata(); err != nil {
 		return nil, err
 	}
 
-	if err := s.indexWriter.Flush(); err != nil {
+	if err := saveRangeTombstones(s.root, s.name, s.cipher, s.rangeTombstones); err != nil {
 		return nil, err
 	}
 
-	if err := s.data.Sync(); err != nil {
+	if err := s.data.finish(); err != nil {
 		return nil, err
 	}
-	if err := s.index.Sync(); err != nil {
+	if err := s.index.finish(); err != nil {
 		return nil, err
 	}
 
 	s.built = true
 
-	s.data.Close()
-	s.index.Close()
-
-	return LoadSSTable(s.root, s.name)
+	return LoadSSTable(s.root, s.name, s.opts)
 }
@@ -0,0 +1,254 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// Maximum number of seeds to try before giving up on building a filter. Each
+// attempt fails with a low probability, so this is only reached if something
+// is wrong with the input.
+const xorFilterMaxAttempts = 100
+
+// Size of the serialized header: seed (8 bytes) | block length (4 bytes) |
+// fingerprint width (1 byte)
+const xorFilterHeaderSize = 8 + 4 + 1
+
+// A static filter as described in "Xor Filters: Faster and Smaller Than Bloom
+// and Cuckoo Filters" by Graf and Lemire. Every key maps to three slots, one
+// in each third of the table, and the fingerprint of the key is the xor of
+// the three slots. It uses about 1.23 * fingerprint bits per key, compared to
+// 1.44 * log2(1/fp) bits for a bloom filter, but all keys must be known
+// when it's built.
+type xorFilter struct {
+	seed        uint64
+	blockLength uint32
+	// Fingerprint width in bytes, gives a false positive rate of 2^-(8*width)
+	width        int
+	fingerprints []byte
+}
+
+// Collects the hashes of the keys of a xor filter until it's built.
+type xorFilterWriter struct {
+	width  int
+	hashes []uint64
+}
+
+func newXorFilterWriter(numElements uint, falsePositiveRate float64) *xorFilterWriter {
+	// Use the smallest supported width that reaches the false positive rate
+	width := 1
+	for width < 4 && math.Pow(2, float64(-8*width)) > falsePositiveRate {
+		width *= 2
+	}
+	return &xorFilterWriter{
+		width:  width,
+		hashes: make([]uint64, 0, numElements),
+	}
+}
+
+// FNV-1a, computed inline since hash/fnv allocates its state.
+func hashKey(key []byte) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, b := range key {
+		hash ^= uint64(b)
+		hash *= 1099511628211
+	}
+	return hash
+}
+
+// The finalizer of murmur3, spreads the bits of the key hash depending on the
+// seed.
+func mixSplit(hash uint64, seed uint64) uint64 {
+	h := hash + seed
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Maps a 32 bit value to [0, n) without division.
+func reduce(hash uint32, n uint32) uint32 {
+	return uint32((uint64(hash) * uint64(n)) >> 32)
+}
+
+func (w *xorFilterWriter) add(key []byte) {
+	w.hashes = append(w.hashes, hashKey(key))
+}
+
+func (w *xorFilterWriter) build() (Filter, error) {
+	// Duplicate keys would never peel, they always share all three slots
+	sort.Slice(w.hashes, func(i, j int) bool { return w.hashes[i] < w.hashes[j] })
+	unique := w.hashes[:0]
+	for i, h := range w.hashes {
+		if i == 0 || h != w.hashes[i-1] {
+			unique = append(unique, h)
+		}
+	}
+
+	size := 32 + uint32(math.Ceil(1.23*float64(len(unique))))
+	f := &xorFilter{
+		blockLength: size / 3,
+		width:       w.width,
+	}
+	capacity := 3 * f.blockLength
+	f.fingerprints = make([]byte, int(capacity)*f.width)
+
+	// Number of keys mapped to each slot, and the xor of their hashes
+	counts := make([]uint8, capacity)
+	xors := make([]uint64, capacity)
+	queue := make([]uint32, 0, capacity)
+	type peeled struct {
+		hash uint64
+		slot uint32
+	}
+	stack := make([]peeled, 0, len(unique))
+
+	for attempt := uint64(0); attempt < xorFilterMaxAttempts; attempt++ {
+		f.seed = mixSplit(attempt, 0x9e3779b97f4a7c15)
+		for i := range counts {
+			counts[i] = 0
+			xors[i] = 0
+		}
+		queue = queue[:0]
+		stack = stack[:0]
+
+		for _, key := range unique {
+			hash := mixSplit(key, f.seed)
+			for _, slot := range f.slots(hash) {
+				counts[slot] += 1
+				xors[slot] ^= hash
+			}
+		}
+
+		for slot, count := range counts {
+			if count == 1 {
+				queue = append(queue, uint32(slot))
+			}
+		}
+
+		// Repeatedly remove keys that are alone in one of their slots. That
+		// slot is where the fingerprint of the key is stored.
+		for len(queue) > 0 {
+			slot := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			if counts[slot] != 1 {
+				continue
+			}
+			hash := xors[slot]
+			stack = append(stack, peeled{hash, slot})
+			for _, s := range f.slots(hash) {
+				counts[s] -= 1
+				xors[s] ^= hash
+				if counts[s] == 1 {
+					queue = append(queue, s)
+				}
+			}
+		}
+
+		if len(stack) == len(unique) {
+			break
+		}
+	}
+	if len(stack) != len(unique) {
+		return nil, errors.New("failed to build xor filter")
+	}
+
+	// Assign the fingerprints in reverse order, so that the other two slots
+	// of every key are final when its own slot is set
+	for i := len(stack) - 1; i >= 0; i-- {
+		p := stack[i]
+		slots := f.slots(p.hash)
+		fp := f.fingerprint(p.hash) ^ f.get(slots[0]) ^ f.get(slots[1]) ^ f.get(slots[2])
+		f.set(p.slot, fp)
+	}
+	return f, nil
+}
+
+func (f *xorFilter) slots(hash uint64) [3]uint32 {
+	return [3]uint32{
+		reduce(uint32(hash), f.blockLength),
+		reduce(uint32(bits.RotateLeft64(hash, 21)), f.blockLength) + f.blockLength,
+		reduce(uint32(bits.RotateLeft64(hash, 42)), f.blockLength) + 2*f.blockLength,
+	}
+}
+
+func (f *xorFilter) fingerprint(hash uint64) uint32 {
+	fp := hash ^ (hash >> 32)
+	return uint32(fp) & (math.MaxUint32 >> (32 - 8*f.width))
+}
+
+func (f *xorFilter) get(slot uint32) uint32 {
+	b := f.fingerprints[int(slot)*f.width:]
+	switch f.width {
+	case 1:
+		return uint32(b[0])
+	case 2:
+		return uint32(binary.BigEndian.Uint16(b))
+	default:
+		return binary.BigEndian.Uint32(b)
+	}
+}
+
+func (f *xorFilter) set(slot uint32, fp uint32) {
+	b := f.fingerprints[int(slot)*f.width:]
+	switch f.width {
+	case 1:
+		b[0] = byte(fp)
+	case 2:
+		binary.BigEndian.PutUint16(b, uint16(fp))
+	default:
+		binary.BigEndian.PutUint32(b, fp)
+	}
+}
+
+func (f *xorFilter) MayContain(key []byte) bool {
+	hash := mixSplit(hashKey(key), f.seed)
+	slots := f.slots(hash)
+	return f.fingerprint(hash) == f.get(slots[0])^f.get(slots[1])^f.get(slots[2])
+}
+
+func (f *xorFilter) Size() int64 {
+	return int64(len(f.fingerprints))
+}
+
+func (f *xorFilter) WriteTo(w io.Writer) (int64, error) {
+	header := [xorFilterHeaderSize]byte{}
+	binary.BigEndian.PutUint64(header[0:8], f.seed)
+	binary.BigEndian.PutUint32(header[8:12], f.blockLength)
+	header[12] = byte(f.width)
+
+	n, err := w.Write(header[:])
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(f.fingerprints)
+	return int64(n + m), err
+}
+
+func readXorFilter(r io.Reader) (*xorFilter, error) {
+	header := [xorFilterHeaderSize]byte{}
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	f := &xorFilter{
+		seed:        binary.BigEndian.Uint64(header[0:8]),
+		blockLength: binary.BigEndian.Uint32(header[8:12]),
+		width:       int(header[12]),
+	}
+	if f.width != 1 && f.width != 2 && f.width != 4 {
+		return nil, errors.New("invalid xor filter fingerprint width")
+	}
+
+	f.fingerprints = make([]byte, int(3*f.blockLength)*f.width)
+	if _, err := io.ReadFull(r, f.fingerprints); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,100 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildXorFilter(t *T, numKeys int, falsePositiveRate float64) Filter {
+	w := newXorFilterWriter(uint(numKeys), falsePositiveRate)
+	for i := 0; i < numKeys; i++ {
+		w.add([]byte(fmt.Sprintf("key%d", i)))
+	}
+	filter, err := w.build()
+	assert.Nil(t, err)
+	return filter
+}
+
+func TestXorFilterContainsAllKeys(t *T) {
+	filter := buildXorFilter(t, 10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		assert.True(t, filter.MayContain([]byte(fmt.Sprintf("key%d", i))))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.MayContain([]byte(fmt.Sprintf("other%d", i))) {
+			falsePositives += 1
+		}
+	}
+	// 8 bit fingerprints give about 0.4% false positives
+	assert.Less(t, falsePositives, 100)
+	assert.Less(t, filter.Size(), int64(10000*1.25)+32, "should use about 1.23 bytes per key")
+}
+
+func TestXorFilterWidth(t *T) {
+	assert.Equal(t, 1, newXorFilterWriter(0, 0.01).width)
+	assert.Equal(t, 2, newXorFilterWriter(0, 0.0001).width)
+	assert.Equal(t, 4, newXorFilterWriter(0, 0.00000001).width)
+}
+
+func TestXorFilterHandlesDuplicatesAndEmpty(t *T) {
+	w := newXorFilterWriter(3, 0.01)
+	w.add([]byte("a"))
+	w.add([]byte("a"))
+	w.add([]byte("b"))
+	filter, err := w.build()
+	assert.Nil(t, err)
+	assert.True(t, filter.MayContain([]byte("a")))
+	assert.True(t, filter.MayContain([]byte("b")))
+
+	_, err = newXorFilterWriter(0, 0.01).build()
+	assert.Nil(t, err)
+}
+
+func TestXorFilterSerialization(t *T) {
+	filter := buildXorFilter(t, 1000, 0.0001)
+	buf := bytes.Buffer{}
+	_, err := filter.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	read, err := readFilter(XorFilterType, &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, filter, read)
+}
+
+func TestTableWithXorFilter(t *T) {
+	root := t.TempDir()
+	keys := []string{}
+	for i := 0; i < 2000; i++ {
+		keys = append(keys, fmt.Sprintf("key:%05d", i))
+	}
+
+	for _, partitioned := range []bool{false, true} {
+		name := fmt.Sprintf("xor-%v", partitioned)
+		tbl := buildTable(t, root, name, keys, Options{
+			FilterType:              XorFilterType,
+			FilterFalsePositiveRate: 0.0001,
+			PartitionedFilters:      partitioned,
+		})
+		assert.Nil(t, tbl.Close())
+
+		// The filter type is read from the table, not the options
+		tbl, err := LoadSSTable(root, name, Options{})
+		assert.Nil(t, err)
+		assert.Equal(t, XorFilterType, tbl.meta.FilterType)
+
+		_, data, exists, err := tbl.Read("key:01234")
+		assert.Nil(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte("value-key:01234"), data)
+
+		_, _, exists, err = tbl.Read("key:01234x")
+		assert.Nil(t, err)
+		assert.False(t, exists)
+		assert.Nil(t, tbl.Close())
+	}
+}
@@ -0,0 +1,366 @@
+package vlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const fileExtension = ".vlog"
+
+// Size of the key and value lengths preceding every record
+const recordHeaderSize = 8 + 8
+
+const encodedPointerSize = 4 + 8 + 4
+
+// Points to a value stored in a value log file.
+type ValuePointer struct {
+	File   uint32
+	Offset int64
+	Length uint32
+}
+
+func (p ValuePointer) Encode() []byte {
+	buf := make([]byte, encodedPointerSize)
+	binary.BigEndian.PutUint32(buf[0:4], p.File)
+	binary.BigEndian.PutUint64(buf[4:12], uint64(p.Offset))
+	binary.BigEndian.PutUint32(buf[12:16], p.Length)
+	return buf
+}
+
+func DecodeValuePointer(data []byte) (ValuePointer, error) {
+	if len(data) != encodedPointerSize {
+		return ValuePointer{}, errors.New("invalid value pointer")
+	}
+	return ValuePointer{
+		File:   binary.BigEndian.Uint32(data[0:4]),
+		Offset: int64(binary.BigEndian.Uint64(data[4:12])),
+		Length: binary.BigEndian.Uint32(data[12:16]),
+	}, nil
+}
+
+type logFile struct {
+	num  uint32
+	file *os.File
+	size int64
+}
+
+// A value log stores large values outside of the LsmTree, so they don't have
+// to be rewritten on every merge. The log is split into numbered files, new
+// values are always appended to the newest one, the head. Each record in a
+// file is stored as:
+//
+//	key length (8 bytes) | value length (8 bytes) | key | value
+type ValueLog struct {
+	dir         string
+	maxFileSize int64
+	lock        sync.Mutex
+	files       map[uint32]*logFile
+	head        *logFile
+	writer      *bufio.Writer
+	// Number of readers that may still hold pointers into removed files.
+	readers int
+	// Files that have been removed while there were readers, deleted once
+	// the last reader is done.
+	obsolete []*logFile
+}
+
+func fileName(dir string, num uint32) string {
+	return path.Join(dir, fmt.Sprintf("%06d%v", num, fileExtension))
+}
+
+// Opens the value log in dir, creating it if it doesn't exist. Files are
+// rotated once they grow beyond maxFileSize.
+func Open(dir string, maxFileSize int64) (*ValueLog, error) {
+	err := os.MkdirAll(dir, 0770)
+	if err != nil {
+		return nil, err
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	nums := []uint32{}
+	for _, e := range dirEntries {
+		name := e.Name()
+		if !strings.HasSuffix(name, fileExtension) {
+			continue
+		}
+		num, err := strconv.ParseUint(strings.TrimSuffix(name, fileExtension), 10, 32)
+		if err != nil {
+			continue
+		}
+		nums = append(nums, uint32(num))
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	v := &ValueLog{
+		dir:         dir,
+		maxFileSize: maxFileSize,
+		files:       make(map[uint32]*logFile),
+	}
+
+	for _, num := range nums {
+		file, err := os.Open(fileName(dir, num))
+		if err != nil {
+			v.Close()
+			return nil, err
+		}
+		v.files[num] = &logFile{num: num, file: file}
+	}
+
+	// Always start writing to a new file, the tail of the previous head may
+	// be a partially written record.
+	nextNum := uint32(1)
+	if len(nums) > 0 {
+		nextNum = nums[len(nums)-1] + 1
+	}
+	err = v.openHead(nextNum)
+	if err != nil {
+		v.Close()
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func (v *ValueLog) openHead(num uint32) error {
+	file, err := os.OpenFile(fileName(v.dir, num), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	v.head = &logFile{num: num, file: file}
+	v.files[num] = v.head
+	v.writer = bufio.NewWriter(file)
+	return nil
+}
+
+// Appends a value to the head of the log. The value can't be read back
+// until Sync has been called.
+func (v *ValueLog) Append(key []byte, value []byte) (ValuePointer, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.head.size > 0 && v.head.size+recordHeaderSize+int64(len(key)+len(value)) > v.maxFileSize {
+		if err := v.rotate(); err != nil {
+			return ValuePointer{}, err
+		}
+	}
+
+	header := [recordHeaderSize]byte{}
+	binary.BigEndian.PutUint64(header[0:8], uint64(len(key)))
+	binary.BigEndian.PutUint64(header[8:16], uint64(len(value)))
+
+	for _, b := range [][]byte{header[:], key, value} {
+		if _, err := v.writer.Write(b); err != nil {
+			return ValuePointer{}, err
+		}
+	}
+
+	ptr := ValuePointer{
+		File:   v.head.num,
+		Offset: v.head.size + recordHeaderSize + int64(len(key)),
+		Length: uint32(len(value)),
+	}
+	v.head.size += recordHeaderSize + int64(len(key)+len(value))
+	return ptr, nil
+}
+
+// Starts writing to a new head file, the previous head becomes read only.
+func (v *ValueLog) rotate() error {
+	if err := v.syncHead(); err != nil {
+		return err
+	}
+	return v.openHead(v.head.num + 1)
+}
+
+// Makes every appended value durable and readable.
+func (v *ValueLog) Sync() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.syncHead()
+}
+
+func (v *ValueLog) syncHead() error {
+	if err := v.writer.Flush(); err != nil {
+		return err
+	}
+	return v.head.file.Sync()
+}
+
+func (v *ValueLog) findFile(num uint32) *logFile {
+	if f, exists := v.files[num]; exists {
+		return f
+	}
+	for _, f := range v.obsolete {
+		if f.num == num {
+			return f
+		}
+	}
+	return nil
+}
+
+// Reads the value a pointer points to. The caller must have acquired the log
+// before reading the pointer to make sure the file isn't deleted.
+func (v *ValueLog) Read(ptr ValuePointer) ([]byte, error) {
+	v.lock.Lock()
+	f := v.findFile(ptr.File)
+	v.lock.Unlock()
+
+	if f == nil {
+		return nil, fmt.Errorf("value log file %v does not exist", ptr.File)
+	}
+
+	data := make([]byte, ptr.Length)
+	_, err := f.file.ReadAt(data, ptr.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Registers a reader of the log. Files removed while there are readers are
+// kept on disk until every reader has called the returned release function.
+func (v *ValueLog) Acquire() func() {
+	v.lock.Lock()
+	v.readers += 1
+	v.lock.Unlock()
+
+	return func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		v.readers -= 1
+		if v.readers == 0 {
+			for _, f := range v.obsolete {
+				f.file.Close()
+				os.Remove(f.file.Name())
+			}
+			v.obsolete = nil
+		}
+	}
+}
+
+// Returns the oldest file of the log. If the head is the only file and it
+// contains values, the head is rotated so that it can be collected.
+func (v *ValueLog) OldestFile() (uint32, bool, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if len(v.files) == 1 {
+		if v.head.size == 0 {
+			return 0, false, nil
+		}
+		if err := v.rotate(); err != nil {
+			return 0, false, err
+		}
+	}
+
+	oldest := v.head.num
+	for num := range v.files {
+		if num < oldest {
+			oldest = num
+		}
+	}
+	return oldest, true, nil
+}
+
+// Calls fn for every record of a file that isn't the head, in the order they
+// were written.
+func (v *ValueLog) Iterate(num uint32, fn func(key []byte, ptr ValuePointer) error) error {
+	v.lock.Lock()
+	f := v.files[num]
+	isHead := f == v.head
+	v.lock.Unlock()
+
+	if f == nil {
+		return fmt.Errorf("value log file %v does not exist", num)
+	}
+	if isHead {
+		return errors.New("cannot iterate the head of the value log")
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f.file, 0, 1<<62))
+	offset := int64(0)
+	header := [recordHeaderSize]byte{}
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A partially written record at the end is from a crash while
+			// appending, nothing can point to it.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		keyLen := int64(binary.BigEndian.Uint64(header[0:8]))
+		valueLen := int64(binary.BigEndian.Uint64(header[8:16]))
+		key := make([]byte, keyLen)
+		if _, err = io.ReadFull(r, key); err != nil {
+			return nil
+		}
+		if _, err = r.Discard(int(valueLen)); err != nil {
+			return nil
+		}
+
+		ptr := ValuePointer{
+			File:   num,
+			Offset: offset + recordHeaderSize + keyLen,
+			Length: uint32(valueLen),
+		}
+		if err = fn(key, ptr); err != nil {
+			return err
+		}
+		offset += recordHeaderSize + keyLen + valueLen
+	}
+}
+
+// Removes a file that isn't the head from the log. The file is deleted from
+// disk once there are no readers left.
+func (v *ValueLog) RemoveFile(num uint32) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	f := v.files[num]
+	if f == nil {
+		return nil
+	}
+	if f == v.head {
+		return errors.New("cannot remove the head of the value log")
+	}
+	delete(v.files, num)
+
+	if v.readers > 0 {
+		v.obsolete = append(v.obsolete, f)
+		return nil
+	}
+	f.file.Close()
+	return os.Remove(f.file.Name())
+}
+
+func (v *ValueLog) Close() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var errs []error
+	if v.head != nil {
+		errs = append(errs, v.syncHead())
+	}
+	for _, f := range v.files {
+		errs = append(errs, f.file.Close())
+	}
+	for _, f := range v.obsolete {
+		errs = append(errs, f.file.Close())
+	}
+	return errors.Join(errs...)
+}
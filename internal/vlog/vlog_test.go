@@ -0,0 +1,108 @@
+package vlog
+
+import (
+	"os"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendAndRead(t *T) {
+	v, err := Open(t.TempDir(), 1024)
+	assert.Nil(t, err)
+	defer v.Close()
+
+	p1, err := v.Append([]byte("key1"), []byte("value1"))
+	assert.Nil(t, err)
+	p2, err := v.Append([]byte("key2"), []byte("value2"))
+	assert.Nil(t, err)
+	assert.Nil(t, v.Sync())
+
+	data, err := v.Read(p1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value1"), data)
+
+	data, err = v.Read(p2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value2"), data)
+}
+
+func TestPointerEncoding(t *T) {
+	p := ValuePointer{File: 3, Offset: 12345, Length: 42}
+	decoded, err := DecodeValuePointer(p.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, p, decoded)
+
+	_, err = DecodeValuePointer([]byte("short"))
+	assert.NotNil(t, err)
+}
+
+func TestRotatesAndIterates(t *T) {
+	v, err := Open(t.TempDir(), 64)
+	assert.Nil(t, err)
+	defer v.Close()
+
+	ptrs := []ValuePointer{}
+	for _, k := range []string{"a", "b", "c", "d"} {
+		p, err := v.Append([]byte(k), []byte("0123456789012345678901234567890"))
+		assert.Nil(t, err)
+		ptrs = append(ptrs, p)
+	}
+	assert.Nil(t, v.Sync())
+	assert.NotEqual(t, ptrs[0].File, ptrs[3].File, "should have rotated")
+
+	oldest, exists, err := v.OldestFile()
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, ptrs[0].File, oldest)
+
+	keys := []string{}
+	err = v.Iterate(oldest, func(key []byte, ptr ValuePointer) error {
+		keys = append(keys, string(key))
+		assert.Equal(t, ptrs[len(keys)-1], ptr)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+}
+
+func TestRemoveWaitsForReaders(t *T) {
+	dir := t.TempDir()
+	v, err := Open(dir, 32)
+	assert.Nil(t, err)
+	defer v.Close()
+
+	p, _ := v.Append([]byte("a"), []byte("01234567890123456789"))
+	v.Append([]byte("b"), []byte("01234567890123456789"))
+	assert.Nil(t, v.Sync())
+
+	release := v.Acquire()
+	assert.Nil(t, v.RemoveFile(p.File))
+
+	data, err := v.Read(p)
+	assert.Nil(t, err, "removed file should be readable while acquired")
+	assert.Equal(t, []byte("01234567890123456789"), data)
+
+	release()
+	_, err = os.Stat(fileName(dir, p.File))
+	assert.True(t, os.IsNotExist(err), "file should be deleted after release")
+}
+
+func TestReopenContinuesAfterExistingFiles(t *T) {
+	dir := t.TempDir()
+	v, err := Open(dir, 1024)
+	assert.Nil(t, err)
+	p, _ := v.Append([]byte("a"), []byte("value"))
+	assert.Nil(t, v.Close())
+
+	v, err = Open(dir, 1024)
+	assert.Nil(t, err)
+	defer v.Close()
+
+	data, err := v.Read(p)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), data)
+
+	p2, _ := v.Append([]byte("b"), []byte("value2"))
+	assert.NotEqual(t, p.File, p2.File)
+}
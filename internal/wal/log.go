@@ -0,0 +1,277 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const segmentExtension = ".wal"
+
+// Segment size used when LogOptions.MaxSegmentSize is 0
+const defaultMaxSegmentSize = 64 * 1024 * 1024
+
+type LogOptions struct {
+	// Segments are rotated once they grow beyond this size. Defaults to
+	// 64 MB.
+	MaxSegmentSize int64
+	// Options of the records of new segments. Segments are read the way
+	// they were written, recorded in their header, so the options can be
+	// changed between opening the log.
+	WALOptions
+}
+
+type segment struct {
+	// LSN of the first entry of the segment
+	firstLSN uint64
+	fileName string
+}
+
+// A write-ahead log split into segments. Every entry appended to the log is
+// assigned a log sequence number (LSN), one higher than the entry before it.
+// New entries are appended to the newest segment, the head, which is rotated
+// once it grows beyond the max segment size. Segments are named by the LSN
+// of their first entry.
+//
+// Entries are kept for as long as they are retained, see Retain. A segment
+// is deleted once every entry in it is before the oldest retained LSN.
+type Log struct {
+	dir            string
+	maxSegmentSize int64
+	walOptions     WALOptions
+	lock           sync.Mutex
+	// Segments of the log, oldest first. The last one is the head.
+	segments []segment
+	head     *WAL
+	nextLSN  uint64
+	// Oldest retained LSN of every retention, by id
+	retained      map[uint64]uint64
+	nextRetention uint64
+	// Closed when the next entry is appended, to wake up waiting readers.
+	// nil if no reader is waiting.
+	appended chan struct{}
+	closed   bool
+}
+
+func segmentFileName(dir string, firstLSN uint64) string {
+	return path.Join(dir, fmt.Sprintf("%020d%v", firstLSN, segmentExtension))
+}
+
+// Opens the log in dir, creating it if it doesn't exist.
+func OpenLog(dir string, opts LogOptions) (*Log, error) {
+	err := os.MkdirAll(dir, 0770)
+	if err != nil {
+		return nil, err
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := []segment{}
+	for _, e := range dirEntries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+		lsn, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{firstLSN: lsn, fileName: path.Join(dir, name)})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].firstLSN < segments[j].firstLSN })
+
+	l := &Log{
+		dir:            dir,
+		maxSegmentSize: opts.MaxSegmentSize,
+		walOptions:     opts.WALOptions,
+		segments:       segments,
+		nextLSN:        1,
+		retained:       map[uint64]uint64{},
+	}
+	if l.maxSegmentSize <= 0 {
+		l.maxSegmentSize = defaultMaxSegmentSize
+	}
+
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		l.nextLSN = last.firstLSN
+		stats, err := ReplayWAL(last.fileName, l.walOptions.KeyProvider, func(e WALEntry) error {
+			l.nextLSN = e.LSN + 1
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if stats.Records == 0 {
+			// An empty head is reused as it is
+			l.segments = segments[:len(segments)-1]
+		}
+	}
+
+	// Always start writing to a new segment, the tail of the previous head
+	// may be a partially written entry.
+	if err := l.openHead(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Starts a new head segment beginning at the next LSN.
+func (l *Log) openHead() error {
+	fileName := segmentFileName(l.dir, l.nextLSN)
+	head, err := CreateWAL(fileName, l.walOptions)
+	if err != nil {
+		return err
+	}
+	l.head = head
+	l.segments = append(l.segments, segment{firstLSN: l.nextLSN, fileName: fileName})
+	return nil
+}
+
+// Returns the LSN the next appended entry will get.
+func (l *Log) NextLSN() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.nextLSN
+}
+
+// Appends an entry to the head of the log. Returns the LSN assigned to it.
+func (l *Log) Append(e WALEntry) (uint64, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.head.Size() >= l.maxSegmentSize {
+		// Only the head has to be synced by Sync
+		if err := l.head.Sync(); err != nil {
+			return 0, err
+		}
+		if err := l.head.Close(); err != nil {
+			return 0, err
+		}
+		if err := l.openHead(); err != nil {
+			return 0, err
+		}
+	}
+
+	e.LSN = l.nextLSN
+	if err := l.head.WriteEntry(e); err != nil {
+		return 0, err
+	}
+	l.nextLSN++
+	if l.appended != nil {
+		close(l.appended)
+		l.appended = nil
+	}
+	return e.LSN, nil
+}
+
+// Keeps the entries from lsn onwards from being deleted, until the returned
+// function is called.
+func (l *Log) Retain(lsn uint64) (release func()) {
+	l.lock.Lock()
+	id := l.retain(lsn)
+	l.lock.Unlock()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			l.release(id)
+		})
+	}
+}
+
+// Registers a retention of the entries from lsn onwards and returns its id.
+// The lock must be held.
+func (l *Log) retain(lsn uint64) uint64 {
+	id := l.nextRetention
+	l.nextRetention++
+	l.retained[id] = lsn
+	return id
+}
+
+// Removes a retention and deletes the segments no longer retained. The lock
+// must be held.
+func (l *Log) release(id uint64) {
+	delete(l.retained, id)
+	l.deleteReleasedSegments()
+}
+
+// Deletes the segments with only entries before the oldest retained LSN.
+// The head is never deleted.
+func (l *Log) deleteReleasedSegments() {
+	oldest := l.nextLSN
+	for _, lsn := range l.retained {
+		if lsn < oldest {
+			oldest = lsn
+		}
+	}
+
+	for len(l.segments) > 1 && l.segments[1].firstLSN <= oldest {
+		os.Remove(l.segments[0].fileName)
+		l.segments = l.segments[1:]
+	}
+}
+
+// Calls fn for every entry from fromLSN onwards, in the order they were
+// appended. Segments with only older entries aren't read. Entries are read
+// one at a time, partially written entries at the end of segments are
+// skipped.
+func (l *Log) Replay(fromLSN uint64, fn func(e WALEntry) error) (ReplayStats, error) {
+	l.lock.Lock()
+	segments := append([]segment{}, l.segments...)
+	l.lock.Unlock()
+
+	stats := ReplayStats{}
+	for i, s := range segments {
+		if i+1 < len(segments) && segments[i+1].firstLSN <= fromLSN {
+			continue
+		}
+
+		segmentStats, err := ReplayWAL(s.fileName, l.walOptions.KeyProvider, func(e WALEntry) error {
+			if e.LSN < fromLSN {
+				return nil
+			}
+			return fn(e)
+		})
+		stats.add(segmentStats)
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// Flushes every entry appended so far to stable storage.
+func (l *Log) Sync() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.head.Sync()
+}
+
+// Returns the number of segments of the log, including the head.
+func (l *Log) NumSegments() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.segments)
+}
+
+// Closes the log. Readers waiting for new entries return ErrLogClosed.
+func (l *Log) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.closed = true
+	if l.appended != nil {
+		close(l.appended)
+		l.appended = nil
+	}
+	return l.head.Close()
+}
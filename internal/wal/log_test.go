@@ -0,0 +1,172 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func appendEntries(t *T, l *Log, from int, to int) {
+	for i := from; i < to; i++ {
+		lsn, err := l.Append(WALEntry{Kind: WalOperationWrite, Key: fmt.Sprintf("key%03d", i), Data: []byte("data")})
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(i+1), lsn)
+	}
+}
+
+func replayKeys(t *T, l *Log, fromLSN uint64) []string {
+	keys := []string{}
+	_, err := l.Replay(fromLSN, func(e WALEntry) error {
+		assert.Equal(t, fmt.Sprintf("key%03d", e.LSN-1), e.Key)
+		keys = append(keys, e.Key)
+		return nil
+	})
+	assert.Nil(t, err)
+	return keys
+}
+
+func TestLogRotatesSegments(t *T) {
+	dir := t.TempDir()
+	l, err := OpenLog(dir, LogOptions{MaxSegmentSize: 500})
+	assert.Nil(t, err)
+
+	appendEntries(t, l, 0, 100)
+	assert.Greater(t, l.NumSegments(), 5)
+	assert.Equal(t, uint64(101), l.NextLSN())
+	assert.Nil(t, l.Sync())
+
+	keys := replayKeys(t, l, 95)
+	assert.Equal(t, []string{"key094", "key095", "key096", "key097", "key098", "key099"}, keys)
+	assert.Len(t, replayKeys(t, l, 1), 100)
+	assert.Nil(t, l.Close())
+
+	// LSNs continue after the entries of the reopened log
+	l, err = OpenLog(dir, LogOptions{MaxSegmentSize: 500})
+	assert.Nil(t, err)
+	defer l.Close()
+	appendEntries(t, l, 100, 110)
+	assert.Len(t, replayKeys(t, l, 1), 110)
+}
+
+func TestLogDeletesReleasedSegments(t *T) {
+	l, err := OpenLog(t.TempDir(), LogOptions{MaxSegmentSize: 500})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	releaseFirst := l.Retain(1)
+	appendEntries(t, l, 0, 50)
+	releaseSecond := l.Retain(l.NextLSN())
+	appendEntries(t, l, 50, 100)
+	segments := l.NumSegments()
+
+	// Segments are kept until every retention of their entries is released
+	releaseSecond()
+	assert.Equal(t, segments, l.NumSegments())
+	releaseThird := l.Retain(80)
+	releaseFirst()
+	assert.Less(t, l.NumSegments(), segments)
+	keys := replayKeys(t, l, 1)
+	assert.LessOrEqual(t, len(keys), 21+10)
+	assert.Equal(t, "key099", keys[len(keys)-1])
+	assert.Contains(t, keys, "key079")
+
+	releaseThird()
+	assert.Equal(t, 1, l.NumSegments())
+}
+
+func TestReaderWaitsForNewEntries(t *T) {
+	l, err := OpenLog(t.TempDir(), LogOptions{MaxSegmentSize: 500})
+	assert.Nil(t, err)
+	appendEntries(t, l, 0, 20)
+
+	r, err := l.NewReader(15)
+	assert.Nil(t, err)
+	defer r.Close()
+	for i := 15; i <= 20; i++ {
+		e, err := r.Next(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(i), e.LSN)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = r.Next(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// Entries appended while waiting are returned, across segments
+	go appendEntries(t, l, 20, 40)
+	for i := 21; i <= 40; i++ {
+		e, err := r.Next(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(i), e.LSN)
+		assert.Equal(t, fmt.Sprintf("key%03d", i-1), e.Key)
+	}
+
+	go l.Close()
+	_, err = r.Next(context.Background())
+	assert.Equal(t, ErrLogClosed, err)
+}
+
+func TestReaderRetainsUnacknowledgedEntries(t *T) {
+	l, err := OpenLog(t.TempDir(), LogOptions{MaxSegmentSize: 500})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	r, err := l.NewReader(0)
+	assert.Nil(t, err)
+	appendEntries(t, l, 0, 100)
+	segments := l.NumSegments()
+	assert.Greater(t, segments, 5)
+
+	for i := 0; i < 50; i++ {
+		_, err := r.Next(context.Background())
+		assert.Nil(t, err)
+	}
+	// Read entries are kept until they're acknowledged
+	assert.Equal(t, segments, l.NumSegments())
+	r.Ack(50)
+	assert.Less(t, l.NumSegments(), segments)
+	_, err = l.NewReader(1)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, r.Close())
+	assert.Equal(t, 1, l.NumSegments())
+}
+
+func TestLogOpensAfterTornWrite(t *T) {
+	dir := t.TempDir()
+	l, err := OpenLog(dir, LogOptions{})
+	assert.Nil(t, err)
+	appendEntries(t, l, 0, 10)
+	assert.Nil(t, l.Close())
+
+	// A crash while writing the head leaves a partial entry at its end
+	file, err := os.OpenFile(segmentFileName(dir, 1), os.O_APPEND|os.O_WRONLY, 0660)
+	assert.Nil(t, err)
+	_, err = file.WriteString(`{"Kind":6,"Key":"key010","LSN":11`)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	l, err = OpenLog(dir, LogOptions{})
+	assert.Nil(t, err)
+	defer l.Close()
+	appendEntries(t, l, 10, 20)
+
+	stats, err := l.Replay(1, func(e WALEntry) error { return nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 20, stats.Records)
+	assert.True(t, stats.TruncatedTail)
+
+	r, err := l.NewReader(0)
+	assert.Nil(t, err)
+	defer r.Close()
+	for i := 1; i <= 20; i++ {
+		e, err := r.Next(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(i), e.LSN)
+	}
+}
@@ -0,0 +1,190 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var ErrLogClosed = errors.New("log is closed")
+
+// Reads the entries of a Log in LSN order, waiting for new entries once it
+// has read all of them. The entries from the oldest one the reader hasn't
+// acknowledged onwards are retained, so they aren't deleted before the
+// reader is done with them.
+type Reader struct {
+	log *Log
+	// Id of the retention of the reader
+	retention uint64
+	// LSN of the next entry to return
+	nextLSN uint64
+	file    *os.File
+	reader  *fileReader
+}
+
+// Returns a reader starting at the entry with fromLSN, or at the oldest
+// entry of the log if fromLSN is 0. Fails if the entry has been deleted.
+func (l *Log) NewReader(fromLSN uint64) (*Reader, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.closed {
+		return nil, ErrLogClosed
+	}
+	oldest := l.segments[0].firstLSN
+	if fromLSN == 0 {
+		fromLSN = oldest
+	}
+	if fromLSN < oldest {
+		return nil, fmt.Errorf("entry %v has been deleted, the oldest entry is %v", fromLSN, oldest)
+	}
+	return &Reader{log: l, retention: l.retain(fromLSN), nextLSN: fromLSN}, nil
+}
+
+// Returns the next entry of the log, waiting for it to be appended if the
+// reader has read every entry. Returns ErrLogClosed if the log is closed
+// while waiting, or the error of ctx if it's done first.
+func (r *Reader) Next(ctx context.Context) (WALEntry, error) {
+	for {
+		if err := r.waitForEntry(ctx); err != nil {
+			return WALEntry{}, err
+		}
+
+		if r.reader == nil {
+			if err := r.openSegment(); err != nil {
+				return WALEntry{}, err
+			}
+		}
+
+		e, _, err := r.reader.next()
+		if err == io.EOF || err == errTruncated {
+			// The entry is in the next segment. The segment may end with a
+			// partially written entry, which was never assigned its LSN.
+			r.closeSegment()
+			if err := r.openNextSegment(); err != nil {
+				return WALEntry{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return WALEntry{}, err
+		}
+		if e.LSN < r.nextLSN {
+			continue
+		}
+		r.nextLSN = e.LSN + 1
+		return e, nil
+	}
+}
+
+// Waits until the next entry of the reader has been appended to the log.
+// Entries are fully written to their segment before the next LSN of the log
+// is increased, so the entry can be read once it's below it.
+func (r *Reader) waitForEntry(ctx context.Context) error {
+	l := r.log
+	for {
+		l.lock.Lock()
+		if r.nextLSN < l.nextLSN {
+			l.lock.Unlock()
+			return nil
+		}
+		if l.closed {
+			l.lock.Unlock()
+			return ErrLogClosed
+		}
+		if l.appended == nil {
+			l.appended = make(chan struct{})
+		}
+		appended := l.appended
+		l.lock.Unlock()
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Opens the segment containing the next entry of the reader.
+func (r *Reader) openSegment() error {
+	r.log.lock.Lock()
+	fileName := ""
+	for _, s := range r.log.segments {
+		if s.firstLSN <= r.nextLSN {
+			fileName = s.fileName
+		}
+	}
+	r.log.lock.Unlock()
+
+	if fileName == "" {
+		return fmt.Errorf("entry %v has been deleted", r.nextLSN)
+	}
+	return r.open(fileName)
+}
+
+// Opens the segment starting at the next entry of the reader, after reading
+// every entry of the previous one.
+func (r *Reader) openNextSegment() error {
+	r.log.lock.Lock()
+	fileName := ""
+	for _, s := range r.log.segments {
+		if s.firstLSN == r.nextLSN {
+			fileName = s.fileName
+		}
+	}
+	r.log.lock.Unlock()
+
+	if fileName == "" {
+		return fmt.Errorf("entry %v is missing from the log", r.nextLSN)
+	}
+	return r.open(fileName)
+}
+
+func (r *Reader) open(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	reader, err := newFileReader(file, r.log.walOptions.KeyProvider)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.reader = reader
+	return nil
+}
+
+func (r *Reader) closeSegment() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+		r.reader = nil
+	}
+}
+
+// Acknowledges every entry up to and including lsn, letting the segments
+// with only acknowledged entries be deleted.
+func (r *Reader) Ack(lsn uint64) {
+	l := r.log
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if retained, ok := l.retained[r.retention]; ok && lsn+1 > retained {
+		l.retained[r.retention] = lsn + 1
+		l.deleteReleasedSegments()
+	}
+}
+
+// Closes the reader and releases the entries it retains.
+func (r *Reader) Close() error {
+	r.log.lock.Lock()
+	r.log.release(r.retention)
+	r.log.lock.Unlock()
+
+	r.closeSegment()
+	return nil
+}
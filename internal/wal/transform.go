@@ -0,0 +1,249 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/lindend/distdb/internal/encryption"
+)
+
+// Options of the records of WAL files.
+type WALOptions struct {
+	// Compresses every record with flate.
+	Compress bool
+	// Encrypts every record with AES-GCM, using the current key of the
+	// provider. Also needed to read encrypted files. If nil, records aren't
+	// encrypted.
+	KeyProvider encryption.KeyProvider
+}
+
+// Written in front of the header of files with transformed records. Files
+// without a header have one JSON encoded entry per line, the first line
+// starting with '{'.
+const headerMagic = "#wal "
+
+// Header of a WAL file, recording how its records are transformed. Records
+// are framed by their length, since transformed records may contain any
+// byte.
+type fileHeader struct {
+	// Transforms applied to the records, in the order they're applied when
+	// writing
+	Transforms []string `json:",omitempty"`
+	// Id of the key the records are encrypted with
+	KeyID string `json:",omitempty"`
+}
+
+// Size in bytes of the length in front of every framed record
+const frameHeaderSize = 4
+
+// Returned when a file ends with a partially written record
+var errTruncated = errors.New("partially written record")
+
+// Transforms the records of a WAL file when they're written and read.
+type recordTransform interface {
+	name() string
+	encode(record []byte) ([]byte, error)
+	decode(record []byte) ([]byte, error)
+}
+
+const flateTransformName = "flate"
+
+type flateTransform struct{}
+
+var flateWriters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return w
+}}
+
+func (flateTransform) name() string {
+	return flateTransformName
+}
+
+func (flateTransform) encode(record []byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(record); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateTransform) decode(record []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(record))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+const aesGCMTransformName = "aes-gcm"
+
+type aesGCMTransform struct {
+	cipher *encryption.Cipher
+}
+
+func (aesGCMTransform) name() string {
+	return aesGCMTransformName
+}
+
+func (t aesGCMTransform) encode(record []byte) ([]byte, error) {
+	return t.cipher.Seal(nil, record, nil)
+}
+
+func (t aesGCMTransform) decode(record []byte) ([]byte, error) {
+	return t.cipher.Open(record, nil)
+}
+
+// Creates the transforms and header of a new file.
+func newFileTransforms(opts WALOptions) ([]recordTransform, fileHeader, error) {
+	transforms := []recordTransform{}
+	header := fileHeader{}
+	if opts.Compress {
+		transforms = append(transforms, flateTransform{})
+	}
+	if opts.KeyProvider != nil {
+		id, key, err := opts.KeyProvider.CurrentKey()
+		if err != nil {
+			return nil, header, err
+		}
+		c, err := encryption.NewCipher(key)
+		if err != nil {
+			return nil, header, err
+		}
+		transforms = append(transforms, aesGCMTransform{c})
+		header.KeyID = id
+	}
+	for _, t := range transforms {
+		header.Transforms = append(header.Transforms, t.name())
+	}
+	return transforms, header, nil
+}
+
+// Creates the transforms recorded in the header of a file.
+func headerTransforms(header fileHeader, keys encryption.KeyProvider) ([]recordTransform, error) {
+	transforms := []recordTransform{}
+	for _, name := range header.Transforms {
+		switch name {
+		case flateTransformName:
+			transforms = append(transforms, flateTransform{})
+		case aesGCMTransformName:
+			if keys == nil {
+				return nil, errors.New("WAL is encrypted, but no key provider was given")
+			}
+			key, err := keys.Key(header.KeyID)
+			if err != nil {
+				return nil, err
+			}
+			c, err := encryption.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+			transforms = append(transforms, aesGCMTransform{c})
+		default:
+			return nil, fmt.Errorf("unknown WAL transform %v", name)
+		}
+	}
+	return transforms, nil
+}
+
+// Reads the entries of a WAL file, in the format recorded in its header.
+type fileReader struct {
+	r      *bufio.Reader
+	framed bool
+	// Applied to records in reverse order
+	transforms []recordTransform
+	// Size of a partially written header, 0 if the header is complete
+	truncatedHeader int64
+}
+
+func newFileReader(r io.Reader, keys encryption.KeyProvider) (*fileReader, error) {
+	f := &fileReader{r: bufio.NewReader(r)}
+	magic, err := f.r.Peek(len(headerMagic))
+	if err == io.EOF || (err == nil && string(magic) != headerMagic) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f.framed = true
+	line, err := f.r.ReadBytes(entrySeparator)
+	if err == io.EOF {
+		f.truncatedHeader = int64(len(line))
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := fileHeader{}
+	if err := json.Unmarshal(line[len(headerMagic):], &header); err != nil {
+		return nil, err
+	}
+	f.transforms, err = headerTransforms(header, keys)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Returns the next entry and the size of its record. Returns io.EOF at the
+// end of the file, or errTruncated with the size of the partial record if
+// the file ends with a partially written one.
+func (f *fileReader) next() (WALEntry, int64, error) {
+	if f.truncatedHeader > 0 {
+		return WALEntry{}, f.truncatedHeader, errTruncated
+	}
+
+	if !f.framed {
+		data, err := f.r.ReadBytes(entrySeparator)
+		if err == io.EOF && len(data) > 0 {
+			return WALEntry{}, int64(len(data)), errTruncated
+		}
+		if err != nil {
+			return WALEntry{}, 0, err
+		}
+		e, err := decodeEntry(data)
+		return e, int64(len(data)), err
+	}
+
+	var frame [frameHeaderSize]byte
+	n, err := io.ReadFull(f.r, frame[:])
+	if err == io.ErrUnexpectedEOF {
+		return WALEntry{}, int64(n), errTruncated
+	}
+	if err != nil {
+		return WALEntry{}, 0, err
+	}
+
+	// The record is copied as it's read instead of allocating its length up
+	// front, the length of a partially written frame may be garbage
+	length := int64(binary.BigEndian.Uint32(frame[:]))
+	buf := bytes.Buffer{}
+	copied, err := io.CopyN(&buf, f.r, length)
+	if err == io.EOF {
+		return WALEntry{}, frameHeaderSize + copied, errTruncated
+	}
+	if err != nil {
+		return WALEntry{}, 0, err
+	}
+
+	record := buf.Bytes()
+	for i := len(f.transforms) - 1; i >= 0; i-- {
+		record, err = f.transforms[i].decode(record)
+		if err != nil {
+			return WALEntry{}, 0, err
+		}
+	}
+	e, err := decodeEntry(record)
+	return e, frameHeaderSize + length, err
+}
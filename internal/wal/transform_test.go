@@ -0,0 +1,119 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	. "testing"
+
+	"github.com/lindend/distdb/internal/encryption"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKeys(t *T, current string) encryption.KeyProvider {
+	keys, err := encryption.NewStaticKeyProvider(current, map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 16),
+	})
+	assert.Nil(t, err)
+	return keys
+}
+
+func TestTransformedRecords(t *T) {
+	for _, opts := range []WALOptions{
+		{},
+		{Compress: true},
+		{KeyProvider: testKeys(t, "k1")},
+		{Compress: true, KeyProvider: testKeys(t, "k1")},
+	} {
+		fileName := path.Join(t.TempDir(), "wal.log")
+		w, err := CreateWAL(fileName, opts)
+		assert.Nil(t, err)
+		value := bytes.Repeat([]byte("secret value "), 100)
+		for i := 0; i < 10; i++ {
+			assert.Nil(t, w.WriteEntry(WALEntry{Kind: WalOperationWrite, Key: fmt.Sprintf("key%v", i), Data: value, Seq: uint64(i)}))
+		}
+		assert.Nil(t, w.WriteEntry(WALEntry{Kind: WalOperationWrite, Key: "\x00\xff"}))
+		assert.Nil(t, w.Close())
+
+		data, err := os.ReadFile(fileName)
+		assert.Nil(t, err)
+		if opts.Compress {
+			assert.Less(t, len(data), 10*len(value))
+		}
+		if opts.KeyProvider != nil {
+			assert.False(t, bytes.Contains(data, []byte("secret")))
+			assert.False(t, bytes.Contains(data, []byte("key1")))
+		}
+
+		entries := []WALEntry{}
+		stats, err := ReplayWAL(fileName, opts.KeyProvider, func(e WALEntry) error {
+			entries = append(entries, e)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 11, stats.Records)
+		assert.Len(t, entries, 11)
+		assert.Equal(t, WALEntry{Kind: WalOperationWrite, Key: "key3", Data: value, Seq: 3}, entries[3])
+		assert.Equal(t, "\x00\xff", entries[10].Key)
+
+		// A partially written record is skipped
+		file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0660)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte{0, 0, 1, 0, 'x'})
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+		stats, err = ReplayWAL(fileName, opts.KeyProvider, func(e WALEntry) error { return nil })
+		assert.Nil(t, err)
+		assert.Equal(t, 11, stats.Records)
+		assert.Equal(t, ReplayStats{Records: 11, Bytes: stats.Bytes, TruncatedTail: true, TruncatedBytes: 5}, stats)
+	}
+}
+
+func TestEncryptedRecordsNeedTheirKey(t *T) {
+	fileName := path.Join(t.TempDir(), "wal.log")
+	w, err := CreateWAL(fileName, WALOptions{KeyProvider: testKeys(t, "k2")})
+	assert.Nil(t, err)
+	assert.Nil(t, w.Write(WalOperationWrite, "key", []byte("value")))
+	assert.Nil(t, w.Close())
+
+	replay := func(keys encryption.KeyProvider) error {
+		_, err := ReplayWAL(fileName, keys, func(e WALEntry) error { return nil })
+		return err
+	}
+	assert.NotNil(t, replay(nil))
+	other, err := encryption.NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	assert.Nil(t, err)
+	assert.NotNil(t, replay(other))
+	// Files are decrypted with the key they were written with, not the
+	// current one
+	assert.Nil(t, replay(testKeys(t, "k1")))
+}
+
+func TestLogReadsSegmentsWrittenWithOtherOptions(t *T) {
+	dir := t.TempDir()
+	keys := testKeys(t, "k1")
+
+	// A segment without a header, written before records could be
+	// transformed
+	w, err := NewWAL(segmentFileName(dir, 1))
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, w.WriteEntry(WALEntry{Kind: WalOperationWrite, Key: fmt.Sprintf("key%03d", i), Data: []byte("data"), LSN: uint64(i + 1)}))
+	}
+	assert.Nil(t, w.Close())
+
+	for i, opts := range []WALOptions{{Compress: true}, {KeyProvider: keys}, {Compress: true, KeyProvider: testKeys(t, "k2")}} {
+		l, err := OpenLog(dir, LogOptions{WALOptions: opts})
+		assert.Nil(t, err)
+		appendEntries(t, l, 5+i*5, 10+i*5)
+		assert.Nil(t, l.Close())
+	}
+
+	l, err := OpenLog(dir, LogOptions{WALOptions: WALOptions{KeyProvider: keys}})
+	assert.Nil(t, err)
+	defer l.Close()
+	assert.Len(t, replayKeys(t, l, 1), 20)
+}
@@ -1,10 +1,14 @@
 package wal
 
 import (
-	"bufio"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"unicode/utf8"
+
+	"github.com/lindend/distdb/internal/encryption"
 )
 
 type WALOperation int
@@ -15,72 +19,202 @@ type WALEntry struct {
 	Kind uint64
 	Key  string
 	Data []byte
+	// Unix time in nanoseconds when the entry expires, 0 if it never does
+	ExpiresAt int64 `json:",omitempty"`
+	// Sequence number assigned to the entry by the writer
+	Seq uint64 `json:",omitempty"`
+	// Log sequence number of the entry, assigned by the Log it's appended
+	// to. 0 for entries written to a single WAL file.
+	LSN uint64 `json:",omitempty"`
+}
+
+// An entry as stored in the file. Keys are arbitrary bytes, but JSON strings
+// can only hold valid UTF-8, so other keys are stored base64 encoded in
+// RawKey instead.
+type walRecord struct {
+	WALEntry
+	RawKey []byte `json:",omitempty"`
 }
 
 type WAL struct {
 	file     *os.File
 	fileName string
+	// Size of the file in bytes
+	size int64
+	// Set if records are framed by their length instead of written one per
+	// line
+	framed     bool
+	transforms []recordTransform
 }
 
 func NewWAL(fileName string) (*WAL, error) {
-	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE, 0660)
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 	return &WAL{
-		file,
-		fileName,
+		file:     file,
+		fileName: fileName,
+		size:     info.Size(),
 	}, nil
 }
 
-// Loads a WAL file, oldest entries are first in the array
-func LoadWAL(fileName string) ([]WALEntry, error) {
-	file, err := os.Open(fileName)
+// Creates a WAL file with a header recording how its records are
+// transformed, replacing the file if it exists.
+func CreateWAL(fileName string, opts WALOptions) (*WAL, error) {
+	transforms, header, err := newFileTransforms(opts)
+	if err != nil {
+		return nil, err
+	}
+	headerJson, err := json.Marshal(header)
 	if err != nil {
 		return nil, err
 	}
 
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+	if err != nil {
+		return nil, err
+	}
+	data := append([]byte(headerMagic), headerJson...)
+	data = append(data, entrySeparator)
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &WAL{
+		file:       file,
+		fileName:   fileName,
+		size:       int64(len(data)),
+		framed:     true,
+		transforms: transforms,
+	}, nil
+}
+
+// Statistics of replaying a WAL file.
+type ReplayStats struct {
+	// Number of entries read
+	Records int
+	// Size in bytes of the entries read
+	Bytes int64
+	// Set if the file ends with a partially written entry, left by a crash
+	// while writing it. The entry is skipped.
+	TruncatedTail bool
+	// Size in bytes of the partially written entry
+	TruncatedBytes int64
+}
+
+func (s *ReplayStats) add(other ReplayStats) {
+	s.Records += other.Records
+	s.Bytes += other.Bytes
+	s.TruncatedTail = s.TruncatedTail || other.TruncatedTail
+	s.TruncatedBytes += other.TruncatedBytes
+}
+
+// Calls fn for every entry of a WAL file, oldest first, reading one entry
+// at a time. keys provides the keys of encrypted files. A partially written
+// entry at the end of the file is skipped and reported in the stats, other
+// entries that can't be decoded are errors.
+func ReplayWAL(fileName string, keys encryption.KeyProvider, fn func(e WALEntry) error) (ReplayStats, error) {
+	stats := ReplayStats{}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return stats, err
+	}
 	defer file.Close()
 
-	entries := make([]WALEntry, 0)
-	r := bufio.NewReader(file)
+	r, err := newFileReader(file, keys)
+	if err != nil {
+		return stats, fmt.Errorf("failed to read header of %v: %w", fileName, err)
+	}
 	for {
-		data, ioErr := r.ReadBytes(entrySeparator)
-		if ioErr != nil && ioErr != io.EOF {
-			return nil, ioErr
+		e, size, err := r.next()
+		if err == io.EOF {
+			return stats, nil
 		}
-
-		if len(data) > 0 {
-			entry := WALEntry{}
-			err = json.Unmarshal(data, &entry)
-			if err != nil {
-				return nil, err
-			}
-
-			entries = append(entries, entry)
+		if err == errTruncated {
+			stats.TruncatedTail = true
+			stats.TruncatedBytes = size
+			return stats, nil
+		}
+		if err != nil {
+			return stats, fmt.Errorf("corrupt entry after %v bytes of entries in %v: %w", stats.Bytes, fileName, err)
 		}
 
-		if ioErr == io.EOF {
-			break
+		stats.Records++
+		stats.Bytes += size
+		if err := fn(e); err != nil {
+			return stats, err
 		}
 	}
+}
 
+// Loads a WAL file, oldest entries are first in the array. Prefer ReplayWAL
+// for large files, which doesn't hold every entry in memory.
+func LoadWAL(fileName string) ([]WALEntry, error) {
+	entries := make([]WALEntry, 0)
+	_, err := ReplayWAL(fileName, nil, func(e WALEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return entries, nil
 }
 
+// Decodes an entry as stored in the file.
+func decodeEntry(data []byte) (WALEntry, error) {
+	record := walRecord{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return WALEntry{}, err
+	}
+	if record.RawKey != nil {
+		record.Key = string(record.RawKey)
+	}
+	return record.WALEntry, nil
+}
+
 func (w *WAL) Write(kind uint64, key string, data []byte) error {
-	we := WALEntry{
-		Kind: kind,
-		Key:  key,
-		Data: data,
+	return w.WriteWithExpiry(kind, key, data, 0)
+}
+
+// Writes an entry that expires at the given unix time in nanoseconds, 0 if
+// it never expires.
+func (w *WAL) WriteWithExpiry(kind uint64, key string, data []byte, expiresAt int64) error {
+	return w.WriteEntry(WALEntry{
+		Kind:      kind,
+		Key:       key,
+		Data:      data,
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (w *WAL) WriteEntry(we WALEntry) error {
+	record := walRecord{WALEntry: we}
+	if !utf8.ValidString(we.Key) {
+		record.Key = ""
+		record.RawKey = []byte(we.Key)
 	}
-	bs, err := json.Marshal(we)
+	bs, err := json.Marshal(record)
 	if err != nil {
 		return err
 	}
-	bs = append(bs, entrySeparator)
+	if w.framed {
+		bs, err = w.frame(bs)
+		if err != nil {
+			return err
+		}
+	} else {
+		bs = append(bs, entrySeparator)
+	}
 
-	_, err = w.file.Write(bs)
+	n, err := w.file.Write(bs)
+	w.size += int64(n)
 	if err != nil {
 		return err
 	}
@@ -88,6 +222,30 @@ func (w *WAL) Write(kind uint64, key string, data []byte) error {
 	return nil
 }
 
+// Transforms a record and frames it with its length.
+func (w *WAL) frame(record []byte) ([]byte, error) {
+	for _, t := range w.transforms {
+		var err error
+		record, err = t.encode(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	framed := make([]byte, frameHeaderSize, frameHeaderSize+len(record))
+	binary.BigEndian.PutUint32(framed, uint32(len(record)))
+	return append(framed, record...), nil
+}
+
+// Flushes the records written so far to stable storage.
+func (w *WAL) Sync() error {
+	return w.file.Sync()
+}
+
+// Size of the file in bytes.
+func (w *WAL) Size() int64 {
+	return w.size
+}
+
 func (w *WAL) Close() error {
 	return w.file.Close()
 }
@@ -1,6 +1,8 @@
 package wal
 
 import (
+	"os"
+	"path"
 	. "testing"
 
 	"github.com/stretchr/testify/assert"
@@ -17,6 +19,9 @@ func TestWal(t *T) {
 	w.Write(WalOperationWrite, "key1", []byte("data1"))
 	w.Write(WalOperationWrite, "key2", []byte("data2"))
 	w.Write(WalOperationDelete, "key3", []byte("data3"))
+	w.WriteWithExpiry(WalOperationWrite, "key4", []byte("data4"), 1234)
+	w.WriteEntry(WALEntry{Kind: WalOperationWrite, Key: "key5", Seq: 7})
+	w.Write(WalOperationWrite, "\x00\xff\x01", []byte("binary"))
 	w.Close()
 
 	ws, err := LoadWAL("wal_test.log")
@@ -26,19 +31,79 @@ func TestWal(t *T) {
 		WalOperationWrite,
 		"key1",
 		[]byte("data1"),
+		0,
+		0,
+		0,
 	}, ws[0])
 
 	assert.Equal(t, WALEntry{
 		WalOperationWrite,
 		"key2",
 		[]byte("data2"),
+		0,
+		0,
+		0,
 	}, ws[1])
 
 	assert.Equal(t, WALEntry{
 		WalOperationDelete,
 		"key3",
 		[]byte("data3"),
+		0,
+		0,
+		0,
 	}, ws[2])
 
+	assert.Equal(t, WALEntry{
+		WalOperationWrite,
+		"key4",
+		[]byte("data4"),
+		1234,
+		0,
+		0,
+	}, ws[3])
+
+	assert.Equal(t, WALEntry{Kind: WalOperationWrite, Key: "key5", Seq: 7}, ws[4])
+	assert.Equal(t, "\x00\xff\x01", ws[5].Key)
+
 	assert.Nil(t, w.Delete())
 }
+
+func writeTestWAL(t *T, fileName string, tail string) {
+	w, err := NewWAL(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Write(WalOperationWrite, "key1", []byte("data1")))
+	assert.Nil(t, w.Write(WalOperationWrite, "key2", []byte("data2")))
+	_, err = w.file.WriteString(tail)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+}
+
+func TestReplayWALSkipsTruncatedTail(t *T) {
+	fileName := path.Join(t.TempDir(), "wal.log")
+	writeTestWAL(t, fileName, `{"Kind":6,"Key":"ke`)
+
+	keys := []string{}
+	stats, err := ReplayWAL(fileName, nil, func(e WALEntry) error {
+		keys = append(keys, e.Key)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"key1", "key2"}, keys)
+
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, ReplayStats{Records: 2, Bytes: info.Size() - 19, TruncatedTail: true, TruncatedBytes: 19}, stats)
+}
+
+func TestReplayWALFailsOnCorruptEntries(t *T) {
+	fileName := path.Join(t.TempDir(), "wal.log")
+	writeTestWAL(t, fileName, "garbage\n")
+
+	stats, err := ReplayWAL(fileName, nil, func(e WALEntry) error { return nil })
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, stats.Records)
+
+	_, err = ReplayWAL(path.Join(t.TempDir(), "missing.log"), nil, func(e WALEntry) error { return nil })
+	assert.True(t, os.IsNotExist(err))
+}
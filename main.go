@@ -27,7 +27,7 @@ func main() {
 }
 
 func tree() {
-	tree, err := lsmtree.NewLsmTree("D:/dev/test/tree")
+	tree, err := lsmtree.NewLsmTree("D:/dev/test/tree", lsmtree.DefaultOptions())
 	if err != nil {
 		panic(err)
 	}
@@ -48,7 +48,7 @@ func tree() {
 }
 
 func tbl(numElements int64, root, name string) {
-	tblBuilder, err := sstable.NewSSTable(uint(numElements), root, name)
+	tblBuilder, err := sstable.NewSSTable(uint(numElements), root, name, sstable.Options{})
 	if err != nil {
 		panic(err.Error())
 	}
@@ -83,7 +83,7 @@ func tbl(numElements int64, root, name string) {
 }
 
 func perfProf() {
-	tbl, err := sstable.LoadSSTable("D:/dev/test", "big")
+	tbl, err := sstable.LoadSSTable("D:/dev/test", "big", sstable.Options{})
 	if err != nil {
 		panic(err)
 	}
@@ -101,7 +101,7 @@ func perfProf() {
 }
 
 func readTable() {
-	tbl, _ := sstable.LoadSSTable("D:/dev/test", "big")
+	tbl, _ := sstable.LoadSSTable("D:/dev/test", "big", sstable.Options{})
 	tblSize, _ := tbl.Size()
 	fmt.Println("SSTable size: ", tblSize)
 	start := time.Now()
@@ -0,0 +1,117 @@
+// Package sst writes SSTables outside of a tree, for bulk loading. Tables
+// written here can be added to a tree with LsmTree.IngestExternalFiles.
+package sst
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/lindend/distdb/internal/lsmtree"
+	"github.com/lindend/distdb/internal/sstable"
+)
+
+// Orders the keys of a table. Compare gets keys as strings holding their
+// bytes, and Name identifies the order, a table can only be ingested into a
+// tree with a comparator of the same name.
+type Comparator = sstable.Comparator
+
+var (
+	// Orders keys by their bytes. The default comparator of tables and trees.
+	BytewiseComparator = sstable.BytewiseComparator
+	// Orders keys by their bytes, in descending order.
+	ReverseBytewiseComparator = sstable.ReverseBytewiseComparator
+)
+
+// Options used when creating a Writer.
+type WriterOptions struct {
+	// Order of the keys of the table. Must be the comparator of the tree the
+	// table is ingested into. If nil, keys are in bytewise order.
+	Comparator Comparator
+}
+
+// Writes the entries of a single table. Keys are arbitrary bytes, and must
+// be written in ascending order of the comparator, each key at most once.
+type Writer struct {
+	builder *sstable.SSTableBuilder
+	path    string
+	cmp     Comparator
+	// Last key written, valid once a key was written
+	lastKey string
+	written bool
+}
+
+// Creates a writer for a table stored at path, which is a directory and a
+// table name without file extension. numElements is the approximate number
+// of keys that will be written, used to size the filter of the table.
+func NewWriter(path string, numElements uint) (*Writer, error) {
+	return NewWriterWithOptions(path, numElements, WriterOptions{})
+}
+
+// Creates a writer like NewWriter, for a table with keys ordered by the
+// comparator of the options.
+func NewWriterWithOptions(path string, numElements uint, options WriterOptions) (*Writer, error) {
+	root, name := filepath.Split(path)
+	if name == "" {
+		return nil, errors.New("path must end with a table name")
+	}
+
+	cmp := options.Comparator
+	if cmp == nil {
+		cmp = sstable.BytewiseComparator
+	}
+	builder, err := sstable.NewSSTable(numElements, root, name, sstable.Options{Comparator: cmp})
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		builder: builder,
+		path:    path,
+		cmp:     cmp,
+	}, nil
+}
+
+// Path of the table, to pass on to IngestExternalFiles.
+func (w *Writer) Path() string {
+	return w.path
+}
+
+func (w *Writer) Put(key []byte, value []byte) error {
+	return w.write(key, lsmtree.RecordKindWrite, value)
+}
+
+// Writes a delete marker, hiding older values of the key in the tree the
+// table is ingested into.
+func (w *Writer) Delete(key []byte) error {
+	return w.write(key, lsmtree.RecordKindDelete, nil)
+}
+
+func (w *Writer) write(key []byte, kind uint64, value []byte) error {
+	k := string(key)
+	if w.written && w.cmp.Compare(w.lastKey, k) >= 0 {
+		return errors.New("keys must be written in ascending order, each key at most once")
+	}
+	if err := w.builder.Write(k, kind, value); err != nil {
+		return err
+	}
+	w.lastKey = k
+	w.written = true
+	return nil
+}
+
+// Deletes every key in [start, end) that is older than the table, in the
+// tree the table is ingested into.
+func (w *Writer) DeleteRange(start []byte, end []byte) error {
+	if w.cmp.Compare(string(start), string(end)) >= 0 {
+		return errors.New("start of range to delete must be before end")
+	}
+	return w.builder.AddRangeTombstone(sstable.RangeTombstone{Start: string(start), End: string(end)})
+}
+
+// Writes the table to disk. The writer can't be used afterwards.
+func (w *Writer) Finish() error {
+	tbl, err := w.builder.Build()
+	if err != nil {
+		return err
+	}
+	return tbl.Close()
+}
@@ -0,0 +1,292 @@
+package sst
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	. "testing"
+
+	"github.com/lindend/distdb/internal/lsmtree"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTable(t *T, path string, keys ...string) string {
+	w, err := NewWriter(path, uint(len(keys)))
+	assert.Nil(t, err)
+	for _, k := range keys {
+		assert.Nil(t, w.Put([]byte(k), []byte("ingested-"+k)))
+	}
+	assert.Nil(t, w.Finish())
+	return w.Path()
+}
+
+func newTree(t *T) *lsmtree.LsmTree {
+	options := lsmtree.DefaultOptions()
+	options.MergeInterval = 0
+	tree, err := lsmtree.NewLsmTree(t.TempDir(), options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+func TestWriterRejectsUnorderedKeys(t *T) {
+	w, err := NewWriter(filepath.Join(t.TempDir(), "table"), 2)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Put([]byte("b"), []byte("b")))
+	assert.NotNil(t, w.Put([]byte("a"), []byte("a")))
+}
+
+func TestWriterRejectsDuplicateKeys(t *T) {
+	w, err := NewWriter(filepath.Join(t.TempDir(), "table"), 2)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Put([]byte("a"), []byte("a")))
+	assert.NotNil(t, w.Put([]byte("a"), []byte("again")))
+	assert.NotNil(t, w.Delete([]byte("a")))
+	assert.Nil(t, w.Put([]byte("b"), []byte("b")))
+}
+
+func TestIngestExternalFiles(t *T) {
+	tree := newTree(t)
+	assert.Nil(t, tree.Set("a", []byte("old-a")))
+	assert.Nil(t, tree.Set("m", []byte("old-m")))
+
+	dir := t.TempDir()
+	first := writeTable(t, filepath.Join(dir, "first"), "a", "b", "c")
+	second := writeTable(t, filepath.Join(dir, "second"), "x", "y")
+	assert.Nil(t, tree.IngestExternalFiles([]string{second, first}))
+
+	for _, k := range []string{"a", "b", "c", "x", "y"} {
+		data, exists, err := tree.Get(k)
+		assert.Nil(t, err)
+		assert.True(t, exists, k)
+		assert.Equal(t, "ingested-"+k, string(data), "ingested value should be newest")
+	}
+	data, _, _ := tree.Get("m")
+	assert.Equal(t, "old-m", string(data))
+
+	// Writes after ingestion are newer than the ingested values
+	assert.Nil(t, tree.Set("b", []byte("new-b")))
+	data, _, _ = tree.Get("b")
+	assert.Equal(t, "new-b", string(data))
+
+	// Tables that don't overlap anything go to the last layer
+	sizes := tree.LayerSizes()
+	assert.True(t, sizes[len(sizes)-1] > 0, "second table should be in the last layer")
+}
+
+func TestIngestRejectsOverlappingFiles(t *T) {
+	tree := newTree(t)
+	dir := t.TempDir()
+	first := writeTable(t, filepath.Join(dir, "first"), "a", "c")
+	second := writeTable(t, filepath.Join(dir, "second"), "b", "d")
+
+	assert.NotNil(t, tree.IngestExternalFiles([]string{first, second}))
+	_, exists, _ := tree.Get("a")
+	assert.False(t, exists, "nothing should be ingested")
+}
+
+func assertTableFilesExist(t *T, path string) {
+	for _, ext := range []string{".data", ".index", ".meta"} {
+		_, err := os.Stat(path + ext)
+		assert.Nil(t, err, "%v should still exist", path+ext)
+	}
+}
+
+func TestIngestIsAllOrNothing(t *T) {
+	tree := newTree(t)
+	dir := t.TempDir()
+	first := writeTable(t, filepath.Join(dir, "first"), "a", "b")
+	second := writeTable(t, filepath.Join(dir, "second"), "x", "y")
+	assert.Nil(t, os.WriteFile(second+".meta", []byte("{"), 0660))
+
+	assert.NotNil(t, tree.IngestExternalFiles([]string{first, second}))
+	_, exists, err := tree.Get("a")
+	assert.Nil(t, err)
+	assert.False(t, exists, "nothing should be ingested")
+	assertTableFilesExist(t, first)
+	assertTableFilesExist(t, second)
+}
+
+func TestIngestRestoresFilesWhenSaveFails(t *T) {
+	dir := t.TempDir()
+	options := lsmtree.DefaultOptions()
+	options.MergeInterval = 0
+	tree, err := lsmtree.NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	defer tree.Close()
+
+	// The state of the tree can't be written over a directory
+	state := filepath.Join(dir, "lsm.json")
+	assert.Nil(t, os.RemoveAll(state))
+	assert.Nil(t, os.Mkdir(state, 0770))
+
+	tables := t.TempDir()
+	first := writeTable(t, filepath.Join(tables, "first"), "a", "b")
+	second := writeTable(t, filepath.Join(tables, "second"), "x", "y")
+	assert.NotNil(t, tree.IngestExternalFiles([]string{first, second}))
+	_, exists, err := tree.Get("x")
+	assert.Nil(t, err)
+	assert.False(t, exists, "nothing should be ingested")
+	assertTableFilesExist(t, first)
+	assertTableFilesExist(t, second)
+
+	// Ingesting again works once the state can be saved
+	assert.Nil(t, os.Remove(state))
+	assert.Nil(t, tree.IngestExternalFiles([]string{first, second}))
+	data, exists, err := tree.Get("x")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "ingested-x", string(data))
+}
+
+func TestIngestDeletes(t *T) {
+	tree := newTree(t)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, tree.Set(fmt.Sprintf("key%d", i), []byte("value")))
+	}
+
+	w, err := NewWriter(filepath.Join(t.TempDir(), "deletes"), 1)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Delete([]byte("key0")))
+	assert.Nil(t, w.DeleteRange([]byte("key2"), []byte("key5")))
+	assert.Nil(t, w.Finish())
+	assert.Nil(t, tree.IngestExternalFiles([]string{w.Path()}))
+
+	for i := 0; i < 10; i++ {
+		_, exists, err := tree.Get(fmt.Sprintf("key%d", i))
+		assert.Nil(t, err)
+		assert.Equal(t, i == 1 || i >= 5, exists, "key%d", i)
+	}
+}
+
+func TestIngestedKeysGetNewVersions(t *T) {
+	dir := t.TempDir()
+	options := lsmtree.DefaultOptions()
+	options.MergeInterval = 0
+	tree, err := lsmtree.NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Set("a", []byte("old-a")))
+	_, oldVersion, _, err := tree.GetWithVersion("a")
+	assert.Nil(t, err)
+
+	path := writeTable(t, filepath.Join(t.TempDir(), "table"), "a", "b")
+	assert.Nil(t, tree.IngestExternalFiles([]string{path}))
+
+	_, version, exists, err := tree.GetWithVersion("a")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Greater(t, version, oldVersion, "ingested value should have a newer version")
+	ok, err := tree.SetIfVersion("a", oldVersion, []byte("stale"))
+	assert.Nil(t, err)
+	assert.False(t, ok, "version of the overwritten value should not be valid")
+	assert.Nil(t, tree.Close())
+
+	// Versions of ingested keys are kept when the tree is reopened, and
+	// writes after it continue after them
+	tree, err = lsmtree.NewLsmTree(dir, options)
+	assert.Nil(t, err)
+	defer tree.Close()
+	_, reopened, _, err := tree.GetWithVersion("a")
+	assert.Nil(t, err)
+	assert.Equal(t, version, reopened)
+	assert.Nil(t, tree.Set("c", []byte("c")))
+	_, newer, _, err := tree.GetWithVersion("c")
+	assert.Nil(t, err)
+	assert.Greater(t, newer, version)
+}
+
+func TestWriterWithComparator(t *T) {
+	options := lsmtree.DefaultOptions()
+	options.MergeInterval = 0
+	options.Comparator = ReverseBytewiseComparator
+	tree, err := lsmtree.NewLsmTree(t.TempDir(), options)
+	assert.Nil(t, err)
+	defer tree.Close()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, tree.Set(k, []byte("old-"+k)))
+	}
+
+	w, err := NewWriterWithOptions(filepath.Join(t.TempDir(), "table"), 2, WriterOptions{Comparator: ReverseBytewiseComparator})
+	assert.Nil(t, err)
+	assert.NotNil(t, w.DeleteRange([]byte("a"), []byte("c")), "range should be in the order of the comparator")
+	assert.Nil(t, w.Put([]byte("d"), []byte("ingested-d")))
+	assert.Nil(t, w.DeleteRange([]byte("c"), []byte("a")))
+	assert.NotNil(t, w.Put([]byte("e"), []byte("e")), "keys should be in the order of the comparator")
+	assert.Nil(t, w.Finish())
+	assert.Nil(t, tree.IngestExternalFiles([]string{w.Path()}))
+
+	for k, expected := range map[string]string{"a": "old-a", "d": "ingested-d"} {
+		data, exists, err := tree.Get(k)
+		assert.Nil(t, err)
+		assert.True(t, exists, k)
+		assert.Equal(t, expected, string(data))
+	}
+	for _, k := range []string{"b", "c"} {
+		_, exists, err := tree.Get(k)
+		assert.Nil(t, err)
+		assert.False(t, exists, "%v should be deleted", k)
+	}
+}
+
+func TestWriterWithBinaryKeys(t *T) {
+	tree := newTree(t)
+	w, err := NewWriter(filepath.Join(t.TempDir(), "table"), 2)
+	assert.Nil(t, err)
+	keys := [][]byte{{0x00, 0xff}, {0x80}, {0xff, 0x00}}
+	for _, k := range keys {
+		assert.Nil(t, w.Put(k, k))
+	}
+	assert.Nil(t, w.Finish())
+	assert.Nil(t, tree.IngestExternalFiles([]string{w.Path()}))
+
+	for _, k := range keys {
+		data, exists, err := tree.Get(string(k))
+		assert.Nil(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, k, data)
+	}
+}
+
+// Orders keys by length, then by their bytes
+type lengthComparator struct{}
+
+func (lengthComparator) Compare(a string, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+func (lengthComparator) Name() string {
+	return "length"
+}
+
+func TestWriterWithCustomComparator(t *T) {
+	var cmp Comparator = lengthComparator{}
+	options := lsmtree.DefaultOptions()
+	options.MergeInterval = 0
+	options.Comparator = cmp
+	tree, err := lsmtree.NewLsmTree(t.TempDir(), options)
+	assert.Nil(t, err)
+	defer tree.Close()
+
+	w, err := NewWriterWithOptions(filepath.Join(t.TempDir(), "table"), 3, WriterOptions{Comparator: cmp})
+	assert.Nil(t, err)
+	for _, k := range []string{"z", "aa", "bbb"} {
+		assert.Nil(t, w.Put([]byte(k), []byte("ingested-"+k)))
+	}
+	assert.NotNil(t, w.Put([]byte("c"), []byte("c")), "keys should be in the order of the comparator")
+	assert.Nil(t, w.Finish())
+	assert.Nil(t, tree.IngestExternalFiles([]string{w.Path()}))
+
+	it := tree.NewIterator("", "")
+	defer it.Close()
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.Nil(t, it.Err())
+	assert.Equal(t, []string{"z", "aa", "bbb"}, keys)
+}